@@ -1,13 +1,23 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+	_ "time/tzdata" // Embed the time zone database for --tz on systems without one
 
+	"github.com/funnyzak/screenshot-cli/internal/animate"
 	"github.com/funnyzak/screenshot-cli/internal/batch"
 	"github.com/funnyzak/screenshot-cli/internal/capture"
 	"github.com/funnyzak/screenshot-cli/internal/config"
+	"github.com/funnyzak/screenshot-cli/internal/formats"
 	"github.com/funnyzak/screenshot-cli/internal/output"
+	"github.com/funnyzak/screenshot-cli/internal/resize"
 
 	"github.com/spf13/cobra"
 )
@@ -28,15 +38,33 @@ EXAMPLES:
   sshot                                    # Full screen screenshot
   sshot -o desktop.png                     # Save with custom filename
   sshot -r "100,100,800,600" -o region.png # Region screenshot
+  sshot --scale 0.5                        # Half size, e.g. for HiDPI screens
+  sshot --fit 1920x1080 -f jpg             # Shrink to fit Full HD
   
   # Output control
   sshot -f jpg -q 80 -o screen.jpg         # JPEG with quality control
+  sshot -o screen.webp --lossless          # Lossless WebP
+  sshot -o ui.png --png-palette --verbose  # Paletted PNG for flat UIs, report savings
+  sshot -o ui.gif --colors 64 --dither none # 64-color GIF without dithering noise
+  sshot -o ticket.jpg --max-size 900KB     # Highest quality that fits an upload limit
   sshot -c                                 # Copy to clipboard only
+  sshot -o - | convert - -resize 50% half.png # Pipe the image to another tool
+  sshot -r "0,0,400,300" --data-url        # Print a data: URL for HTML or Markdown
   sshot -t "screenshot_{datetime}.png"     # Use filename template
+  sshot -o shot.png --if-exists suffix     # Never overwrite: shot-1.png, shot-2.png, ...
   
   # Batch processing
   sshot -n 10 -i 3 -p "batch"              # 10 screenshots every 3 seconds
   sshot -n 5 -i 2 -d "./screenshots"       # Save to custom directory
+  sshot -n 100 -d "./shots" --resume       # Continue numbering after existing files
+  sshot -n 60 -i 10 --dedupe phash         # Skip frames identical to earlier ones
+  sshot -n 30 -i 1 --animate gif           # One animated GIF instead of 30 files
+  sshot -n 1440 -i 60 --animate avi --fps 24 # A day as a one-minute MJPEG time-lapse
+  sshot -n 20 -i 30 --animate pdf --pdf-caption # One captioned PDF page per shot
+  sshot -n 10 -d "./shots" --thumbnail 320x # Gallery previews: shot_001.thumb.jpg, ...
+  sshot -n 10 -d "./shots" --manifest jsonl # Record every capture in ./shots/manifest.jsonl
+  sshot -n 60 -i 60 --on-error retry       # Retry failed captures instead of aborting
+  sshot -n 1000 -i 60 -d "./monitoring" --keep-last 500  # Rotate old screenshots
   # If no template is provided, filenames will be auto-numbered: screenshot_001.png, screenshot_002.png, ...
   
   # Multi-display support
@@ -45,22 +73,33 @@ EXAMPLES:
   
   # Advanced templates
   sshot -t "screen_{date}_{time}_{counter}.png" -n 5
+  sshot -t "{hostname}_{date:2006-01-02}_{counter:05}.png" -n 5
   sshot -d "./captures/{date}" -t "{time}_{random}.png"
 
 TEMPLATE VARIABLES:
-  {timestamp}  - Unix timestamp
+  {timestamp}  - Unix timestamp ({timestamp:ms}, :us, :ns for finer units)
   {datetime}   - Date and time (YYYYMMDD_HHMMSS)
   {date}       - Date only (YYYYMMDD)
   {time}       - Time only (HHMMSS)
-  {counter}    - Sequence number
-  {random}     - Random string
+  {counter}    - Sequence number ({counter:05} for five digits)
+  {random}     - Random string ({random:10} for ten characters)
   {prefix}     - Filename prefix
+  {display}    - Display index
+  {width}      - Image width in pixels
+  {height}     - Image height in pixels
+  {format}     - Output format (png, jpg, ...)
+  {hostname}   - Name of this machine
+  {name}       - Screenshot name without extension (thumbnail templates only)
+  {user}       - Current user name
+  {env:NAME}   - Value of environment variable NAME
+  {uuid}       - Random UUID
+  Date and time variables accept a Go layout, e.g. {date:2006-01-02} or {time:15-04-05.000}.
+  All variables of a file name use the same capture instant, in the --tz time zone.
+  Use {{ and }} for literal braces; unknown variables are reported as errors.
 
 SUPPORTED FORMATS:
-  png  - Portable Network Graphics (default)
-  jpg  - JPEG with quality control
-  bmp  - Bitmap
-  gif  - Graphics Interchange Format`,
+` + formats.Help(config.DefaultFormat) + `
+  Without -f the format follows the extension of -o or -t (shot.jpg writes JPEG).`,
 		Version: version,
 		RunE:    runScreenshot,
 		Example: `  # Quick full screen capture
@@ -87,20 +126,60 @@ SUPPORTED FORMATS:
 
 	// Screenshot flags
 	rootCmd.Flags().StringP("region", "r", "", "Capture specific region \"x,y,width,height\" (e.g., \"100,100,800,600\")")
-	rootCmd.Flags().StringP("output", "o", "screenshot.png", "Output file path (use \"\" for clipboard only)")
+	rootCmd.Flags().StringP("output", "o", "screenshot.png", "Output file path (use \"\" for clipboard only, - for stdout)")
 	rootCmd.Flags().Int("display", 0, "Display index to capture (0=primary, 1=secondary, etc.)")
+	addResizeFlags(rootCmd)
 
 	// Output control flags
-	rootCmd.Flags().StringP("format", "f", "png", "Output format: png, jpg, bmp, or gif")
-	rootCmd.Flags().IntP("quality", "q", 90, "JPEG compression quality (1-100, higher=better quality)")
+	rootCmd.Flags().StringP("format", "f", config.DefaultFormat, "Output format: "+listNames(formats.Names())+" (default: from the output extension, else "+config.DefaultFormat+")")
+	rootCmd.Flags().Bool("force-format", false, "Write the -f format even if it contradicts the output file extension")
+	rootCmd.Flags().IntP("quality", "q", 90, "Compression quality for "+listNames(formats.QualityNames())+" (1-100, higher=better quality)")
+	rootCmd.Flags().Bool("lossless", false, "Use lossless compression for formats that support it ("+listNames(formats.NamesWithOption("lossless"))+")")
+	rootCmd.Flags().String("tiff-compression", "deflate", "TIFF compression: none, deflate, or lzw")
+	rootCmd.Flags().String("png-compression", "default", "PNG compression level: none, fast, default, or best")
+	rootCmd.Flags().Bool("png-palette", false, "Write PNGs with 256 or fewer colors as paletted images (savings shown with --verbose)")
+	rootCmd.Flags().Int("colors", 256, "Palette size for "+listNames(formats.NamesWithOption("colors"))+" (2-256); with --png-palette, PNGs are quantized to it")
+	rootCmd.Flags().String("dither", formats.DefaultDither, "Dithering for paletted output: none or floyd-steinberg")
+	rootCmd.Flags().String("pdf-compression", formats.DefaultPDFCompression, "Compression of PDF page images: jpeg (uses --quality) or flate (lossless)")
+	rootCmd.Flags().Bool("pdf-caption", false, "Add a caption line with time, display and hostname below each PDF page")
+	rootCmd.Flags().Bool("base64", false, "Write the image to stdout as base64 text")
+	rootCmd.Flags().Bool("data-url", false, "Write the image to stdout as a data: URL for HTML, Markdown or JSON")
+	rootCmd.Flags().Bool("force-stdout", false, "Write binary image data with -o - even if stdout is a terminal")
+	rootCmd.Flags().String("max-size", "", "Largest file size (e.g., \"900KB\"): lowers the quality of "+listNames(formats.QualityNames())+" down to 40, then downscales until the file fits")
+	rootCmd.Flags().String("thumbnail", "", "Also save a thumbnail fitting within WxH next to each screenshot (e.g., \"320x\", \"320x200\")")
+	rootCmd.Flags().String("thumbnail-template", config.DefaultThumbnailTemplate, "Thumbnail path template, relative to the screenshot; {name} is the screenshot name without extension")
+	rootCmd.Flags().String("thumbnail-format", "", "Thumbnail format (default: from the thumbnail template extension)")
+	rootCmd.Flags().Int("thumbnail-quality", 80, "Compression quality of thumbnails (1-100)")
 	rootCmd.Flags().BoolP("clipboard", "c", false, "Copy screenshot to clipboard")
 	rootCmd.Flags().StringP("template", "t", "", "Filename template with variables (e.g., \"{datetime}_{counter}.png\")")
+	rootCmd.Flags().String("tz", "Local", "Time zone for date and time template variables: UTC, Local, or an IANA name (e.g., \"Europe/Berlin\")")
+	rootCmd.Flags().String("if-exists", "overwrite", "What to do when the output file exists: overwrite, skip, error, or suffix (name-1.png)")
+	rootCmd.Flags().Bool("fsync", false, "Flush each file to disk before it is renamed into place")
 
 	// Batch processing flags
 	rootCmd.Flags().IntP("count", "n", 1, "Number of screenshots to capture (use >1 for batch mode)")
 	rootCmd.Flags().IntP("interval", "i", 1, "Interval between screenshots in seconds")
 	rootCmd.Flags().StringP("prefix", "p", "shot", "Filename prefix for batch processing")
-	rootCmd.Flags().StringP("directory", "d", ".", "Output directory for screenshots")
+	rootCmd.Flags().StringP("directory", "d", ".", "Output directory for screenshots, may contain template variables (e.g., \"./shots/{date}\")")
+	rootCmd.Flags().Bool("resume", false, "Continue numbering after the highest counter already in the output directory")
+	rootCmd.Flags().String("animate", "none", "Collect the batch into one file instead of separate files: "+listNames(append([]string{"none"}, animate.Names()...)))
+	rootCmd.Flags().Int("loop", 0, "Number of times the animation plays (0=forever)")
+	rootCmd.Flags().String("palette", "frame", "Palette of animated GIFs: frame (adaptive per frame) or shared (from the first frame)")
+	rootCmd.Flags().Int("fps", animate.DefaultFPS, "Frame rate of --animate avi videos, each capture is one frame")
+
+	// Deduplication flags
+	rootCmd.Flags().String("dedupe", "none", "Skip near-duplicate frames in batch mode: none or phash")
+	rootCmd.Flags().Int("dedupe-threshold", 5, "Maximum perceptual hash distance (0-64) treated as a duplicate")
+	rootCmd.Flags().Bool("dedupe-index", false, "Persist the perceptual hash index in the output directory across runs")
+	rootCmd.Flags().String("manifest", "none", "Write a per-run manifest to the output directory: none, jsonl, or csv")
+
+	// Error handling flags
+	rootCmd.Flags().String("on-error", "abort", "Batch error policy: abort, continue, or retry")
+	rootCmd.Flags().Int("retries", 3, "Number of retries per screenshot with --on-error retry")
+	rootCmd.Flags().Duration("retry-backoff", 500*time.Millisecond, "Delay before the first retry, doubled on each further attempt")
+
+	// Retention flags
+	addRetentionFlags(rootCmd)
 
 	// Add subcommands
 	var infoCmd = &cobra.Command{
@@ -113,6 +192,46 @@ SUPPORTED FORMATS:
 	}
 	rootCmd.AddCommand(infoCmd)
 
+	var pruneCmd = &cobra.Command{
+		Use:   "prune [directory]",
+		Short: "Delete old screenshots from an output directory",
+		Long:  `Apply retention rules to an existing output directory. Only screenshots recorded in the directory's manifest (manifest.jsonl or manifest.csv) are considered, oldest first, so files that were not created by sshot are never deleted.`,
+		Example: `  sshot prune ./monitoring --keep-last 500      # Keep the newest 500 screenshots
+  sshot prune ./monitoring --max-age 7d          # Delete screenshots older than a week
+  sshot prune ./monitoring --max-bytes 2GB --dry-run`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: runPrune,
+	}
+	addRetentionFlags(pruneCmd)
+	pruneCmd.Flags().Bool("dry-run", false, "List the files that would be deleted without deleting them")
+	rootCmd.AddCommand(pruneCmd)
+
+	var templateCmd = &cobra.Command{
+		Use:   "template [filename_template]",
+		Short: "Preview and validate filename and directory templates",
+		Long:  `Expand a filename template (and the --directory template) for every screenshot of a run with the given flags, assuming captures happen exactly every --interval seconds. Reports paths written more than once, templates that would overwrite files in batch mode, and characters that are not allowed on the target operating system.`,
+		Example: `  sshot template "{prefix}_{counter}.png" -n 5       # Preview five file names
+  sshot template "{time}.png" -d "./shots/{date}" -n 100 -i 60
+  sshot template "shot_{date}.png" -n 10 --os windows`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: runTemplate,
+	}
+	templateCmd.Flags().StringP("template", "t", "", "Filename template (alternative to the argument)")
+	templateCmd.Flags().StringP("output", "o", "screenshot.png", "Output file path used when no template is given")
+	templateCmd.Flags().StringP("format", "f", config.DefaultFormat, "Output format (default: from the template extension, else "+config.DefaultFormat+")")
+	templateCmd.Flags().Bool("force-format", false, "Allow a format that contradicts the template extension")
+	templateCmd.Flags().Int("display", 0, "Display index used for {display}, {width} and {height}")
+	templateCmd.Flags().StringP("region", "r", "", "Capture region used for {width} and {height}")
+	addResizeFlags(templateCmd)
+	templateCmd.Flags().IntP("count", "n", 1, "Number of screenshots in the run")
+	templateCmd.Flags().IntP("interval", "i", 1, "Interval between screenshots in seconds")
+	templateCmd.Flags().StringP("prefix", "p", "shot", "Filename prefix")
+	templateCmd.Flags().StringP("directory", "d", ".", "Output directory template")
+	templateCmd.Flags().String("tz", "Local", "Time zone for date and time variables")
+	templateCmd.Flags().Int("limit", 10, "Maximum number of paths to print (0=all)")
+	templateCmd.Flags().String("os", runtime.GOOS, "Target operating system for path validation: windows, darwin, or linux")
+	rootCmd.AddCommand(templateCmd)
+
 	// Add usage tips
 	rootCmd.SetHelpTemplate(`{{with (or .Long .Short)}}{{. | trimTrailingWhitespaces}}
 
@@ -136,8 +255,30 @@ For more examples, see: https://github.com/funnyzak/screenshot-cli`)
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
+	}
+}
+
+// listNames joins names for help text, e.g. "png, jpg, or gif"
+func listNames(names []string) string {
+	switch len(names) {
+	case 0:
+		return ""
+	case 1:
+		return names[0]
+	case 2:
+		return names[0] + " or " + names[1]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + ", or " + names[len(names)-1]
+}
+
+// exitCode maps an error returned by a command to the process exit code
+func exitCode(err error) int {
+	var failureErr *batch.FailureError
+	if errors.As(err, &failureErr) {
+		return config.ExitBatchFailure
 	}
+	return config.ExitArgsError
 }
 
 func runScreenshot(cmd *cobra.Command, args []string) error {
@@ -148,7 +289,7 @@ func runScreenshot(cmd *cobra.Command, args []string) error {
 	}
 
 	if verbose {
-		fmt.Printf("Configuration: %+v\n", config)
+		fmt.Fprintf(statusWriter(config), "Configuration: %+v\n", config)
 	}
 
 	// Handle batch processing
@@ -162,10 +303,21 @@ func runScreenshot(cmd *cobra.Command, args []string) error {
 
 func captureSingleScreenshot(config *config.Config) error {
 	// Capture screenshot
-	img, err := capture.CaptureScreen(config)
+	img, capturedAt, err := capture.CaptureScreenWithTime(config)
 	if err != nil {
 		return fmt.Errorf("failed to capture screenshot: %w", err)
 	}
+	config.CaptureTime = capturedAt
+	status := statusWriter(config)
+
+	// Scale the capture before it is copied or saved
+	if resized := resize.Apply(img, config.Resize()); resized != img {
+		if verbose {
+			fmt.Fprintf(status, "Resized %dx%d to %dx%d (%s)\n", img.Bounds().Dx(), img.Bounds().Dy(),
+				resized.Bounds().Dx(), resized.Bounds().Dy(), config.Interpolation)
+		}
+		img = resized
+	}
 
 	// Process output
 	if config.Clipboard {
@@ -175,26 +327,166 @@ func captureSingleScreenshot(config *config.Config) error {
 
 		// Provide user feedback
 		if output.IsImageClipboardSupported() {
-			fmt.Println("✓ Screenshot copied to clipboard")
+			fmt.Fprintln(status, "✓ Screenshot copied to clipboard")
 		} else {
-			fmt.Println("⚠ Screenshot copied to clipboard (as data URL)")
-			fmt.Println("   Note: Some applications may not support image data URLs")
+			fmt.Fprintln(status, "⚠ Screenshot copied to clipboard (as data URL)")
+			fmt.Fprintln(status, "   Note: Some applications may not support image data URLs")
 		}
 	}
 
 	// Save to file if output path is specified
 	if config.OutputPath != "" {
-		if err := output.SaveToFile(img, config); err != nil {
+		result, err := output.SaveToFileWithResult(img, config)
+		if err != nil {
 			return fmt.Errorf("failed to save file: %w", err)
 		}
-		if verbose {
-			fmt.Printf("Screenshot saved to: %s\n", config.OutputPath)
+		if config.MaxSize > 0 && !result.Skipped {
+			if result.Quality > 0 {
+				fmt.Fprintf(status, "Fitted within %d bytes: %dx%d at quality %d (%d bytes)\n",
+					config.MaxSize, result.Width, result.Height, result.Quality, result.Size)
+			} else {
+				fmt.Fprintf(status, "Fitted within %d bytes: %dx%d (%d bytes)\n",
+					config.MaxSize, result.Width, result.Height, result.Size)
+			}
+		}
+		if result.Skipped {
+			fmt.Fprintf(status, "⚠ %s already exists, screenshot not saved\n", result.Path)
+		} else if verbose {
+			for _, note := range result.Notes {
+				fmt.Fprintln(status, note)
+			}
+			if config.WritesToStdout() {
+				fmt.Fprintf(status, "Screenshot written to stdout (%d bytes)\n", result.Size)
+			} else {
+				fmt.Fprintf(status, "Screenshot saved to: %s\n", result.Path)
+			}
+		}
+
+		if config.Thumbnail() && !result.Skipped {
+			thumb, err := output.SaveThumbnail(img, result.Path, config, config.NewTemplateProcessor())
+			if err != nil {
+				return fmt.Errorf("failed to save thumbnail: %w", err)
+			}
+			if thumb.Skipped {
+				fmt.Fprintf(status, "⚠ %s already exists, thumbnail not saved\n", thumb.Path)
+			} else if verbose {
+				fmt.Fprintf(status, "Thumbnail saved to: %s\n", thumb.Path)
+			}
 		}
 	}
 
 	return nil
 }
 
+// statusWriter returns where messages go: stdout, unless the image does
+func statusWriter(config *config.Config) io.Writer {
+	if config.WritesToStdout() {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+func runPrune(cmd *cobra.Command, args []string) error {
+	config, err := config.ParsePruneArgs(cmd, args)
+	if err != nil {
+		return fmt.Errorf("failed to parse arguments: %w", err)
+	}
+
+	deleted, err := batch.Prune(config.Dir, batch.NewRetentionPolicy(config), time.Now(), config.DryRun)
+	for _, path := range deleted {
+		if config.DryRun {
+			fmt.Printf("Would delete: %s\n", path)
+		} else {
+			fmt.Printf("Deleted: %s\n", path)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Prune completed: %d files\n", len(deleted))
+	return nil
+}
+
+func runTemplate(cmd *cobra.Command, args []string) error {
+	config, err := config.ParseTemplateArgs(cmd, args)
+	if err != nil {
+		return fmt.Errorf("failed to parse arguments: %w", err)
+	}
+
+	limit, _ := cmd.Flags().GetInt("limit")
+	targetOS, _ := cmd.Flags().GetString("os")
+
+	preview, err := batch.PreviewPaths(config, time.Now())
+	if err != nil {
+		return fmt.Errorf("invalid template: %w", err)
+	}
+
+	for i, path := range preview.Paths {
+		if limit > 0 && i >= limit {
+			fmt.Printf("  ... %d more\n", len(preview.Paths)-limit)
+			break
+		}
+		fmt.Printf("%4d  %s\n", i+1, path)
+	}
+	fmt.Println()
+
+	// Validate every distinct path for the target OS
+	var invalid []string
+	checked := make(map[string]bool, len(preview.Paths))
+	for _, path := range preview.Paths {
+		if checked[path] {
+			continue
+		}
+		checked[path] = true
+		if err := output.ValidatePathChars(path, targetOS); err != nil {
+			invalid = append(invalid, fmt.Sprintf("%s: %v", path, err))
+		}
+	}
+
+	collisions := make([]string, 0, len(preview.Collisions))
+	for path := range preview.Collisions {
+		collisions = append(collisions, path)
+	}
+	sort.Strings(collisions)
+
+	for _, path := range collisions {
+		fmt.Printf("⚠ %s is written by screenshots %v\n", path, preview.Collisions[path])
+	}
+	for _, warning := range preview.Warnings {
+		fmt.Printf("⚠ %s\n", warning)
+	}
+	for _, problem := range invalid {
+		fmt.Printf("✗ %s\n", problem)
+	}
+
+	if len(invalid) > 0 {
+		return fmt.Errorf("%d path(s) are not valid on %s", len(invalid), targetOS)
+	}
+	if len(collisions) == 0 && len(preview.Warnings) == 0 {
+		fmt.Printf("✓ %d unique paths, valid on %s\n", len(preview.Paths), targetOS)
+	}
+
+	return nil
+}
+
+// addRetentionFlags registers the retention flags shared by batch mode and prune
+func addRetentionFlags(cmd *cobra.Command) {
+	cmd.Flags().Int("keep-last", 0, "Keep only the newest N screenshots in the output directory (0=unlimited)")
+	cmd.Flags().String("max-age", "", "Delete screenshots older than this age (e.g., \"36h\", \"7d\", \"2w\")")
+	cmd.Flags().String("max-bytes", "", "Delete the oldest screenshots once the directory exceeds this size (e.g., \"500MB\", \"2GB\")")
+}
+
+// addResizeFlags registers the resize flags shared by captures and the
+// template preview, where they determine {width} and {height}
+func addResizeFlags(cmd *cobra.Command) {
+	cmd.Flags().Float64("scale", 1, "Scale captures by this factor before saving (e.g., 0.5 for HiDPI screens)")
+	cmd.Flags().Int("max-width", 0, "Shrink captures wider than this many pixels, keeping the aspect ratio (0=unlimited)")
+	cmd.Flags().Int("max-height", 0, "Shrink captures taller than this many pixels, keeping the aspect ratio (0=unlimited)")
+	cmd.Flags().String("fit", "", "Shrink captures to fit within WxH, keeping the aspect ratio (e.g., \"1920x1080\")")
+	cmd.Flags().String("interpolation", resize.DefaultInterpolation, "Resize interpolation: nearest, bilinear, or catmull-rom")
+}
+
 func runInfo(cmd *cobra.Command, args []string) error {
 	// Check platform support
 	if !capture.IsPlatformSupported() {
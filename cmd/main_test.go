@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/funnyzak/screenshot-cli/internal/batch"
+	"github.com/funnyzak/screenshot-cli/internal/config"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"batch failure", &batch.FailureError{Failed: 1, Total: 3}, config.ExitBatchFailure},
+		{"wrapped batch failure", fmt.Errorf("run: %w", &batch.FailureError{Failed: 1, Total: 3}), config.ExitBatchFailure},
+		{"other error", errors.New("failed to parse arguments"), config.ExitArgsError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("exitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+// Package animate assembles the frames of a batch run into a single animated
+// file. Encoders write each frame as it is added, so long runs never hold
+// more than a frame or two in memory. In animated images each frame is shown
+// until the next one was captured; videos show one capture per frame.
+//
+// Encoders buffer their output in a bufio.Writer and do not check each write:
+// its errors are sticky and surface from the Flush after each frame or file.
+package animate
+
+import (
+	"image"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/funnyzak/screenshot-cli/internal/formats"
+)
+
+// Encoder writes an animation frame by frame
+type Encoder interface {
+	// AddFrame appends a frame captured at the given time. Encoders may
+	// merge a frame identical to the previous one into its display time.
+	AddFrame(img image.Image, capturedAt time.Time) error
+	// Close completes the file, which then holds all frames added so far
+	Close() error
+}
+
+// Palette modes of paletted animations
+const (
+	PaletteFrame  = "frame"  // An adaptive palette per frame
+	PaletteShared = "shared" // The palette of the first frame for all frames
+)
+
+// IsPalette reports whether name is a supported palette mode
+func IsPalette(name string) bool {
+	switch strings.ToLower(name) {
+	case PaletteFrame, PaletteShared:
+		return true
+	}
+	return false
+}
+
+// Options configure an animation encoder
+type Options struct {
+	formats.Options // Settings of the frame encoding, e.g. Colors and Dither
+
+	Loop       int           // Number of times the animation plays, 0 forever
+	Palette    string        // Palette mode of paletted animations
+	FinalDelay time.Duration // Display time of the last frame
+	FPS        int           // Frame rate of videos, which show one capture per frame
+
+	// Caption, if set, returns the caption of a frame captured at the given
+	// time for documents
+	Caption func(capturedAt time.Time) string
+}
+
+// Kind describes a registered animation format
+type Kind struct {
+	Name        string                                       // Name given to --animate, e.g. "gif"
+	Extensions  []string                                     // File extensions with the leading dot; the first is written
+	Description string                                       // Short description for help text
+	New         func(w io.WriteSeeker, opts Options) Encoder // Headers may be patched on Close
+}
+
+// Extension returns the file extension written for the kind
+func (k *Kind) Extension() string {
+	return k.Extensions[0]
+}
+
+// HasExtension reports whether ext, which includes the leading dot, is one of
+// the extensions of the kind, ignoring case
+func (k *Kind) HasExtension(ext string) bool {
+	ext = strings.ToLower(ext)
+	for _, e := range k.Extensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+var kinds []*Kind
+
+// Register adds an animation format to the registry
+func Register(k *Kind) {
+	kinds = append(kinds, k)
+}
+
+// Lookup returns the animation format with the given name, ignoring case
+func Lookup(name string) (*Kind, bool) {
+	name = strings.ToLower(name)
+	for _, k := range kinds {
+		if k.Name == name {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+// Names returns the names of all animation formats
+func Names() []string {
+	names := make([]string, len(kinds))
+	for i, k := range kinds {
+		names[i] = k.Name
+	}
+	return names
+}
+
+// changedRect returns the smallest rectangle holding every pixel that differs
+// between prev and cur, which have the same size and origin (0, 0). It is
+// empty if the frames are identical.
+func changedRect(prev, cur *image.NRGBA) image.Rectangle {
+	var r image.Rectangle
+	width, height := cur.Rect.Dx(), cur.Rect.Dy()
+	for y := 0; y < height; y++ {
+		a := prev.Pix[y*prev.Stride : y*prev.Stride+4*width]
+		b := cur.Pix[y*cur.Stride : y*cur.Stride+4*width]
+		left := 0
+		for left < len(a) && a[left] == b[left] {
+			left++
+		}
+		if left == len(a) {
+			continue
+		}
+		right := len(a) - 1
+		for a[right] == b[right] {
+			right--
+		}
+		r = r.Union(image.Rect(left/4, y, right/4+1, y+1))
+	}
+	return r
+}
@@ -0,0 +1,348 @@
+package animate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+	"time"
+)
+
+// testFrames returns a flat background with a moving square, a repeated
+// frame and a frame where nothing but one pixel changed
+func testFrames() []*image.NRGBA {
+	background := color.NRGBA{R: 30, G: 60, B: 90, A: 255}
+	square := color.NRGBA{R: 250, G: 200, B: 10, A: 255}
+
+	frame := func(x int) *image.NRGBA {
+		m := image.NewNRGBA(image.Rect(0, 0, 64, 40))
+		draw.Draw(m, m.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+		draw.Draw(m, image.Rect(x, 10, x+8, 18), image.NewUniform(square), image.Point{}, draw.Src)
+		return m
+	}
+
+	last := frame(20)
+	last.SetNRGBA(63, 39, square)
+	return []*image.NRGBA{frame(4), frame(20), frame(20), last}
+}
+
+func TestChangedRect(t *testing.T) {
+	frames := testFrames()
+	tests := []struct {
+		name      string
+		prev, cur *image.NRGBA
+		want      image.Rectangle
+	}{
+		{"moved square", frames[0], frames[1], image.Rect(4, 10, 28, 18)},
+		{"identical", frames[1], frames[2], image.Rectangle{}},
+		{"one pixel", frames[2], frames[3], image.Rect(63, 39, 64, 40)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := changedRect(tt.prev, tt.cur); got != tt.want {
+				t.Errorf("changedRect() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGIF(t *testing.T) {
+	for _, palette := range []string{PaletteFrame, PaletteShared} {
+		t.Run(palette, func(t *testing.T) {
+			kind, ok := Lookup("gif")
+			if !ok {
+				t.Fatal("gif is not registered")
+			}
+
+			frames := testFrames()
+			data := encodeFrames(t, kind, frames, Options{Loop: 3, Palette: palette, FinalDelay: 2 * time.Second})
+			decoded, err := gif.DecodeAll(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("gif.DecodeAll() error = %v", err)
+			}
+			// The repeated frame extends the display time of the one before
+			if got, want := decoded.Delay, []int{50, 75, 200}; !equalInts(got, want) {
+				t.Errorf("delays = %v, want %v", got, want)
+			}
+			if decoded.LoopCount != 2 {
+				t.Errorf("loop count = %d, want 2", decoded.LoopCount)
+			}
+			if got := decoded.Image[1].Rect; got != image.Rect(4, 10, 28, 18) {
+				t.Errorf("second frame rect = %v, want only the changed area", got)
+			}
+
+			// Compositing the frames reproduces the captures exactly
+			canvas := image.NewNRGBA(frames[0].Rect)
+			for i, want := range []*image.NRGBA{frames[0], frames[1], frames[3]} {
+				draw.Draw(canvas, decoded.Image[i].Rect, decoded.Image[i], decoded.Image[i].Rect.Min, draw.Over)
+				if !bytes.Equal(canvas.Pix, want.Pix) {
+					t.Fatalf("frame %d differs from the capture", i)
+				}
+			}
+		})
+	}
+}
+
+func TestAPNG(t *testing.T) {
+	kind, ok := Lookup("apng")
+	if !ok {
+		t.Fatal("apng is not registered")
+	}
+	frames := testFrames()
+	data := encodeFrames(t, kind, frames, Options{Loop: 2, FinalDelay: 90 * time.Second})
+
+	// The default image is the first frame for viewers without APNG support
+	first, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("png.Decode() error = %v", err)
+	}
+	canvas := image.NewNRGBA(frames[0].Rect)
+	draw.Draw(canvas, canvas.Rect, first, image.Point{}, draw.Src)
+	if !bytes.Equal(canvas.Pix, frames[0].Pix) {
+		t.Fatal("default image differs from the first frame")
+	}
+
+	var actl []byte
+	var controls [][]byte
+	var frameData [][]byte
+	sequence := uint32(0)
+	for p := 8; p < len(data); {
+		length := int(binary.BigEndian.Uint32(data[p:]))
+		name := string(data[p+4 : p+8])
+		chunk := data[p+8 : p+8+length]
+		if crc32.ChecksumIEEE(data[p+4:p+8+length]) != binary.BigEndian.Uint32(data[p+8+length:]) {
+			t.Fatalf("%s chunk has a bad CRC", name)
+		}
+		p += 12 + length
+
+		switch name {
+		case "acTL":
+			actl = chunk
+		case "fcTL", "fdAT":
+			if seq := binary.BigEndian.Uint32(chunk); seq != sequence {
+				t.Fatalf("%s sequence number = %d, want %d", name, seq, sequence)
+			}
+			sequence++
+			if name == "fcTL" {
+				controls = append(controls, chunk)
+				frameData = append(frameData, nil)
+			} else {
+				frameData[len(frameData)-1] = append(frameData[len(frameData)-1], chunk[4:]...)
+			}
+		}
+	}
+
+	if got := binary.BigEndian.Uint32(actl); got != 3 {
+		t.Errorf("acTL frames = %d, want 3", got)
+	}
+	if got := binary.BigEndian.Uint32(actl[4:]); got != 2 {
+		t.Errorf("acTL plays = %d, want 2", got)
+	}
+
+	// Delays as fractions of a second; the repeated frame extends the second
+	wantDelays := [][2]uint16{{500, 1000}, {750, 1000}, {9000, 100}}
+	for i, want := range []*image.NRGBA{frames[0], frames[1], frames[3]} {
+		fctl := controls[i]
+		rect := image.Rect(0, 0, int(binary.BigEndian.Uint32(fctl[4:])), int(binary.BigEndian.Uint32(fctl[8:]))).
+			Add(image.Pt(int(binary.BigEndian.Uint32(fctl[12:])), int(binary.BigEndian.Uint32(fctl[16:]))))
+		if delay := [2]uint16{binary.BigEndian.Uint16(fctl[20:]), binary.BigEndian.Uint16(fctl[22:])}; delay != wantDelays[i] {
+			t.Errorf("frame %d delay = %v, want %v", i, delay, wantDelays[i])
+		}
+		if i == 1 && rect != image.Rect(4, 10, 28, 18) {
+			t.Errorf("second frame rect = %v, want only the changed area", rect)
+		}
+		if i > 0 {
+			sub := decodeFrame(t, data, rect, frameData[i])
+			draw.Draw(canvas, rect, sub, image.Point{}, draw.Src)
+		}
+		if !bytes.Equal(canvas.Pix, want.Pix) {
+			t.Fatalf("frame %d differs from the capture", i)
+		}
+	}
+}
+
+func TestAVI(t *testing.T) {
+	kind, ok := Lookup("avi")
+	if !ok {
+		t.Fatal("avi is not registered")
+	}
+	frames := testFrames()
+	data := encodeFrames(t, kind, frames, Options{FPS: 25})
+	le := binary.LittleEndian
+
+	if string(data[0:4]) != "RIFF" || string(data[8:12]) != "AVI " {
+		t.Fatalf("missing RIFF AVI header: %q", data[:12])
+	}
+	if got := int(le.Uint32(data[4:])); got != len(data)-8 {
+		t.Errorf("RIFF size = %d, want %d", got, len(data)-8)
+	}
+	if got := le.Uint32(data[32:]); got != 40000 {
+		t.Errorf("microseconds per frame = %d, want 40000", got)
+	}
+	// Videos keep every capture, including the repeated one
+	if got := le.Uint32(data[48:]); got != 4 {
+		t.Errorf("total frames = %d, want 4", got)
+	}
+	if w, h := le.Uint32(data[64:]), le.Uint32(data[68:]); w != 64 || h != 40 {
+		t.Errorf("size = %dx%d, want 64x40", w, h)
+	}
+
+	moviSize := int(le.Uint32(data[216:]))
+	idx := aviHeaderSize - 4 + moviSize
+	if string(data[idx:idx+4]) != "idx1" || int(le.Uint32(data[idx+4:])) != 16*len(frames) {
+		t.Fatalf("missing idx1 chunk after the movi list")
+	}
+	for i := range frames {
+		entry := data[idx+8+16*i:]
+		offset := aviMoviOffset + int(le.Uint32(entry[8:]))
+		size := int(le.Uint32(entry[12:]))
+		if string(entry[:4]) != "00dc" || string(data[offset:offset+4]) != "00dc" || int(le.Uint32(data[offset+4:])) != size {
+			t.Fatalf("index entry %d does not point at its frame", i)
+		}
+		img, err := jpeg.Decode(bytes.NewReader(data[offset+8 : offset+8+size]))
+		if err != nil {
+			t.Fatalf("frame %d: jpeg.Decode() error = %v", i, err)
+		}
+		if img.Bounds() != frames[i].Bounds() {
+			t.Errorf("frame %d bounds = %v", i, img.Bounds())
+		}
+	}
+}
+
+func TestPDF(t *testing.T) {
+	kind, ok := Lookup("pdf")
+	if !ok {
+		t.Fatal("pdf is not registered")
+	}
+	var captions []string
+	caption := func(capturedAt time.Time) string {
+		c := capturedAt.Format("15:04:05.000")
+		captions = append(captions, c)
+		return c
+	}
+	data := encodeFrames(t, kind, testFrames(), Options{Caption: caption})
+
+	if !bytes.HasPrefix(data, []byte("%PDF-")) || !bytes.HasSuffix(data, []byte("%%EOF\n")) {
+		t.Fatalf("missing PDF header or trailer")
+	}
+	// Documents keep every capture as a page, including the repeated one
+	if got := bytes.Count(data, []byte("/Type /Page ")); got != 4 {
+		t.Errorf("pages = %d, want 4", got)
+	}
+	want := []string{"00:00:00.000", "00:00:00.500", "00:00:01.000", "00:00:01.250"}
+	if len(captions) != len(want) {
+		t.Fatalf("captions = %q, want %q", captions, want)
+	}
+	for i, c := range want {
+		if captions[i] != c || !bytes.Contains(data, []byte("("+c+") Tj")) {
+			t.Errorf("page %d caption = %q, want %q", i, captions[i], c)
+		}
+	}
+}
+
+func TestSizeChange(t *testing.T) {
+	for _, name := range Names() {
+		if name == "pdf" {
+			continue // Every page is sized to its capture
+		}
+		kind, _ := Lookup(name)
+		enc := kind.New(&memFile{}, Options{})
+		now := time.Now()
+		if err := enc.AddFrame(image.NewNRGBA(image.Rect(0, 0, 4, 4)), now); err != nil {
+			t.Fatalf("%s AddFrame() error = %v", name, err)
+		}
+		if err := enc.AddFrame(image.NewNRGBA(image.Rect(0, 0, 8, 4)), now.Add(time.Second)); err == nil {
+			t.Errorf("%s AddFrame() accepted a frame of a different size", name)
+		}
+	}
+}
+
+// encodeFrames encodes frames captured at 0s, 0.5s, 1s and 1.25s
+func encodeFrames(t *testing.T, kind *Kind, frames []*image.NRGBA, opts Options) []byte {
+	t.Helper()
+	var f memFile
+	enc := kind.New(&f, opts)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	offsets := []time.Duration{0, 500 * time.Millisecond, time.Second, 1250 * time.Millisecond}
+	for i, frame := range frames {
+		if err := enc.AddFrame(frame, start.Add(offsets[i])); err != nil {
+			t.Fatalf("AddFrame(%d) error = %v", i, err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	return f.data
+}
+
+// decodeFrame decodes the zlib data of an APNG frame by wrapping it into a
+// standalone PNG with the IHDR of the animation, resized to rect
+func decodeFrame(t *testing.T, apng []byte, rect image.Rectangle, data []byte) image.Image {
+	t.Helper()
+	var b bytes.Buffer
+	b.WriteString("\x89PNG\r\n\x1a\n")
+	chunk := func(name string, data []byte) {
+		binary.Write(&b, binary.BigEndian, uint32(len(data)))
+		b.WriteString(name)
+		b.Write(data)
+		binary.Write(&b, binary.BigEndian, crc32.ChecksumIEEE(append([]byte(name), data...)))
+	}
+	ihdr := append([]byte(nil), apng[16:29]...)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(rect.Dx()))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(rect.Dy()))
+	chunk("IHDR", ihdr)
+	chunk("IDAT", data)
+	chunk("IEND", nil)
+
+	img, err := png.Decode(&b)
+	if err != nil {
+		t.Fatalf("frame decode error = %v", err)
+	}
+	return img
+}
+
+// memFile is an in-memory io.WriteSeeker
+type memFile struct {
+	data []byte
+	pos  int
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if end := f.pos + len(p); end > len(f.data) {
+		f.data = append(f.data, make([]byte, end-len(f.data))...)
+	}
+	copy(f.data[f.pos:], p)
+	f.pos += len(p)
+	return len(p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += int64(f.pos)
+	case io.SeekEnd:
+		offset += int64(len(f.data))
+	}
+	f.pos = int(offset)
+	return offset, nil
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
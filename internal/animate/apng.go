@@ -0,0 +1,349 @@
+package animate
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/funnyzak/screenshot-cli/internal/formats"
+)
+
+func init() {
+	Register(&Kind{
+		Name:        "apng",
+		Extensions:  []string{".apng", ".png"},
+		Description: "Animated PNG in true color",
+		New: func(w io.WriteSeeker, opts Options) Encoder {
+			return &apngEncoder{ws: w, w: bufio.NewWriter(w), opts: opts}
+		},
+	})
+}
+
+// apngChunkSize is the largest IDAT or fdAT chunk written
+const apngChunkSize = 1 << 16
+
+// apngFrame is a frame waiting for its display time, which is known once the
+// next changed frame arrives
+type apngFrame struct {
+	rect       image.Rectangle
+	image      *image.NRGBA
+	capturedAt time.Time
+}
+
+// apngEncoder streams an animated PNG. The first frame is the default image;
+// each later frame only stores the rectangle that changed and replaces those
+// pixels of the previous frame. The frame count in the acTL chunk is patched
+// when the file is closed.
+type apngEncoder struct {
+	ws       io.WriteSeeker
+	w        *bufio.Writer
+	opts     Options
+	level    int
+	alpha    bool  // Frames are written as RGBA rather than RGB
+	actlPos  int64 // Offset of the acTL chunk
+	sequence uint32
+	frames   uint32
+	prev     *image.NRGBA
+	pending  *apngFrame
+}
+
+// AddFrame implements Encoder
+func (e *apngEncoder) AddFrame(img image.Image, capturedAt time.Time) error {
+	m := formats.ToNRGBA(img)
+
+	rect := m.Rect
+	if e.prev == nil {
+		if err := e.writeHeader(m); err != nil {
+			return err
+		}
+	} else {
+		if m.Rect != e.prev.Rect {
+			return fmt.Errorf("frame size changed from %dx%d to %dx%d",
+				e.prev.Rect.Dx(), e.prev.Rect.Dy(), rect.Dx(), rect.Dy())
+		}
+		if rect = changedRect(e.prev, m); rect.Empty() {
+			return nil
+		}
+		if err := e.flush(capturedAt.Sub(e.pending.capturedAt)); err != nil {
+			return err
+		}
+	}
+
+	e.prev = m
+	e.pending = &apngFrame{rect: rect, image: m.SubImage(rect).(*image.NRGBA), capturedAt: capturedAt}
+	return nil
+}
+
+// Close implements Encoder
+func (e *apngEncoder) Close() error {
+	if e.prev == nil {
+		return fmt.Errorf("animation has no frames")
+	}
+	if e.pending != nil {
+		if err := e.flush(e.opts.FinalDelay); err != nil {
+			return err
+		}
+	}
+	e.writeChunk("IEND", nil)
+	if err := e.w.Flush(); err != nil {
+		return err
+	}
+
+	// Patch the frame count now that it is known
+	end, err := e.ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := e.ws.Seek(e.actlPos, io.SeekStart); err != nil {
+		return err
+	}
+	e.writeChunk("acTL", e.actl())
+	if err := e.w.Flush(); err != nil {
+		return err
+	}
+	_, err = e.ws.Seek(end, io.SeekStart)
+	return err
+}
+
+// writeHeader writes the signature, IHDR and a provisional acTL chunk. Frames
+// keep an alpha channel only if the first one has transparency.
+func (e *apngEncoder) writeHeader(m *image.NRGBA) error {
+	switch strings.ToLower(e.opts.PNGCompression) {
+	case formats.PNGNone:
+		e.level = zlib.NoCompression
+	case formats.PNGFast:
+		e.level = zlib.BestSpeed
+	case formats.PNGBest:
+		e.level = zlib.BestCompression
+	default:
+		e.level = zlib.DefaultCompression
+	}
+	e.alpha = !m.Opaque()
+
+	start, err := e.ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("animated PNG needs a seekable output: %w", err)
+	}
+
+	e.w.WriteString("\x89PNG\r\n\x1a\n")
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(m.Rect.Dx()))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(m.Rect.Dy()))
+	ihdr[8] = 8 // Bits per sample
+	ihdr[9] = 2 // Truecolor
+	if e.alpha {
+		ihdr[9] = 6 // Truecolor with alpha
+	}
+	e.writeChunk("IHDR", ihdr)
+
+	e.actlPos = start + 8 + 12 + int64(len(ihdr))
+	e.writeChunk("acTL", e.actl())
+	return nil
+}
+
+// actl returns the data of the acTL chunk for the frames written so far
+func (e *apngEncoder) actl() []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint32(data[0:], e.frames)
+	binary.BigEndian.PutUint32(data[4:], uint32(e.opts.Loop))
+	return data
+}
+
+// flush writes the pending frame, shown for delay
+func (e *apngEncoder) flush(delay time.Duration) error {
+	f := e.pending
+	e.pending = nil
+
+	// Frame control: replace the pixels of the rectangle, keep the rest
+	num, den := apngDelay(delay)
+	fctl := make([]byte, 26)
+	binary.BigEndian.PutUint32(fctl[0:], e.sequence)
+	binary.BigEndian.PutUint32(fctl[4:], uint32(f.rect.Dx()))
+	binary.BigEndian.PutUint32(fctl[8:], uint32(f.rect.Dy()))
+	binary.BigEndian.PutUint32(fctl[12:], uint32(f.rect.Min.X))
+	binary.BigEndian.PutUint32(fctl[16:], uint32(f.rect.Min.Y))
+	binary.BigEndian.PutUint16(fctl[20:], num)
+	binary.BigEndian.PutUint16(fctl[22:], den)
+	fctl[24] = 0 // APNG_DISPOSE_OP_NONE
+	fctl[25] = 0 // APNG_BLEND_OP_SOURCE
+	e.sequence++
+	e.writeChunk("fcTL", fctl)
+
+	// The first frame is the default image in IDAT chunks, later frames go
+	// into fdAT chunks that carry a sequence number
+	chunks := &apngChunkWriter{e: e, first: e.frames == 0}
+	zw, err := zlib.NewWriterLevel(chunks, e.level)
+	if err != nil {
+		return err
+	}
+	bpp := 3
+	if e.alpha {
+		bpp = 4
+	}
+	width := f.rect.Dx()
+	prev := make([]byte, bpp*width)
+	cur := make([]byte, bpp*width)
+	var filtered [5][]byte
+	for i := range filtered {
+		filtered[i] = make([]byte, 1+bpp*width)
+	}
+	for y := 0; y < f.rect.Dy(); y++ {
+		pix := f.image.Pix[y*f.image.Stride:]
+		if bpp == 4 {
+			copy(cur, pix[:4*width])
+		} else {
+			for x := 0; x < width; x++ {
+				copy(cur[3*x:3*x+3], pix[4*x:4*x+3])
+			}
+		}
+		if _, err := zw.Write(filterRow(&filtered, cur, prev, bpp)); err != nil {
+			return fmt.Errorf("failed to compress frame: %w", err)
+		}
+		prev, cur = cur, prev
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to compress frame: %w", err)
+	}
+	chunks.flush()
+	e.frames++
+
+	return e.w.Flush()
+}
+
+// apngDelay returns the fraction of a second a frame is shown for, in the
+// finest unit that can hold delay
+func apngDelay(delay time.Duration) (uint16, uint16) {
+	for _, den := range []int64{1000, 100, 1} {
+		n := (delay.Milliseconds()*den + 500) / 1000
+		if n <= 0xffff {
+			return uint16(max(n, 1)), uint16(den)
+		}
+	}
+	return 0xffff, 1
+}
+
+// writeChunk writes a PNG chunk with its length and CRC
+func (e *apngEncoder) writeChunk(name string, data []byte) {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], name)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+
+	e.w.Write(header[:])
+	e.w.Write(data)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	e.w.Write(sum[:])
+}
+
+// apngChunkWriter splits compressed frame data into IDAT or fdAT chunks
+type apngChunkWriter struct {
+	e     *apngEncoder
+	first bool
+	buf   []byte
+}
+
+// Write implements io.Writer
+func (c *apngChunkWriter) Write(p []byte) (int, error) {
+	c.buf = append(c.buf, p...)
+	for len(c.buf) >= apngChunkSize {
+		c.writeChunk(c.buf[:apngChunkSize])
+		c.buf = c.buf[apngChunkSize:]
+	}
+	return len(p), nil
+}
+
+// flush writes the remaining data
+func (c *apngChunkWriter) flush() {
+	if len(c.buf) > 0 {
+		c.writeChunk(c.buf)
+		c.buf = nil
+	}
+}
+
+func (c *apngChunkWriter) writeChunk(data []byte) {
+	if c.first {
+		c.e.writeChunk("IDAT", data)
+		return
+	}
+	fdat := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(fdat, c.e.sequence)
+	copy(fdat[4:], data)
+	c.e.sequence++
+	c.e.writeChunk("fdAT", fdat)
+}
+
+// filterRow picks the PNG filter that minimizes the sum of absolute
+// differences for the row cur, whose predecessor is prev, and returns the
+// filtered row prefixed with its filter type
+func filterRow(filtered *[5][]byte, cur, prev []byte, bpp int) []byte {
+	best, bestSum := 0, -1
+	for ft := 0; ft < 5; ft++ {
+		out := filtered[ft]
+		out[0] = byte(ft)
+		sum := 0
+		for i := range cur {
+			var a, c int
+			b := int(prev[i])
+			if i >= bpp {
+				a = int(cur[i-bpp])
+				c = int(prev[i-bpp])
+			}
+			var pred int
+			switch ft {
+			case 1:
+				pred = a
+			case 2:
+				pred = b
+			case 3:
+				pred = (a + b) / 2
+			case 4:
+				pred = paeth(a, b, c)
+			}
+			v := cur[i] - byte(pred)
+			out[1+i] = v
+			sum += abs8(v)
+			if bestSum >= 0 && sum >= bestSum {
+				break
+			}
+		}
+		if bestSum < 0 || sum < bestSum {
+			best, bestSum = ft, sum
+		}
+	}
+	return filtered[best]
+}
+
+// paeth is the Paeth predictor of the PNG specification
+func paeth(a, b, c int) int {
+	p := a + b - c
+	pa, pb, pc := abs(p-a), abs(p-b), abs(p-c)
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	default:
+		return c
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// abs8 returns the magnitude of a filtered byte read as a signed value
+func abs8(v byte) int {
+	return abs(int(int8(v)))
+}
@@ -0,0 +1,219 @@
+package animate
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"time"
+
+	"github.com/funnyzak/screenshot-cli/internal/formats"
+)
+
+func init() {
+	Register(&Kind{
+		Name:        "avi",
+		Extensions:  []string{".avi"},
+		Description: "Motion-JPEG AVI time-lapse at --fps",
+		New: func(w io.WriteSeeker, opts Options) Encoder {
+			return &aviEncoder{ws: w, w: bufio.NewWriter(w), opts: opts}
+		},
+	})
+}
+
+// DefaultFPS is the frame rate of time-lapse videos unless configured
+const DefaultFPS = 10
+
+// Layout of the AVI headers written before the first frame
+const (
+	aviHeaderSize = 224 // RIFF header, hdrl list and movi list header
+	aviMoviOffset = 220 // Offset of the movi list type, the base of idx1 offsets
+	aviKeyFrame   = 0x10
+	aviHasIndex   = 0x10
+)
+
+// aviIndexEntry locates one frame for the idx1 chunk
+type aviIndexEntry struct {
+	offset uint32 // Relative to the movi list type
+	size   uint32
+}
+
+// aviEncoder streams a Motion-JPEG AVI in which every capture is one frame
+// of a video played at opts.FPS. The headers are written with placeholder
+// counts first; Close appends the index and patches them, so the file is only
+// valid once closed.
+type aviEncoder struct {
+	ws      io.WriteSeeker
+	w       *bufio.Writer
+	opts    Options
+	jpeg    *formats.Format
+	start   int64
+	size    image.Rectangle
+	written int64 // Bytes written after the headers
+	maxSize uint32
+	index   []aviIndexEntry
+	buf     bytes.Buffer
+}
+
+// AddFrame implements Encoder. Every frame is kept, so the video advances at
+// a constant rate of one capture per frame.
+func (e *aviEncoder) AddFrame(img image.Image, capturedAt time.Time) error {
+	if e.jpeg == nil {
+		jpeg, ok := formats.Lookup("jpg")
+		if !ok {
+			return fmt.Errorf("JPEG encoder is not registered")
+		}
+		e.jpeg = jpeg
+		e.size = img.Bounds().Sub(img.Bounds().Min)
+		start, err := e.ws.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return fmt.Errorf("AVI needs a seekable output: %w", err)
+		}
+		e.start = start
+		e.w.Write(e.header())
+	} else if size := img.Bounds().Sub(img.Bounds().Min); size != e.size {
+		return fmt.Errorf("frame size changed from %dx%d to %dx%d", e.size.Dx(), e.size.Dy(), size.Dx(), size.Dy())
+	}
+
+	e.buf.Reset()
+	if err := e.jpeg.Encode(&e.buf, img, e.opts.Options); err != nil {
+		return fmt.Errorf("failed to encode frame: %w", err)
+	}
+	size := e.buf.Len()
+	padded := int64(size + size%2)
+	if aviHeaderSize+e.written+8+padded+16*int64(len(e.index)+1) > math.MaxUint32 {
+		return fmt.Errorf("AVI size limit of 4 GB reached")
+	}
+
+	e.index = append(e.index, aviIndexEntry{offset: uint32(4 + e.written), size: uint32(size)})
+	e.maxSize = max(e.maxSize, uint32(size))
+
+	var header [8]byte
+	copy(header[:], "00dc")
+	binary.LittleEndian.PutUint32(header[4:], uint32(size))
+	e.w.Write(header[:])
+	e.w.Write(e.buf.Bytes())
+	if size%2 == 1 {
+		e.w.WriteByte(0)
+	}
+	e.written += 8 + padded
+
+	return e.w.Flush()
+}
+
+// Close implements Encoder
+func (e *aviEncoder) Close() error {
+	if len(e.index) == 0 {
+		return fmt.Errorf("animation has no frames")
+	}
+
+	var chunk [8]byte
+	copy(chunk[:], "idx1")
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(16*len(e.index)))
+	e.w.Write(chunk[:])
+	for _, entry := range e.index {
+		var b [16]byte
+		copy(b[:], "00dc")
+		binary.LittleEndian.PutUint32(b[4:], aviKeyFrame)
+		binary.LittleEndian.PutUint32(b[8:], entry.offset)
+		binary.LittleEndian.PutUint32(b[12:], entry.size)
+		e.w.Write(b[:])
+	}
+	if err := e.w.Flush(); err != nil {
+		return err
+	}
+
+	// Patch the headers with the final counts and sizes
+	end, err := e.ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := e.ws.Seek(e.start, io.SeekStart); err != nil {
+		return err
+	}
+	e.w.Write(e.header())
+	if err := e.w.Flush(); err != nil {
+		return err
+	}
+	_, err = e.ws.Seek(end, io.SeekStart)
+	return err
+}
+
+// fps returns the configured frame rate
+func (e *aviEncoder) fps() uint32 {
+	if e.opts.FPS <= 0 {
+		return DefaultFPS
+	}
+	return uint32(e.opts.FPS)
+}
+
+// header returns the RIFF header, the hdrl list with the main AVI header and
+// one MJPG video stream, and the movi list header for the frames written so
+// far
+func (e *aviEncoder) header() []byte {
+	b := make([]byte, aviHeaderSize)
+	le := binary.LittleEndian
+	frames := uint32(len(e.index))
+	width, height := uint32(e.size.Dx()), uint32(e.size.Dy())
+	fps := e.fps()
+	idxSize := uint32(0)
+	if frames > 0 {
+		idxSize = 8 + 16*frames
+	}
+
+	copy(b[0:], "RIFF")
+	le.PutUint32(b[4:], uint32(aviHeaderSize-8+e.written)+idxSize)
+	copy(b[8:], "AVI ")
+
+	copy(b[12:], "LIST")
+	le.PutUint32(b[16:], 192)
+	copy(b[20:], "hdrl")
+
+	// Main AVI header
+	copy(b[24:], "avih")
+	le.PutUint32(b[28:], 56)
+	le.PutUint32(b[32:], 1000000/fps)   // Microseconds per frame
+	le.PutUint32(b[36:], e.maxSize*fps) // Maximum bytes per second
+	le.PutUint32(b[44:], aviHasIndex)   // Flags
+	le.PutUint32(b[48:], frames)        // Total frames
+	le.PutUint32(b[56:], 1)             // Streams
+	le.PutUint32(b[60:], e.maxSize)     // Suggested buffer size
+	le.PutUint32(b[64:], width)
+	le.PutUint32(b[68:], height)
+
+	copy(b[88:], "LIST")
+	le.PutUint32(b[92:], 116)
+	copy(b[96:], "strl")
+
+	// Stream header
+	copy(b[100:], "strh")
+	le.PutUint32(b[104:], 56)
+	copy(b[108:], "vids")
+	copy(b[112:], "MJPG")
+	le.PutUint32(b[128:], 1)          // Scale
+	le.PutUint32(b[132:], fps)        // Rate, frames per scale
+	le.PutUint32(b[140:], frames)     // Length
+	le.PutUint32(b[144:], e.maxSize)  // Suggested buffer size
+	le.PutUint32(b[148:], 0xffffffff) // Quality: default
+	le.PutUint16(b[160:], uint16(width))
+	le.PutUint16(b[162:], uint16(height))
+
+	// Stream format: BITMAPINFOHEADER
+	copy(b[164:], "strf")
+	le.PutUint32(b[168:], 40)
+	le.PutUint32(b[172:], 40)
+	le.PutUint32(b[176:], width)
+	le.PutUint32(b[180:], height)
+	le.PutUint16(b[184:], 1)  // Planes
+	le.PutUint16(b[186:], 24) // Bits per pixel
+	copy(b[188:], "MJPG")
+	le.PutUint32(b[192:], width*height*3)
+
+	copy(b[212:], "LIST")
+	le.PutUint32(b[216:], uint32(4+e.written))
+	copy(b[220:], "movi")
+	return b
+}
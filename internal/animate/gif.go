@@ -0,0 +1,222 @@
+package animate
+
+import (
+	"bufio"
+	"compress/lzw"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"time"
+
+	"github.com/funnyzak/screenshot-cli/internal/formats"
+)
+
+func init() {
+	Register(&Kind{
+		Name:        "gif",
+		Extensions:  []string{".gif"},
+		Description: "Animated GIF with adaptive palettes",
+		New: func(w io.WriteSeeker, opts Options) Encoder {
+			return &gifEncoder{w: bufio.NewWriter(w), opts: opts}
+		},
+	})
+}
+
+// GIF delays are in hundredths of a second; browsers slow down shorter ones
+const (
+	gifMinDelay = 2
+	gifMaxDelay = 0xffff
+)
+
+// gifFrame is a quantized frame waiting for its display time, which is known
+// once the next changed frame arrives
+type gifFrame struct {
+	rect       image.Rectangle
+	image      *image.Paletted
+	capturedAt time.Time
+}
+
+// gifEncoder streams an animated GIF. Each frame after the first only stores
+// the rectangle that changed and is drawn over the previous frames.
+type gifEncoder struct {
+	w       *bufio.Writer
+	opts    Options
+	prev    *image.NRGBA
+	pending *gifFrame
+	global  color.Palette // Palette of the first frame in shared mode
+}
+
+// AddFrame implements Encoder
+func (e *gifEncoder) AddFrame(img image.Image, capturedAt time.Time) error {
+	m := formats.ToNRGBA(img)
+
+	rect := m.Rect
+	if e.prev == nil {
+		e.writeHeader(rect.Dx(), rect.Dy())
+	} else {
+		if m.Rect != e.prev.Rect {
+			return fmt.Errorf("frame size changed from %dx%d to %dx%d",
+				e.prev.Rect.Dx(), e.prev.Rect.Dy(), rect.Dx(), rect.Dy())
+		}
+		if rect = changedRect(e.prev, m); rect.Empty() {
+			return nil
+		}
+		if err := e.flush(capturedAt.Sub(e.pending.capturedAt)); err != nil {
+			return err
+		}
+	}
+
+	sub := m.SubImage(rect).(*image.NRGBA)
+	var paletted *image.Paletted
+	switch {
+	case e.opts.Palette != PaletteShared:
+		paletted = formats.Quantize(sub, e.opts.PaletteSize(), e.opts.Dither)
+	case e.global == nil:
+		paletted = formats.Quantize(sub, e.opts.PaletteSize(), e.opts.Dither)
+		e.global = paletted.Palette
+	default:
+		paletted = formats.MapToPalette(sub, e.global, e.opts.Dither)
+	}
+
+	e.prev = m
+	e.pending = &gifFrame{rect: rect, image: paletted, capturedAt: capturedAt}
+	return nil
+}
+
+// Close implements Encoder
+func (e *gifEncoder) Close() error {
+	if e.prev == nil {
+		return fmt.Errorf("animation has no frames")
+	}
+	if e.pending != nil {
+		if err := e.flush(e.opts.FinalDelay); err != nil {
+			return err
+		}
+	}
+	e.w.WriteByte(0x3b) // Trailer
+	return e.w.Flush()
+}
+
+// writeHeader writes the GIF header, the logical screen descriptor and the
+// loop count
+func (e *gifEncoder) writeHeader(width, height int) {
+	e.w.WriteString("GIF89a")
+	var screen [7]byte
+	binary.LittleEndian.PutUint16(screen[0:], uint16(width))
+	binary.LittleEndian.PutUint16(screen[2:], uint16(height))
+	screen[4] = 0x70 // No global color table, 8 bits of color resolution
+	e.w.Write(screen[:])
+
+	// The NETSCAPE2.0 extension counts repetitions after the first play,
+	// with 0 meaning forever; without it the animation plays once
+	if e.opts.Loop == 1 {
+		return
+	}
+	repeat := 0
+	if e.opts.Loop > 1 {
+		repeat = e.opts.Loop - 1
+	}
+	e.w.Write([]byte{0x21, 0xff, 0x0b})
+	e.w.WriteString("NETSCAPE2.0")
+	e.w.Write([]byte{0x03, 0x01, byte(repeat), byte(repeat >> 8), 0x00})
+}
+
+// flush writes the pending frame, shown for delay
+func (e *gifEncoder) flush(delay time.Duration) error {
+	f := e.pending
+	e.pending = nil
+
+	centis := min(max(int((delay+5*time.Millisecond)/(10*time.Millisecond)), gifMinDelay), gifMaxDelay)
+	transparent := -1
+	for i, c := range f.image.Palette {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			transparent = i
+			break
+		}
+	}
+
+	// Graphic control extension: keep the frame in place for the next one
+	control := []byte{0x21, 0xf9, 0x04, 0x01 << 2, byte(centis), byte(centis >> 8), 0, 0x00}
+	if transparent >= 0 {
+		control[3] |= 0x01
+		control[6] = byte(transparent)
+	}
+	e.w.Write(control)
+
+	// Image descriptor with a local color table
+	bits := 1
+	for 1<<bits < len(f.image.Palette) {
+		bits++
+	}
+	var descriptor [10]byte
+	descriptor[0] = 0x2c
+	binary.LittleEndian.PutUint16(descriptor[1:], uint16(f.rect.Min.X))
+	binary.LittleEndian.PutUint16(descriptor[3:], uint16(f.rect.Min.Y))
+	binary.LittleEndian.PutUint16(descriptor[5:], uint16(f.rect.Dx()))
+	binary.LittleEndian.PutUint16(descriptor[7:], uint16(f.rect.Dy()))
+	descriptor[9] = 0x80 | byte(bits-1)
+	e.w.Write(descriptor[:])
+	for i := 0; i < 1<<bits; i++ {
+		var r, g, b uint32
+		if i < len(f.image.Palette) {
+			r, g, b, _ = f.image.Palette[i].RGBA()
+		}
+		e.w.Write([]byte{byte(r >> 8), byte(g >> 8), byte(b >> 8)})
+	}
+
+	// LZW compressed indices in sub-blocks of up to 255 bytes
+	litWidth := max(bits, 2)
+	e.w.WriteByte(byte(litWidth))
+	blocks := &gifBlockWriter{w: e.w}
+	compressor := lzw.NewWriter(blocks, lzw.LSB, litWidth)
+	width := f.rect.Dx()
+	for y := 0; y < f.rect.Dy(); y++ {
+		if _, err := compressor.Write(f.image.Pix[y*f.image.Stride : y*f.image.Stride+width]); err != nil {
+			return fmt.Errorf("failed to compress frame: %w", err)
+		}
+	}
+	if err := compressor.Close(); err != nil {
+		return fmt.Errorf("failed to compress frame: %w", err)
+	}
+	blocks.close()
+
+	return e.w.Flush()
+}
+
+// gifBlockWriter splits data into GIF sub-blocks
+type gifBlockWriter struct {
+	w   *bufio.Writer
+	buf [255]byte
+	n   int
+}
+
+// Write implements io.Writer
+func (b *gifBlockWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		c := copy(b.buf[b.n:], p)
+		b.n += c
+		p = p[c:]
+		if b.n == len(b.buf) {
+			b.writeBlock()
+		}
+	}
+	return written, nil
+}
+
+// writeBlock writes the buffered bytes as one sub-block
+func (b *gifBlockWriter) writeBlock() {
+	b.w.WriteByte(byte(b.n))
+	b.w.Write(b.buf[:b.n])
+	b.n = 0
+}
+
+// close writes the remaining bytes and the block terminator
+func (b *gifBlockWriter) close() {
+	if b.n > 0 {
+		b.writeBlock()
+	}
+	b.w.WriteByte(0x00)
+}
@@ -0,0 +1,40 @@
+package animate
+
+import (
+	"image"
+	"io"
+	"time"
+
+	"github.com/funnyzak/screenshot-cli/internal/formats"
+)
+
+func init() {
+	Register(&Kind{
+		Name:        "pdf",
+		Extensions:  []string{".pdf"},
+		Description: "PDF document with one page per capture",
+		New: func(w io.WriteSeeker, opts Options) Encoder {
+			return &pdfEncoder{pdf: formats.NewPDFWriter(w, opts.Options), opts: opts}
+		},
+	})
+}
+
+// pdfEncoder adds every capture as a page, captioned if configured
+type pdfEncoder struct {
+	pdf  *formats.PDFWriter
+	opts Options
+}
+
+// AddFrame implements Encoder
+func (e *pdfEncoder) AddFrame(img image.Image, capturedAt time.Time) error {
+	caption := ""
+	if e.opts.Caption != nil {
+		caption = e.opts.Caption(capturedAt)
+	}
+	return e.pdf.AddPage(img, caption)
+}
+
+// Close implements Encoder
+func (e *pdfEncoder) Close() error {
+	return e.pdf.Close()
+}
@@ -0,0 +1,106 @@
+package batch
+
+import (
+	"fmt"
+	"image"
+	"time"
+
+	"github.com/funnyzak/screenshot-cli/internal/animate"
+	"github.com/funnyzak/screenshot-cli/internal/config"
+	"github.com/funnyzak/screenshot-cli/internal/formats"
+	"github.com/funnyzak/screenshot-cli/internal/output"
+)
+
+// animation streams the frames of a run with --animate into one file. The
+// file is created at the first frame, so its path can use the template values
+// of that capture, and renamed into place when the run ends.
+type animation struct {
+	cfg     *config.Config
+	kind    *animate.Kind
+	path    string
+	file    *output.AtomicFile
+	encoder animate.Encoder
+	frames  int
+	skipped bool // The file already existed and --if-exists skip kept it
+	closed  bool
+}
+
+// newAnimation returns the animation configured for a run, or nil
+func newAnimation(cfg *config.Config) (*animation, error) {
+	if cfg.Animate == "" {
+		return nil, nil
+	}
+	kind, ok := animate.Lookup(cfg.Animate)
+	if !ok {
+		return nil, fmt.Errorf("unsupported animation format: %s", cfg.Animate)
+	}
+	return &animation{cfg: cfg, kind: kind}, nil
+}
+
+// add appends a frame, creating the file at path for the first one. It
+// reports whether the frame was kept, which it is not if the file is skipped.
+func (a *animation) add(path string, img image.Image, capturedAt time.Time) (bool, error) {
+	if a.skipped {
+		return false, nil
+	}
+
+	if a.encoder == nil {
+		resolved, skip, err := output.PrepareOutputPath(path, a.cfg.IfExists)
+		if err != nil {
+			return false, err
+		}
+		a.path = resolved
+		if skip {
+			a.skipped = true
+			return false, nil
+		}
+
+		file, err := output.CreateAtomic(resolved)
+		if err != nil {
+			return false, err
+		}
+		a.file = file
+		opts := animate.Options{
+			Options: formats.Options{
+				Quality:        a.cfg.Quality,
+				PNGCompression: a.cfg.PNGCompression,
+				Colors:         a.cfg.Colors,
+				Dither:         a.cfg.Dither,
+				PDFCompression: a.cfg.PDFCompression,
+			},
+			Loop:       a.cfg.Loop,
+			Palette:    a.cfg.AnimatePalette,
+			FinalDelay: time.Duration(a.cfg.Interval) * time.Second,
+			FPS:        a.cfg.FPS,
+		}
+		if a.cfg.PDFCaption {
+			opts.Caption = a.cfg.Caption
+		}
+		a.encoder = a.kind.New(file, opts)
+	}
+
+	if err := a.encoder.AddFrame(img, capturedAt); err != nil {
+		return false, err
+	}
+	a.frames++
+	return true, nil
+}
+
+// close completes the file and renames it into place. Runs that end early
+// still produce an animation of the frames captured so far.
+func (a *animation) close() error {
+	if a.closed || a.encoder == nil {
+		return nil
+	}
+	a.closed = true
+
+	if err := a.encoder.Close(); err != nil {
+		a.file.Abort()
+		return fmt.Errorf("failed to write animation: %w", err)
+	}
+	if err := a.file.Commit(a.cfg.Fsync); err != nil {
+		return fmt.Errorf("failed to write animation: %w", err)
+	}
+	fmt.Printf("Animation saved: %s (%d frames)\n", a.path, a.frames)
+	return nil
+}
@@ -0,0 +1,80 @@
+package batch
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/funnyzak/screenshot-cli/internal/config"
+)
+
+func TestAnimation(t *testing.T) {
+	for _, kind := range []string{"gif", "apng", "avi"} {
+		t.Run(kind, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "run."+kind)
+			cfg := &config.Config{Animate: kind, Count: 3, Interval: 1, Quality: 90, IfExists: config.IfExistsSuffix}
+			if err := os.WriteFile(path, []byte("existing"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			a, err := newAnimation(cfg)
+			if err != nil {
+				t.Fatalf("newAnimation() error = %v", err)
+			}
+			now := time.Now()
+			for i := 0; i < 2; i++ {
+				frame := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+				frame.Pix[4*i] = 255
+				if added, err := a.add(path, frame, now.Add(time.Duration(i)*time.Second)); err != nil || !added {
+					t.Fatalf("add() = %v, %v", added, err)
+				}
+			}
+			if err := a.close(); err != nil {
+				t.Fatalf("close() error = %v", err)
+			}
+			if err := a.close(); err != nil {
+				t.Errorf("second close() error = %v", err)
+			}
+
+			// The existing file is kept and the animation gets a suffix
+			want := filepath.Join(dir, "run-1."+kind)
+			if a.path != want {
+				t.Errorf("path = %s, want %s", a.path, want)
+			}
+			entries, _ := os.ReadDir(dir)
+			if len(entries) != 2 {
+				t.Errorf("directory holds %d files, want 2 without temp files", len(entries))
+			}
+			if info, err := os.Stat(want); err != nil || info.Size() == 0 {
+				t.Errorf("animation not written: %v", err)
+			}
+		})
+	}
+}
+
+func TestWaitForNextInterrupted(t *testing.T) {
+	ctx, cancel := notifyInterrupt()
+	cancel()
+
+	start := time.Now()
+	waitForNext(ctx, &config.Config{Count: 2, Interval: 60}, 1)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("waitForNext() waited %v after the interrupt", elapsed)
+	}
+}
+
+func TestWaitBackoffInterrupted(t *testing.T) {
+	ctx, cancel := notifyInterrupt()
+	cancel()
+
+	start := time.Now()
+	if waitBackoff(ctx, time.Minute) {
+		t.Error("waitBackoff() = true after the interrupt")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("waitBackoff() waited %v after the interrupt", elapsed)
+	}
+}
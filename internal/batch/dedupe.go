@@ -0,0 +1,174 @@
+package batch
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// DedupeIndexFile is the name of the persisted perceptual hash index
+const DedupeIndexFile = ".sshot-dedupe.json"
+
+// dedupeEntry associates a perceptual hash with the file it was saved to
+type dedupeEntry struct {
+	Hash string `json:"hash"`
+	Path string `json:"path"`
+}
+
+// Deduper tracks perceptual hashes of saved frames to detect near-duplicates
+type Deduper struct {
+	threshold int
+	indexPath string
+	hashes    []uint64
+	entries   []dedupeEntry
+}
+
+// NewDeduper creates a deduper that treats frames within threshold bits
+// (Hamming distance) of an earlier frame as duplicates. If indexPath is not
+// empty, an existing index is loaded from it and Save writes back to it.
+// Entries whose file no longer exists, e.g. after retention deleted it, are
+// dropped so new frames are not skipped as duplicates of a missing file.
+func NewDeduper(threshold int, indexPath string) (*Deduper, error) {
+	d := &Deduper{
+		threshold: threshold,
+		indexPath: indexPath,
+	}
+
+	if indexPath == "" {
+		return d, nil
+	}
+
+	data, err := os.ReadFile(indexPath)
+	if os.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dedupe index: %w", err)
+	}
+
+	var entries []dedupeEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid dedupe index %s: %w", indexPath, err)
+	}
+	for _, entry := range entries {
+		hash, err := strconv.ParseUint(entry.Hash, 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hash in dedupe index: %s", entry.Hash)
+		}
+		if _, err := os.Stat(entry.Path); os.IsNotExist(err) {
+			continue
+		}
+		d.hashes = append(d.hashes, hash)
+		d.entries = append(d.entries, entry)
+	}
+
+	return d, nil
+}
+
+// FindDuplicate returns the path of an earlier frame that is perceptually
+// identical to hash, or an empty string if there is none
+func (d *Deduper) FindDuplicate(hash uint64) string {
+	for i, h := range d.hashes {
+		if HammingDistance(h, hash) <= d.threshold {
+			return d.entries[i].Path
+		}
+	}
+	return ""
+}
+
+// Add records the hash of a frame saved to path
+func (d *Deduper) Add(hash uint64, path string) {
+	d.hashes = append(d.hashes, hash)
+	d.entries = append(d.entries, dedupeEntry{
+		Hash: fmt.Sprintf("%016x", hash),
+		Path: path,
+	})
+}
+
+// Save persists the index if an index path was configured
+func (d *Deduper) Save() error {
+	if d.indexPath == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(d.entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode dedupe index: %w", err)
+	}
+
+	if err := ensureDir(filepath.Dir(d.indexPath)); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(d.indexPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write dedupe index: %w", err)
+	}
+
+	return nil
+}
+
+// DifferenceHash computes a 64-bit dHash of an image. The image is reduced
+// to a 9x8 grayscale grid and each bit records whether a cell is brighter
+// than its right-hand neighbour, which makes the hash robust against
+// compression noise and small rendering differences.
+func DifferenceHash(img image.Image) uint64 {
+	const cols, rows = 9, 8
+	var grid [rows][cols]float64
+
+	bounds := img.Bounds()
+	for y := 0; y < rows; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/rows
+		y1 := bounds.Min.Y + (y+1)*bounds.Dy()/rows
+		for x := 0; x < cols; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/cols
+			x1 := bounds.Min.X + (x+1)*bounds.Dx()/cols
+			grid[y][x] = averageLuminance(img, x0, y0, x1, y1)
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols-1; x++ {
+			hash <<= 1
+			if grid[y][x] > grid[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+// HammingDistance returns the number of differing bits between two hashes
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// averageLuminance samples up to 16x16 points of a cell and returns their
+// mean luminance. Sampling keeps hashing cheap on multi-megapixel frames.
+func averageLuminance(img image.Image, x0, y0, x1, y1 int) float64 {
+	const samples = 16
+
+	if x1 <= x0 || y1 <= y0 {
+		return 0
+	}
+
+	stepX := max((x1-x0)/samples, 1)
+	stepY := max((y1-y0)/samples, 1)
+
+	var sum float64
+	var n int
+	for y := y0; y < y1; y += stepY {
+		for x := x0; x < x1; x += stepX {
+			r, g, b, _ := img.At(x, y).RGBA()
+			sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+			n++
+		}
+	}
+
+	return sum / float64(n)
+}
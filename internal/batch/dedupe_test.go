@@ -0,0 +1,81 @@
+package batch
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func gradientImage(width, height int, reverse bool) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := uint8(x * 255 / width)
+			if reverse {
+				v = 255 - v
+			}
+			img.Set(x, y, color.RGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+	return img
+}
+
+func TestDifferenceHash(t *testing.T) {
+	a := gradientImage(320, 240, false)
+	b := gradientImage(320, 240, false)
+	b.Set(10, 10, color.RGBA{R: 255, A: 255}) // small local change
+	c := gradientImage(320, 240, true)
+
+	if d := HammingDistance(DifferenceHash(a), DifferenceHash(b)); d != 0 {
+		t.Errorf("near-identical images distance = %d, want 0", d)
+	}
+	if d := HammingDistance(DifferenceHash(a), DifferenceHash(c)); d < 32 {
+		t.Errorf("inverted images distance = %d, want >= 32", d)
+	}
+}
+
+func TestDeduperIndex(t *testing.T) {
+	dir := t.TempDir()
+	indexPath := filepath.Join(dir, DedupeIndexFile)
+	kept := filepath.Join(dir, "shot_001.png")
+	if err := os.WriteFile(kept, []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDeduper(3, indexPath)
+	if err != nil {
+		t.Fatalf("NewDeduper() error = %v", err)
+	}
+	d.Add(0xff00ff00ff00ff00, kept)
+	// Deleted by retention since it was saved
+	d.Add(0x00ff00ff00ff00ff, filepath.Join(dir, "shot_002.png"))
+	if err := d.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	reloaded, err := NewDeduper(3, indexPath)
+	if err != nil {
+		t.Fatalf("NewDeduper() reload error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		hash uint64
+		want string
+	}{
+		{"exact match", 0xff00ff00ff00ff00, kept},
+		{"within threshold", 0xff00ff00ff00ff07, kept},
+		{"beyond threshold", 0xff00ff00ff00ff0f, ""},
+		{"deleted file", 0x00ff00ff00ff00ff, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := reloaded.FindDuplicate(tt.hash); got != tt.want {
+				t.Errorf("FindDuplicate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
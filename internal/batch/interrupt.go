@@ -0,0 +1,51 @@
+package batch
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/funnyzak/screenshot-cli/internal/config"
+)
+
+// notifyInterrupt returns a context canceled by the first interrupt or
+// SIGTERM, so a run can stop between screenshots and still complete its
+// manifest and animation. Once canceled the signals are no longer caught, and
+// a second Ctrl-C terminates the process immediately.
+func notifyInterrupt() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
+// waitForNext sleeps for the configured interval unless i is the last
+// screenshot or the run is interrupted
+func waitForNext(ctx context.Context, cfg *config.Config, i int) {
+	if i >= cfg.Count {
+		return
+	}
+	timer := time.NewTimer(time.Duration(cfg.Interval) * time.Second)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// waitBackoff sleeps for d before a retry and reports whether the run may go
+// on, which it may not once interrupted; tests replace it
+var waitBackoff = func(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
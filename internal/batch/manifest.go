@@ -0,0 +1,351 @@
+package batch
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Manifest formats
+const (
+	ManifestJSONL = "jsonl"
+	ManifestCSV   = "csv"
+)
+
+// Record describes a single capture of a batch run
+type Record struct {
+	RunID           string        `json:"run_id"` // Start of the run that made the capture
+	Sequence        int           `json:"sequence"`
+	Timestamp       time.Time     `json:"timestamp"`
+	Display         int           `json:"display"`
+	Region          string        `json:"region,omitempty"`
+	Path            string        `json:"path,omitempty"`
+	Format          string        `json:"format"`
+	Size            int64         `json:"size"`
+	Width           int           `json:"width"`
+	Height          int           `json:"height"`
+	Quality         int           `json:"quality,omitempty"`
+	SHA256          string        `json:"sha256,omitempty"`
+	CaptureDuration time.Duration `json:"capture_duration_ns"`
+	EncodeDuration  time.Duration `json:"encode_duration_ns"`
+	DuplicateOf     string        `json:"duplicate_of,omitempty"`
+	Existing        bool          `json:"existing,omitempty"`
+	Attempts        int           `json:"attempts"`
+	Error           string        `json:"error,omitempty"`
+	Thumbnail       string        `json:"thumbnail,omitempty"`
+	ThumbnailSize   int64         `json:"thumbnail_size,omitempty"`
+}
+
+// csvHeader lists the CSV columns in the order written by Manifest. Columns
+// are only ever appended: manifests of older versions have a prefix of this
+// header and keep being written with their columns.
+var csvHeader = []string{
+	"sequence", "timestamp", "display", "region", "path", "format", "size",
+	"width", "height", "sha256", "capture_duration_ns", "encode_duration_ns",
+	"duplicate_of", "existing", "attempts", "error", "thumbnail", "thumbnail_size", "quality", "run_id",
+}
+
+// Manifest appends capture records to a JSONL or CSV file. Paths are stored
+// relative to the directory containing the manifest.
+type Manifest struct {
+	file    *os.File
+	dir     string
+	format  string
+	csv     *csv.Writer
+	columns int // Number of CSV columns written, from the header of the file
+}
+
+// newRunID identifies the batch run started at start in its manifest records.
+// Runs append to the same manifest, so their records are told apart by it.
+func newRunID(start time.Time) string {
+	return start.UTC().Format("20060102T150405.000Z")
+}
+
+// ManifestPath returns the manifest location for an output directory
+func ManifestPath(dir, format string) string {
+	return filepath.Join(dir, "manifest."+format)
+}
+
+// OpenManifest opens the manifest at path for appending, creating it if
+// necessary. A CSV header is written when the file is new; records appended
+// to an existing CSV manifest only have the columns its header lists, and a
+// header that is not a prefix of csvHeader is rejected.
+func OpenManifest(path, format string) (*Manifest, error) {
+	if format != ManifestJSONL && format != ManifestCSV {
+		return nil, fmt.Errorf("unsupported manifest format: %s", format)
+	}
+
+	if err := ensureDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest: %w", err)
+	}
+
+	m := &Manifest{file: file, dir: filepath.Dir(path), format: format}
+
+	if format == ManifestCSV {
+		m.csv = csv.NewWriter(file)
+		m.columns = len(csvHeader)
+
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to stat manifest: %w", err)
+		}
+		if info.Size() == 0 {
+			if err := m.csv.Write(csvHeader); err != nil {
+				file.Close()
+				return nil, fmt.Errorf("failed to write manifest header: %w", err)
+			}
+			m.csv.Flush()
+		} else if m.columns, err = csvColumns(path); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// csvColumns returns the number of columns of the CSV manifest at path,
+// which has to be a prefix of csvHeader
+func csvColumns(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open manifest: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read manifest header: %w", err)
+	}
+	if len(header) > len(csvHeader) || !slices.Equal(header, csvHeader[:len(header)]) {
+		return 0, fmt.Errorf("manifest %s has the unknown columns %s; move it aside to start a new one",
+			path, strings.Join(header, ","))
+	}
+	return len(header), nil
+}
+
+// Write appends a record to the manifest. Records are flushed immediately so
+// the manifest stays usable if the run is interrupted.
+func (m *Manifest) Write(record *Record) error {
+	stored := *record
+	stored.Path = m.relative(record.Path)
+	stored.DuplicateOf = m.relative(record.DuplicateOf)
+	stored.Thumbnail = m.relative(record.Thumbnail)
+	record = &stored
+
+	if m.format == ManifestCSV {
+		if err := m.csv.Write(record.csvRow()[:m.columns]); err != nil {
+			return fmt.Errorf("failed to write manifest record: %w", err)
+		}
+		m.csv.Flush()
+		if err := m.csv.Error(); err != nil {
+			return fmt.Errorf("failed to write manifest record: %w", err)
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest record: %w", err)
+	}
+	if _, err := m.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write manifest record: %w", err)
+	}
+	return nil
+}
+
+// Close closes the underlying manifest file
+func (m *Manifest) Close() error {
+	return m.file.Close()
+}
+
+// relative converts path to be relative to the manifest directory
+func (m *Manifest) relative(path string) string {
+	if path == "" {
+		return ""
+	}
+
+	absDir, err := filepath.Abs(m.dir)
+	if err != nil {
+		return path
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	if err != nil {
+		return path
+	}
+	return rel
+}
+
+// FindManifest returns the path and format of the manifest in dir
+func FindManifest(dir string) (path, format string, err error) {
+	for _, format := range []string{ManifestJSONL, ManifestCSV} {
+		path := ManifestPath(dir, format)
+		if _, err := os.Stat(path); err == nil {
+			return path, format, nil
+		}
+	}
+	return "", "", fmt.Errorf("no manifest found in %s", dir)
+}
+
+// ReadManifest reads all records of a manifest. Record paths are returned as
+// stored, relative to the manifest directory.
+func ReadManifest(path, format string) ([]Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest: %w", err)
+	}
+	defer file.Close()
+
+	switch format {
+	case ManifestJSONL:
+		return readJSONL(file)
+	case ManifestCSV:
+		return readCSV(file)
+	default:
+		return nil, fmt.Errorf("unsupported manifest format: %s", format)
+	}
+}
+
+// readJSONL parses JSONL manifest records, one per line
+func readJSONL(r io.Reader) ([]Record, error) {
+	var records []Record
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("invalid manifest record on line %d: %w", line, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	return records, nil
+}
+
+// readCSV parses CSV manifest records using the header to locate columns
+func readCSV(r io.Reader) ([]Record, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	columns := make(map[string]int, len(rows[0]))
+	for i, name := range rows[0] {
+		columns[name] = i
+	}
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	records := make([]Record, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		record := Record{
+			RunID:       field(row, "run_id"),
+			Region:      field(row, "region"),
+			Path:        field(row, "path"),
+			Format:      field(row, "format"),
+			SHA256:      field(row, "sha256"),
+			DuplicateOf: field(row, "duplicate_of"),
+			Error:       field(row, "error"),
+			Thumbnail:   field(row, "thumbnail"),
+		}
+		record.Sequence, _ = strconv.Atoi(field(row, "sequence"))
+		record.Timestamp, _ = time.Parse(time.RFC3339Nano, field(row, "timestamp"))
+		record.Display, _ = strconv.Atoi(field(row, "display"))
+		record.Size, _ = strconv.ParseInt(field(row, "size"), 10, 64)
+		record.Width, _ = strconv.Atoi(field(row, "width"))
+		record.Height, _ = strconv.Atoi(field(row, "height"))
+		record.Existing, _ = strconv.ParseBool(field(row, "existing"))
+		record.Attempts, _ = strconv.Atoi(field(row, "attempts"))
+		captureNs, _ := strconv.ParseInt(field(row, "capture_duration_ns"), 10, 64)
+		record.CaptureDuration = time.Duration(captureNs)
+		encodeNs, _ := strconv.ParseInt(field(row, "encode_duration_ns"), 10, 64)
+		record.EncodeDuration = time.Duration(encodeNs)
+		record.ThumbnailSize, _ = strconv.ParseInt(field(row, "thumbnail_size"), 10, 64)
+		record.Quality, _ = strconv.Atoi(field(row, "quality"))
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// csvRow formats a record as a CSV row matching csvHeader
+func (r *Record) csvRow() []string {
+	thumbnailSize := ""
+	if r.Thumbnail != "" {
+		thumbnailSize = strconv.FormatInt(r.ThumbnailSize, 10)
+	}
+	quality := ""
+	if r.Quality > 0 {
+		quality = strconv.Itoa(r.Quality)
+	}
+	return []string{
+		strconv.Itoa(r.Sequence),
+		r.Timestamp.Format(time.RFC3339Nano),
+		strconv.Itoa(r.Display),
+		r.Region,
+		r.Path,
+		r.Format,
+		strconv.FormatInt(r.Size, 10),
+		strconv.Itoa(r.Width),
+		strconv.Itoa(r.Height),
+		r.SHA256,
+		strconv.FormatInt(int64(r.CaptureDuration), 10),
+		strconv.FormatInt(int64(r.EncodeDuration), 10),
+		r.DuplicateOf,
+		strconv.FormatBool(r.Existing),
+		strconv.Itoa(r.Attempts),
+		r.Error,
+		r.Thumbnail,
+		thumbnailSize,
+		quality,
+		r.RunID,
+	}
+}
+
+// ensureDir creates dir if it does not exist yet
+func ensureDir(dir string) error {
+	if dir == "." || dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package batch
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestManifestJSONL(t *testing.T) {
+	path := ManifestPath(t.TempDir(), ManifestJSONL)
+
+	m, err := OpenManifest(path, ManifestJSONL)
+	if err != nil {
+		t.Fatalf("OpenManifest() error = %v", err)
+	}
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	if err := m.Write(&Record{Sequence: 1, Timestamp: ts, Path: "shot_001.png", Format: "png"}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := m.Write(&Record{Sequence: 2, Timestamp: ts, Format: "png", Error: "boom"}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	m.Close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("invalid JSONL line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, r)
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+	if !records[0].Timestamp.Equal(ts) {
+		t.Errorf("timestamp = %v, want %v", records[0].Timestamp, ts)
+	}
+	if records[1].Error != "boom" {
+		t.Errorf("error = %q, want boom", records[1].Error)
+	}
+}
+
+func TestManifestCSVRuns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest.csv")
+
+	// Two runs append to the same manifest, told apart by their run IDs
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 1; i <= 2; i++ {
+		m, err := OpenManifest(path, ManifestCSV)
+		if err != nil {
+			t.Fatalf("OpenManifest() error = %v", err)
+		}
+		runID := newRunID(start.Add(time.Duration(i) * time.Hour))
+		if err := m.Write(&Record{RunID: runID, Sequence: i, Format: "png"}); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		m.Close()
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows, want header plus 2 records", len(rows))
+	}
+	if rows[0][0] != "sequence" || rows[2][0] != "2" {
+		t.Errorf("unexpected rows: %v", rows)
+	}
+	last := len(csvHeader) - 1
+	if rows[1][last] != "20240102T040405.000Z" || rows[2][last] != "20240102T050405.000Z" {
+		t.Errorf("run IDs = %q, %q", rows[1][last], rows[2][last])
+	}
+
+	records, err := ReadManifest(path, ManifestCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].RunID == records[1].RunID {
+		t.Errorf("ReadManifest() = %+v, want two records of different runs", records)
+	}
+}
+
+func TestManifestCSVOlderHeader(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.csv")
+	// Written by a version without the thumbnail and quality columns
+	old := "sequence,timestamp,display,region,path,format,size,width,height,sha256," +
+		"capture_duration_ns,encode_duration_ns,duplicate_of,existing,attempts,error\n" +
+		"1,2024-01-02T03:04:05Z,0,,shot_001.png,png,100,16,16,,0,0,,false,1,\n"
+	if err := os.WriteFile(path, []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := OpenManifest(path, ManifestCSV)
+	if err != nil {
+		t.Fatalf("OpenManifest() error = %v", err)
+	}
+	record := &Record{Sequence: 2, Path: filepath.Join(dir, "shot_002.png"), Format: "jpg", Quality: 80}
+	if err := m.Write(record); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	m.Close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	if len(rows) != 3 || rows[2][0] != "2" || rows[2][4] != "shot_002.png" {
+		t.Errorf("unexpected rows: %v", rows)
+	}
+
+	// A header that is not a prefix of the current one is rejected
+	if err := os.WriteFile(path, []byte("sequence,path\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := OpenManifest(path, ManifestCSV); err == nil {
+		m.Close()
+		t.Error("OpenManifest() accepted unknown columns")
+	}
+}
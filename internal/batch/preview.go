@@ -0,0 +1,98 @@
+package batch
+
+import (
+	"time"
+
+	"github.com/funnyzak/screenshot-cli/internal/capture"
+	"github.com/funnyzak/screenshot-cli/internal/config"
+)
+
+// Preview describes the paths a batch run would write
+type Preview struct {
+	Paths      []string         // Output path of every screenshot, in order
+	Collisions map[string][]int // Paths written more than once, with their counters
+	Warnings   []string
+}
+
+// PreviewPaths expands the file name and directory templates of cfg for
+// every screenshot of a run starting at start, assuming captures happen
+// exactly every cfg.Interval seconds. {width} and {height} are taken from
+// the region or, if available, the size of the selected display, after
+// resizing.
+func PreviewPaths(cfg *config.Config, start time.Time) (*Preview, error) {
+	templateProcessor := cfg.NewTemplateProcessor()
+
+	width, height := cfg.Resize().Size(previewImageSize(cfg))
+	templateProcessor.SetImageSize(width, height)
+
+	preview := &Preview{Collisions: make(map[string][]int)}
+	seen := make(map[string][]int, cfg.Count)
+
+	for i := 1; i <= cfg.Count; i++ {
+		templateProcessor.SetCounter(i)
+		templateProcessor.SetTime(start.Add(time.Duration(i-1) * time.Duration(cfg.Interval) * time.Second))
+
+		var path string
+		var err error
+		if cfg.Count > 1 {
+			path, err = batchOutputPath(cfg, templateProcessor, i)
+		} else {
+			path, err = templateProcessor.ProcessTemplate(cfg.Template, cfg)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		preview.Paths = append(preview.Paths, path)
+		seen[path] = append(seen[path], i)
+	}
+
+	for path, counters := range seen {
+		if len(counters) > 1 {
+			preview.Collisions[path] = counters
+		}
+	}
+
+	if cfg.Count > 1 && cfg.Template != "" {
+		unique, err := hasUniqueVariable(cfg.Template)
+		if err != nil {
+			return nil, err
+		}
+		if !unique {
+			preview.Warnings = append(preview.Warnings,
+				"template has no {counter}, time or random variable; batch screenshots may overwrite each other")
+		}
+	}
+
+	return preview, nil
+}
+
+// hasUniqueVariable reports whether a template contains a variable that
+// changes between screenshots of a batch
+func hasUniqueVariable(template string) (bool, error) {
+	names, err := config.TemplateVariableNames(template)
+	if err != nil {
+		return false, err
+	}
+
+	for _, name := range names {
+		switch name {
+		case "counter", "timestamp", "datetime", "time", "random", "uuid":
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// previewImageSize returns the expected dimensions of a capture
+func previewImageSize(cfg *config.Config) (int, int) {
+	if cfg.Region != nil {
+		return cfg.Region.Width, cfg.Region.Height
+	}
+
+	displays, err := capture.GetDisplayInfo()
+	if err != nil || cfg.Display >= len(displays) {
+		return 0, 0
+	}
+	return displays[cfg.Display].Dx(), displays[cfg.Display].Dy()
+}
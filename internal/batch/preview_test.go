@@ -0,0 +1,62 @@
+package batch
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/funnyzak/screenshot-cli/internal/config"
+)
+
+func TestPreviewPaths(t *testing.T) {
+	start := time.Date(2024, 6, 1, 23, 59, 58, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		template       string
+		dir            string
+		count          int
+		wantPaths      []string
+		wantCollisions map[string][]int
+		wantWarning    bool
+	}{
+		{"counter", "shot_{counter}.png", ".", 3,
+			[]string{"shot_001.png", "shot_002.png", "shot_003.png"}, map[string][]int{}, false},
+		{"colliding date", "{date}.png", ".", 5,
+			[]string{"20240601.png", "20240602.png", "20240602.png", "20240602.png", "20240602.png"},
+			map[string][]int{"20240602.png": {2, 3, 4, 5}}, true},
+		{"no unique variable", "shot.png", ".", 2,
+			[]string{"shot.png", "shot.png"}, map[string][]int{"shot.png": {1, 2}}, true},
+		{"templated directory", "{time}.png", filepath.Join("shots", "{date}"), 2,
+			[]string{filepath.Join("shots", "20240601", "235958.png"), filepath.Join("shots", "20240602", "000000.png")},
+			map[string][]int{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Template: tt.template,
+				Dir:      tt.dir,
+				Count:    tt.count,
+				Interval: 2,
+				Format:   "png",
+				Region:   &config.Region{Width: 640, Height: 480},
+				Location: time.UTC,
+			}
+			preview, err := PreviewPaths(cfg, start)
+			if err != nil {
+				t.Fatalf("PreviewPaths() error = %v", err)
+			}
+			if !reflect.DeepEqual(preview.Paths, tt.wantPaths) {
+				t.Errorf("Paths = %q, want %q", preview.Paths, tt.wantPaths)
+			}
+			if !reflect.DeepEqual(preview.Collisions, tt.wantCollisions) {
+				t.Errorf("Collisions = %v, want %v", preview.Collisions, tt.wantCollisions)
+			}
+			if got := len(preview.Warnings) > 0; got != tt.wantWarning {
+				t.Errorf("Warnings = %q, want a warning: %v", preview.Warnings, tt.wantWarning)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package batch
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
 	"strings"
@@ -9,149 +10,533 @@ import (
 	"github.com/funnyzak/screenshot-cli/internal/capture"
 	"github.com/funnyzak/screenshot-cli/internal/config"
 	"github.com/funnyzak/screenshot-cli/internal/output"
+	"github.com/funnyzak/screenshot-cli/internal/resize"
 )
 
-// ProcessBatch handles batch screenshot processing
+// captureScreen takes the screenshots of a batch run; tests replace it
+var captureScreen = capture.CaptureScreenWithTime
+
+// ProcessBatch handles batch screenshot processing. An interrupt ends the run
+// after the current screenshot.
 func ProcessBatch(cfg *config.Config) error {
-	templateProcessor := cfg.NewTemplateProcessor()
+	p, err := newProcessor(cfg)
+	if err != nil {
+		return err
+	}
+	defer p.close()
+
+	ctx, stop := notifyInterrupt()
+	defer stop()
 
 	fmt.Printf("Starting batch capture: %d screenshots, %d second intervals\n", cfg.Count, cfg.Interval)
 
 	for i := 1; i <= cfg.Count; i++ {
-		// Update counter for template processing
-		templateProcessor.SetCounter(i)
+		if ctx.Err() != nil {
+			fmt.Printf("Interrupted after %d of %d screenshots\n", i-1, cfg.Count)
+			break
+		}
 
-		// Capture screenshot
-		img, err := capture.CaptureScreen(cfg)
-		if err != nil {
-			return fmt.Errorf("failed to capture screenshot %d: %w", i, err)
+		record, err := p.process(ctx, i)
+		switch {
+		case err != nil && cfg.OnError == config.OnErrorAbort:
+			return p.abort(err)
+		case err != nil:
+			fmt.Printf("Screenshot %d/%d failed: %v\n", i, cfg.Count, err)
+		case record.DuplicateOf != "":
+			fmt.Printf("Screenshot %d/%d skipped: duplicate of %s\n", i, cfg.Count, record.DuplicateOf)
+		case record.Existing:
+			fmt.Printf("Screenshot %d/%d skipped: %s already exists\n", i, cfg.Count, record.Path)
+		case cfg.MaxSize > 0:
+			fmt.Printf("Screenshot %d/%d saved: %s (%s)\n", i, cfg.Count, record.Path, fitSummary(record))
+		default:
+			fmt.Printf("Screenshot %d/%d saved: %s\n", i, cfg.Count, record.Path)
 		}
 
-		// Generate output path
-		outputPath := output.GetOutputPath(cfg, templateProcessor)
+		// Wait before next screenshot (except for the last one)
+		waitForNext(ctx, cfg, i)
+	}
 
-		// For batch processing without template, ensure unique filenames
-		if cfg.Template == "" && cfg.Count > 1 {
-			// Generate unique filename with counter
-			ext := filepath.Ext(outputPath)
-			if ext == "" {
-				ext = "." + cfg.Format
-			}
-			baseName := strings.TrimSuffix(filepath.Base(outputPath), ext)
-			outputPath = filepath.Join(filepath.Dir(outputPath), fmt.Sprintf("%s_%03d%s", baseName, i, ext))
+	if err := p.finish(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Batch capture completed: %s\n", p.summary.String())
+	return p.summary.err()
+}
+
+// ProcessBatchWithProgress handles batch processing with detailed progress
+// information. An interrupt ends the run after the current screenshot.
+func ProcessBatchWithProgress(cfg *config.Config) error {
+	p, err := newProcessor(cfg)
+	if err != nil {
+		return err
+	}
+	defer p.close()
+
+	fmt.Printf("Starting batch capture: %d screenshots, %d second intervals\n", cfg.Count, cfg.Interval)
+	fmt.Printf("Output directory: %s\n", cfg.Dir)
+	fmt.Printf("Format: %s, Quality: %d\n", cfg.Format, cfg.Quality)
+
+	ctx, stop := notifyInterrupt()
+	defer stop()
+
+	startTime := time.Now()
+
+	for i := 1; i <= cfg.Count; i++ {
+		if ctx.Err() != nil {
+			fmt.Printf("Interrupted after %d of %d screenshots\n", i-1, cfg.Count)
+			break
 		}
 
-		// Ensure full path includes directory
-		if cfg.Dir != "." {
-			outputPath = filepath.Join(cfg.Dir, filepath.Base(outputPath))
+		iterationStart := time.Now()
+
+		record, err := p.process(ctx, i)
+		if err != nil && cfg.OnError == config.OnErrorAbort {
+			return p.abort(err)
 		}
 
-		// Create temporary config for saving
-		saveConfig := &config.Config{
-			OutputPath: outputPath,
-			Format:     cfg.Format,
-			Quality:    cfg.Quality,
+		iterationDuration := time.Since(iterationStart)
+
+		switch {
+		case err != nil:
+			fmt.Printf("[%d/%d] Failed after %d attempt(s): %v - %v\n",
+				i, cfg.Count, record.Attempts, err, iterationDuration)
+		case record.DuplicateOf != "":
+			fmt.Printf("[%d/%d] Skipped: duplicate of %s - %v\n",
+				i, cfg.Count, record.DuplicateOf, iterationDuration)
+		case record.Existing:
+			fmt.Printf("[%d/%d] Skipped: %s already exists - %v\n",
+				i, cfg.Count, record.Path, iterationDuration)
+		case cfg.MaxSize > 0:
+			fmt.Printf("[%d/%d] Saved: %s (%s) - %v\n",
+				i, cfg.Count, record.Path, fitSummary(record), iterationDuration)
+		default:
+			fmt.Printf("[%d/%d] Saved: %s (%dx%d) - %v\n",
+				i, cfg.Count, record.Path, record.Width, record.Height, iterationDuration)
 		}
 
-		// Save to file
-		if err := output.SaveToFile(img, saveConfig); err != nil {
-			return fmt.Errorf("failed to save screenshot %d: %w", i, err)
+		// Wait before next screenshot (except for the last one)
+		waitForNext(ctx, cfg, i)
+	}
+
+	if err := p.finish(); err != nil {
+		return err
+	}
+
+	totalDuration := time.Since(startTime)
+	fmt.Printf("Batch capture completed: %s in %v\n", p.summary.String(), totalDuration)
+
+	return p.summary.err()
+}
+
+// Summary counts the outcomes of a batch run
+type Summary struct {
+	Saved   int
+	Skipped int
+	Failed  []int // Sequence numbers of failed screenshots
+}
+
+// String formats the summary for the completion message
+func (s *Summary) String() string {
+	result := fmt.Sprintf("%d screenshots saved", s.Saved)
+	if s.Skipped > 0 {
+		result += fmt.Sprintf(", %d skipped", s.Skipped)
+	}
+	if len(s.Failed) > 0 {
+		result += fmt.Sprintf(", %d failed %v", len(s.Failed), s.Failed)
+	}
+	return result
+}
+
+// err returns a FailureError if any screenshot of the run failed
+func (s *Summary) err() error {
+	if len(s.Failed) == 0 {
+		return nil
+	}
+	return &FailureError{Failed: len(s.Failed), Total: s.Saved + s.Skipped + len(s.Failed)}
+}
+
+// FailureError reports that a batch run completed but some screenshots failed
+type FailureError struct {
+	Failed int
+	Total  int
+}
+
+// Error implements the error interface
+func (e *FailureError) Error() string {
+	return fmt.Sprintf("%d of %d screenshots failed", e.Failed, e.Total)
+}
+
+// processor holds the state shared by all iterations of a batch run
+type processor struct {
+	cfg               *config.Config
+	templateProcessor *config.TemplateProcessor
+	deduper           *Deduper
+	manifest          *Manifest
+	animation         *animation
+	retention         RetentionPolicy
+	retained          *retainedSet // Screenshots subject to retention, if enabled
+	resume            *resumeState
+	firstCounter      int
+	runID             string // Recorded with every capture of the run
+	summary           Summary
+}
+
+// newProcessor prepares the deduper and manifest configured for a batch run
+func newProcessor(cfg *config.Config) (*processor, error) {
+	p := &processor{
+		cfg:               cfg,
+		templateProcessor: cfg.NewTemplateProcessor(),
+		retention:         NewRetentionPolicy(cfg),
+		firstCounter:      1,
+		runID:             newRunID(time.Now()),
+	}
+
+	if cfg.Resume {
+		resume, firstCounter, err := newResumeState(cfg, p.templateProcessor)
+		if err != nil {
+			return nil, err
 		}
+		p.resume = resume
+		p.firstCounter = firstCounter
+		if firstCounter > 1 {
+			fmt.Printf("Resuming numbering at %d\n", firstCounter)
+		}
+	}
 
-		fmt.Printf("Screenshot %d/%d saved: %s\n", i, cfg.Count, outputPath)
+	deduper, err := newDeduper(cfg)
+	if err != nil {
+		return nil, err
+	}
+	p.deduper = deduper
 
-		// Copy to clipboard if requested
-		if cfg.Clipboard {
-			if err := output.CopyToClipboard(img); err != nil {
-				fmt.Printf("Warning: failed to copy screenshot %d to clipboard: %v\n", i, err)
-			}
+	animation, err := newAnimation(cfg)
+	if err != nil {
+		return nil, err
+	}
+	p.animation = animation
+
+	if cfg.Manifest != "" {
+		manifest, err := OpenManifest(ManifestPath(baseDir(cfg), cfg.Manifest), cfg.Manifest)
+		if err != nil {
+			return nil, err
 		}
+		p.manifest = manifest
+	}
 
-		// Wait before next screenshot (except for the last one)
-		if i < cfg.Count {
-			time.Sleep(time.Duration(cfg.Interval) * time.Second)
+	if p.retention.Enabled() {
+		retained, err := loadRetained(baseDir(cfg), ManifestPath(baseDir(cfg), cfg.Manifest), cfg.Manifest)
+		if err != nil {
+			return nil, err
 		}
+		p.retained = retained
 	}
 
-	fmt.Printf("Batch capture completed: %d screenshots saved\n", cfg.Count)
-	return nil
+	return p, nil
 }
 
-// ProcessBatchWithProgress handles batch processing with detailed progress information
-func ProcessBatchWithProgress(cfg *config.Config) error {
-	templateProcessor := cfg.NewTemplateProcessor()
+// process captures and saves the i-th screenshot of the batch, retrying
+// according to the error policy, and records the outcome in the manifest
+func (p *processor) process(ctx context.Context, i int) (*Record, error) {
+	// The counter used in file names continues from earlier runs when resuming
+	counter := p.firstCounter + i - 1
 
-	fmt.Printf("Starting batch capture: %d screenshots, %d second intervals\n", cfg.Count, cfg.Interval)
-	fmt.Printf("Output directory: %s\n", cfg.Dir)
-	fmt.Printf("Format: %s, Quality: %d\n", cfg.Format, cfg.Quality)
+	attempts := 1
+	if p.cfg.OnError == config.OnErrorRetry {
+		attempts += p.cfg.Retries
+	}
 
-	startTime := time.Now()
+	var record *Record
+	var err error
+	backoff := p.cfg.RetryBackoff
+	for attempt := 1; attempt <= attempts; attempt++ {
+		record, err = p.attempt(counter)
+		record.Attempts = attempt
+		if err == nil {
+			break
+		}
 
-	for i := 1; i <= cfg.Count; i++ {
-		iterationStart := time.Now()
+		if attempt == attempts {
+			break
+		}
+		fmt.Printf("Warning: screenshot %d attempt %d/%d failed: %v (retrying in %v)\n",
+			counter, attempt, attempts, err, backoff)
+		if !waitBackoff(ctx, backoff) {
+			break // Interrupted, the run ends after this screenshot
+		}
+		backoff *= 2
+	}
 
-		// Update counter for template processing
-		templateProcessor.SetCounter(i)
+	switch {
+	case err != nil:
+		record.Error = err.Error()
+		p.summary.Failed = append(p.summary.Failed, counter)
+	case record.DuplicateOf != "" || record.Existing:
+		p.summary.Skipped++
+	default:
+		p.summary.Saved++
+	}
 
-		// Capture screenshot
-		img, err := capture.CaptureScreen(cfg)
+	if writeErr := p.write(record); writeErr != nil {
 		if err != nil {
-			return fmt.Errorf("failed to capture screenshot %d: %w", i, err)
+			return record, fmt.Errorf("%w (%v)", err, writeErr)
 		}
+		return record, writeErr
+	}
 
-		// Generate output path
-		outputPath := output.GetOutputPath(cfg, templateProcessor)
+	if err == nil && record.DuplicateOf == "" && !record.Existing && p.resume != nil {
+		if resumeErr := p.resume.update(counter); resumeErr != nil {
+			fmt.Printf("Warning: failed to save resume state: %v\n", resumeErr)
+		}
+	}
 
-		// For batch processing without template, ensure unique filenames
-		if cfg.Template == "" && cfg.Count > 1 {
-			// Generate unique filename with counter
-			ext := filepath.Ext(outputPath)
-			if ext == "" {
-				ext = "." + cfg.Format
-			}
-			baseName := strings.TrimSuffix(filepath.Base(outputPath), ext)
-			outputPath = filepath.Join(filepath.Dir(outputPath), fmt.Sprintf("%s_%03d%s", baseName, i, ext))
+	if err == nil && record.DuplicateOf == "" && !record.Existing {
+		if pruneErr := p.prune(record); pruneErr != nil {
+			fmt.Printf("Warning: failed to enforce retention: %v\n", pruneErr)
 		}
+	}
+
+	return record, err
+}
+
+// prune adds the screenshot of record to the retained files and deletes the
+// oldest ones once the retention policy is exceeded
+func (p *processor) prune(record *Record) error {
+	if p.retained == nil {
+		return nil
+	}
+
+	p.retained.add(retainedFile{
+		path:      record.Path,
+		thumbnail: record.Thumbnail,
+		timestamp: record.Timestamp,
+		size:      record.Size + record.ThumbnailSize,
+	})
+	deleted, err := deleteRetained(baseDir(p.cfg), p.retained.expire(p.retention, time.Now()))
+	for _, path := range deleted {
+		fmt.Printf("Pruned: %s\n", path)
+	}
+	return err
+}
+
+// attempt captures and saves the screenshot numbered counter once
+func (p *processor) attempt(counter int) (*Record, error) {
+	cfg := p.cfg
+
+	// Update counter for template processing
+	p.templateProcessor.SetCounter(counter)
+
+	record := &Record{
+		RunID:    p.runID,
+		Sequence: counter,
+		Display:  cfg.Display,
+		Format:   cfg.Format,
+	}
+	if cfg.Region != nil {
+		record.Region = fmt.Sprintf("%d,%d,%d,%d", cfg.Region.X, cfg.Region.Y, cfg.Region.Width, cfg.Region.Height)
+	}
 
-		// Ensure full path includes directory
-		if cfg.Dir != "." {
-			outputPath = filepath.Join(cfg.Dir, filepath.Base(outputPath))
+	// Capture screenshot
+	img, capturedAt, err := captureScreen(cfg)
+	record.CaptureDuration = time.Since(capturedAt)
+	record.Timestamp = capturedAt
+	if cfg.Location != nil {
+		record.Timestamp = capturedAt.In(cfg.Location)
+	}
+	if err != nil {
+		return record, fmt.Errorf("failed to capture screenshot %d: %w", counter, err)
+	}
+	img = resize.Apply(img, cfg.Resize())
+
+	width, height, _ := output.GetImageInfo(img)
+	record.Width = width
+	record.Height = height
+	p.templateProcessor.SetImageSize(width, height)
+	p.templateProcessor.SetTime(capturedAt)
+
+	// Generate output path
+	outputPath, err := batchOutputPath(cfg, p.templateProcessor, counter)
+	if err != nil {
+		return record, fmt.Errorf("failed to resolve output path of screenshot %d: %w", counter, err)
+	}
+	record.Path = outputPath
+
+	// Skip frames that look like one we already saved
+	var hash uint64
+	if p.deduper != nil {
+		hash = DifferenceHash(img)
+		if original := p.deduper.FindDuplicate(hash); original != "" {
+			record.Path = ""
+			record.DuplicateOf = original
+			return record, nil
 		}
+	}
 
-		// Create temporary config for saving
-		saveConfig := &config.Config{
-			OutputPath: outputPath,
-			Format:     cfg.Format,
-			Quality:    cfg.Quality,
+	if p.animation != nil {
+		added, err := p.animation.add(outputPath, img, capturedAt)
+		if err != nil {
+			return record, fmt.Errorf("failed to add screenshot %d to the animation: %w", counter, err)
+		}
+		record.Path = p.animation.path
+		if !added {
+			record.Existing = true
+			return record, nil
 		}
+	} else {
+		// Save with the run's options to the resolved path
+		saveConfig := *cfg
+		saveConfig.OutputPath = outputPath
+		saveConfig.Template = ""
+		saveConfig.CaptureTime = capturedAt
 
 		// Save to file
-		if err := output.SaveToFile(img, saveConfig); err != nil {
-			return fmt.Errorf("failed to save screenshot %d: %w", i, err)
+		result, err := output.SaveToFileWithResult(img, &saveConfig)
+		if err != nil {
+			return record, fmt.Errorf("failed to save screenshot %d: %w", counter, err)
 		}
 
-		// Get image info for progress
-		width, height, _ := output.GetImageInfo(img)
-		iterationDuration := time.Since(iterationStart)
-
-		fmt.Printf("[%d/%d] Saved: %s (%dx%d) - %v\n",
-			i, cfg.Count, outputPath, width, height, iterationDuration)
+		record.Path = result.Path
+		if result.Skipped {
+			record.Existing = true
+			return record, nil
+		}
+		record.Size = result.Size
+		record.SHA256 = result.SHA256
+		record.EncodeDuration = result.EncodeDuration
+		record.Width, record.Height = result.Width, result.Height
+		record.Quality = result.Quality
+		if cfg.Verbose {
+			for _, note := range result.Notes {
+				fmt.Printf("Screenshot %d: %s\n", counter, note)
+			}
+		}
 
-		// Copy to clipboard if requested
-		if cfg.Clipboard {
-			if err := output.CopyToClipboard(img); err != nil {
-				fmt.Printf("Warning: failed to copy screenshot %d to clipboard: %v\n", i, err)
+		if cfg.Thumbnail() {
+			thumb, err := output.SaveThumbnail(img, result.Path, cfg, p.templateProcessor)
+			if err != nil {
+				return record, fmt.Errorf("failed to save thumbnail of screenshot %d: %w", counter, err)
+			}
+			if !thumb.Skipped {
+				record.Thumbnail = thumb.Path
+				record.ThumbnailSize = thumb.Size
 			}
 		}
+	}
 
-		// Wait before next screenshot (except for the last one)
-		if i < cfg.Count {
-			time.Sleep(time.Duration(cfg.Interval) * time.Second)
+	if p.deduper != nil {
+		p.deduper.Add(hash, record.Path)
+	}
+
+	// Copy to clipboard if requested
+	if cfg.Clipboard {
+		if err := output.CopyToClipboard(img); err != nil {
+			fmt.Printf("Warning: failed to copy screenshot %d to clipboard: %v\n", counter, err)
 		}
 	}
 
-	totalDuration := time.Since(startTime)
-	fmt.Printf("Batch capture completed: %d screenshots in %v\n", cfg.Count, totalDuration)
+	return record, nil
+}
+
+// fitSummary describes how a screenshot was fitted within --max-size
+func fitSummary(record *Record) string {
+	if record.Quality > 0 {
+		return fmt.Sprintf("%dx%d, quality %d, %d bytes", record.Width, record.Height, record.Quality, record.Size)
+	}
+	return fmt.Sprintf("%dx%d, %d bytes", record.Width, record.Height, record.Size)
+}
+
+// write appends record to the manifest if one is configured
+func (p *processor) write(record *Record) error {
+	if p.manifest == nil {
+		return nil
+	}
+	return p.manifest.Write(record)
+}
 
+// finish completes the animation and persists state that outlives the run
+func (p *processor) finish() error {
+	if p.animation != nil {
+		if err := p.animation.close(); err != nil {
+			return err
+		}
+	}
+	if p.deduper != nil {
+		if err := p.deduper.Save(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// abort ends the run after err under --on-error abort, persisting the state
+// of the screenshots saved so far like a completed run
+func (p *processor) abort(err error) error {
+	if finishErr := p.finish(); finishErr != nil {
+		return fmt.Errorf("%w (%v)", err, finishErr)
+	}
+	return err
+}
+
+// close releases resources held by the processor. An animation not yet
+// completed by finish keeps the frames of the aborted run.
+func (p *processor) close() {
+	if p.animation != nil {
+		if err := p.animation.close(); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}
+	if p.manifest != nil {
+		p.manifest.Close()
+	}
+}
+
+// batchOutputPath resolves the output path of the screenshot numbered counter
+func batchOutputPath(cfg *config.Config, templateProcessor *config.TemplateProcessor, counter int) (string, error) {
+	outputPath, err := output.GetOutputPath(cfg, templateProcessor)
+	if err != nil {
+		return "", err
+	}
+
+	// For batch processing without template, ensure unique filenames
+	// An animation collects all screenshots in one file
+	if cfg.Template == "" && cfg.Count > 1 && cfg.Animate == "" {
+		// Generate unique filename with counter
+		ext := filepath.Ext(outputPath)
+		if ext == "" {
+			ext = "." + cfg.Format
+		}
+		baseName := strings.TrimSuffix(filepath.Base(outputPath), ext)
+		outputPath = filepath.Join(filepath.Dir(outputPath), fmt.Sprintf("%s_%03d%s", baseName, counter, ext))
+	}
+
+	// Ensure full path includes directory, which may itself be a template
+	if cfg.Dir != "." {
+		dir, err := templateProcessor.ExpandTemplate(cfg.Dir, cfg)
+		if err != nil {
+			return "", fmt.Errorf("invalid directory template: %w", err)
+		}
+		outputPath = filepath.Join(dir, filepath.Base(outputPath))
+	}
+
+	return outputPath, nil
+}
+
+// newDeduper creates the perceptual hash deduper if deduplication is enabled
+func newDeduper(cfg *config.Config) (*Deduper, error) {
+	if cfg.Dedupe == "" {
+		return nil, nil
+	}
+
+	indexPath := ""
+	if cfg.DedupeIndex {
+		indexPath = filepath.Join(baseDir(cfg), DedupeIndexFile)
+	}
+
+	return NewDeduper(cfg.DedupeThreshold, indexPath)
+}
+
+// baseDir returns the directory holding state shared by the whole run, such as
+// the manifest, which is the fixed part of a templated output directory
+func baseDir(cfg *config.Config) string {
+	return config.TemplateBaseDir(cfg.Dir)
+}
@@ -0,0 +1,191 @@
+package batch
+
+import (
+	"context"
+	"errors"
+	"image"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/funnyzak/screenshot-cli/internal/config"
+	"github.com/funnyzak/screenshot-cli/internal/output"
+)
+
+// stubCapture replaces the screen capture for the duration of the test
+func stubCapture(t *testing.T, capture func(cfg *config.Config) (image.Image, time.Time, error)) {
+	t.Helper()
+	original := captureScreen
+	captureScreen = capture
+	t.Cleanup(func() { captureScreen = original })
+}
+
+// noiseFrame returns an image whose encoded size depends on quality and size;
+// frames of different seeds are no duplicates of each other
+func noiseFrame(seed int64) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 256, 160))
+	rand.New(rand.NewSource(seed)).Read(img.Pix)
+	return img
+}
+
+func TestProcessBatchMaxSize(t *testing.T) {
+	calls := 0
+	stubCapture(t, func(*config.Config) (image.Image, time.Time, error) {
+		calls++
+		return noiseFrame(int64(calls)), time.Now(), nil
+	})
+
+	full, err := output.EncodeImage(noiseFrame(1), "jpg", output.EncodeOptions{Quality: 95})
+	if err != nil {
+		t.Fatal(err)
+	}
+	maxSize := int64(len(full)) * 3 / 4
+
+	dir := t.TempDir()
+	cfg := &config.Config{
+		OutputPath: "screenshot.jpg",
+		Dir:        dir,
+		Format:     "jpg",
+		Quality:    95,
+		MaxSize:    maxSize,
+		Count:      3,
+		Manifest:   ManifestCSV,
+	}
+	if err := ProcessBatch(cfg); err != nil {
+		t.Fatalf("ProcessBatch() error = %v", err)
+	}
+
+	records, err := ReadManifest(ManifestPath(dir, ManifestCSV), ManifestCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != cfg.Count {
+		t.Fatalf("manifest holds %d records, want %d", len(records), cfg.Count)
+	}
+	for _, record := range records {
+		info, err := os.Stat(filepath.Join(dir, record.Path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > maxSize || record.Size != info.Size() {
+			t.Errorf("%s: saved %d bytes (record %d), want at most %d", record.Path, info.Size(), record.Size, maxSize)
+		}
+		if record.Quality <= 0 || record.Quality >= 95 {
+			t.Errorf("%s: quality = %d, want below 95", record.Path, record.Quality)
+		}
+		if record.RunID == "" || record.RunID != records[0].RunID {
+			t.Errorf("%s: run ID = %q, want the one of the run", record.Path, record.RunID)
+		}
+	}
+}
+
+func TestProcessBatchOnError(t *testing.T) {
+	tests := []struct {
+		name      string
+		onError   string
+		retries   int
+		failCalls []int // Captures that fail, counted across attempts
+		wantFiles int
+		wantWaits []time.Duration
+		wantErr   error // FailureError, or nil for any other error
+		wantOK    bool
+	}{
+		{"continue", config.OnErrorContinue, 0, []int{2}, 2, nil, &FailureError{Failed: 1, Total: 3}, false},
+		{"retry succeeds", config.OnErrorRetry, 3, []int{2, 3}, 3, []time.Duration{time.Second, 2 * time.Second}, nil, true},
+		{"retries exhausted", config.OnErrorRetry, 2, []int{2, 3, 4}, 2,
+			[]time.Duration{time.Second, 2 * time.Second}, &FailureError{Failed: 1, Total: 3}, false},
+		{"abort", config.OnErrorAbort, 0, []int{2}, 1, nil, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			stubCapture(t, func(*config.Config) (image.Image, time.Time, error) {
+				calls++
+				for _, call := range tt.failCalls {
+					if call == calls {
+						return nil, time.Now(), errors.New("display unavailable")
+					}
+				}
+				return noiseFrame(int64(calls)), time.Now(), nil
+			})
+			var waits []time.Duration
+			original := waitBackoff
+			waitBackoff = func(ctx context.Context, d time.Duration) bool {
+				waits = append(waits, d)
+				return true
+			}
+			t.Cleanup(func() { waitBackoff = original })
+
+			dir := t.TempDir()
+			cfg := &config.Config{
+				OutputPath:   "screenshot.png",
+				Dir:          dir,
+				Format:       "png",
+				Count:        3,
+				OnError:      tt.onError,
+				Retries:      tt.retries,
+				RetryBackoff: time.Second,
+				Dedupe:       "phash",
+				DedupeIndex:  true,
+			}
+			err := ProcessBatch(cfg)
+
+			var failureErr *FailureError
+			switch {
+			case tt.wantOK:
+				if err != nil {
+					t.Fatalf("ProcessBatch() error = %v", err)
+				}
+			case tt.wantErr != nil:
+				if !errors.As(err, &failureErr) || !reflect.DeepEqual(failureErr, tt.wantErr) {
+					t.Fatalf("ProcessBatch() error = %v, want %v", err, tt.wantErr)
+				}
+			case err == nil || errors.As(err, &failureErr):
+				t.Fatalf("ProcessBatch() error = %v, want the capture error", err)
+			}
+
+			files, _ := filepath.Glob(filepath.Join(dir, "screenshot_*.png"))
+			if len(files) != tt.wantFiles {
+				t.Errorf("saved %d files, want %d", len(files), tt.wantFiles)
+			}
+			if !reflect.DeepEqual(waits, tt.wantWaits) {
+				t.Errorf("backoffs = %v, want %v", waits, tt.wantWaits)
+			}
+
+			// The dedupe index is saved even when the run is aborted
+			d, err := NewDeduper(0, filepath.Join(dir, DedupeIndexFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(d.entries) != tt.wantFiles {
+				t.Errorf("dedupe index holds %d entries, want %d", len(d.entries), tt.wantFiles)
+			}
+		})
+	}
+}
+
+func TestSummary(t *testing.T) {
+	tests := []struct {
+		summary Summary
+		want    string
+		wantErr string
+	}{
+		{Summary{Saved: 3}, "3 screenshots saved", ""},
+		{Summary{Saved: 2, Skipped: 1}, "2 screenshots saved, 1 skipped", ""},
+		{Summary{Saved: 1, Skipped: 1, Failed: []int{2, 4}}, "1 screenshots saved, 1 skipped, 2 failed [2 4]", "2 of 4 screenshots failed"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.summary.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+		err := tt.summary.err()
+		if (err == nil) != (tt.wantErr == "") || err != nil && err.Error() != tt.wantErr {
+			t.Errorf("err() = %v, want %q", err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package batch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/funnyzak/screenshot-cli/internal/config"
+	"github.com/funnyzak/screenshot-cli/internal/output"
+)
+
+// ResumeStateFile is the name of the file persisting batch counters
+const ResumeStateFile = ".sshot-state.json"
+
+// resumeState persists the last counter used for each naming pattern in an
+// output directory, so numbering survives interrupted runs and pruned files
+type resumeState struct {
+	path     string
+	key      string
+	Counters map[string]int `json:"counters"`
+}
+
+// newResumeState determines the first counter of a resumed batch run. It
+// scans the output directories for files matching the naming pattern of cfg
+// and continues after the highest counter found there or persisted by an
+// earlier run. With a templated directory, such as ./shots/{date}, every
+// directory the template may have expanded to is scanned.
+func newResumeState(cfg *config.Config, templateProcessor *config.TemplateProcessor) (*resumeState, int, error) {
+	pattern, err := config.CounterPattern(namingTemplate(cfg), cfg)
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot resume: %w", err)
+	}
+
+	// Screenshots are written to directories at the same depth below the
+	// fixed part of the directory, which also holds the state
+	templateProcessor.SetCounter(1)
+	firstPath, err := batchOutputPath(cfg, templateProcessor, 1)
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot resume: %w", err)
+	}
+	root, depth := baseDir(cfg), 0
+	rel, err := filepath.Rel(root, filepath.Dir(firstPath))
+	switch {
+	case err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)):
+		root = filepath.Dir(firstPath)
+	case rel != ".":
+		depth = len(strings.Split(rel, string(filepath.Separator)))
+	}
+
+	state := &resumeState{
+		path:     filepath.Join(root, ResumeStateFile),
+		key:      pattern.String(),
+		Counters: make(map[string]int),
+	}
+
+	data, err := os.ReadFile(state.path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, state); err != nil {
+			return nil, 0, fmt.Errorf("invalid resume state %s: %w", state.path, err)
+		}
+		if state.Counters == nil {
+			state.Counters = make(map[string]int)
+		}
+	case !os.IsNotExist(err):
+		return nil, 0, fmt.Errorf("failed to read resume state: %w", err)
+	}
+
+	last := state.Counters[state.key]
+
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root && os.IsNotExist(err) {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		level := 0
+		if rel, _ := filepath.Rel(root, path); rel != "." {
+			level = len(strings.Split(rel, string(filepath.Separator)))
+		}
+
+		if entry.IsDir() {
+			if level > depth {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if level != depth+1 {
+			return nil
+		}
+		match := pattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil
+		}
+		if n, err := strconv.Atoi(match[1]); err == nil && n > last {
+			last = n
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to scan %s: %w", root, err)
+	}
+
+	return state, last + 1, nil
+}
+
+// update records counter as used and persists the state
+func (s *resumeState) update(counter int) error {
+	if counter <= s.Counters[s.key] {
+		return nil
+	}
+	s.Counters[s.key] = counter
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode resume state: %w", err)
+	}
+
+	if err := ensureDir(filepath.Dir(s.path)); err != nil {
+		return err
+	}
+
+	// A crash while writing must not leave a truncated state behind
+	if err := output.WriteFileAtomic(s.path, data, false); err != nil {
+		return fmt.Errorf("failed to write resume state: %w", err)
+	}
+
+	return nil
+}
+
+// namingTemplate returns the file name template of a batch run, expressing
+// the automatic numbering used without -t as a {counter} template
+func namingTemplate(cfg *config.Config) string {
+	if cfg.Template != "" {
+		return filepath.Base(cfg.Template)
+	}
+
+	outputPath := cfg.OutputPath
+	if outputPath == "" {
+		outputPath = "screenshot.png"
+	}
+	ext := filepath.Ext(outputPath)
+	if ext == "" {
+		ext = "." + cfg.Format
+	}
+	base := strings.TrimSuffix(filepath.Base(outputPath), ext)
+	escape := strings.NewReplacer("{", "{{", "}", "}}")
+	return escape.Replace(base) + "_{counter}" + escape.Replace(ext)
+}
@@ -0,0 +1,71 @@
+package batch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/funnyzak/screenshot-cli/internal/config"
+)
+
+func TestResumeCounter(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"screenshot_001.png", "screenshot_004.png", "other_099.png"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := &config.Config{OutputPath: "screenshot.png", Format: "png", Count: 10, Dir: dir, Resume: true}
+
+	state, first, err := newResumeState(cfg, cfg.NewTemplateProcessor())
+	if err != nil {
+		t.Fatalf("newResumeState() error = %v", err)
+	}
+	if first != 5 {
+		t.Errorf("first counter = %d, want 5", first)
+	}
+
+	// Persisted counters win over files that have since been deleted
+	if err := state.update(9); err != nil {
+		t.Fatalf("update() error = %v", err)
+	}
+	_, first, err = newResumeState(cfg, cfg.NewTemplateProcessor())
+	if err != nil {
+		t.Fatalf("newResumeState() error = %v", err)
+	}
+	if first != 10 {
+		t.Errorf("first counter after update = %d, want 10", first)
+	}
+}
+
+func TestResumeCounterTemplatedDirectory(t *testing.T) {
+	base := t.TempDir()
+	// Written on earlier days; the file in the base directory is no screenshot of the run
+	for _, name := range []string{
+		filepath.Join("20240601", "screenshot_003.png"),
+		filepath.Join("20240602", "screenshot_005.png"),
+		"screenshot_009.png",
+	} {
+		path := filepath.Join(base, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := &config.Config{OutputPath: "screenshot.png", Format: "png", Count: 10, Dir: filepath.Join(base, "{date}"), Resume: true}
+
+	state, first, err := newResumeState(cfg, cfg.NewTemplateProcessor())
+	if err != nil {
+		t.Fatalf("newResumeState() error = %v", err)
+	}
+	if first != 6 {
+		t.Errorf("first counter = %d, want 6", first)
+	}
+	if want := filepath.Join(base, ResumeStateFile); state.path != want {
+		t.Errorf("state path = %s, want %s", state.path, want)
+	}
+}
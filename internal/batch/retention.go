@@ -0,0 +1,221 @@
+package batch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/funnyzak/screenshot-cli/internal/config"
+)
+
+// RetentionPolicy limits how many screenshots are kept in an output directory
+type RetentionPolicy struct {
+	KeepLast int           // Keep at most this many files (0 = unlimited)
+	MaxAge   time.Duration // Delete files older than this (0 = unlimited)
+	MaxBytes int64         // Keep the total size below this (0 = unlimited)
+}
+
+// NewRetentionPolicy returns the retention policy configured in cfg
+func NewRetentionPolicy(cfg *config.Config) RetentionPolicy {
+	return RetentionPolicy{
+		KeepLast: cfg.KeepLast,
+		MaxAge:   cfg.MaxAge,
+		MaxBytes: cfg.MaxBytes,
+	}
+}
+
+// Enabled reports whether any retention limit is set
+func (r RetentionPolicy) Enabled() bool {
+	return r.KeepLast > 0 || r.MaxAge > 0 || r.MaxBytes > 0
+}
+
+// retainedFile is a screenshot recorded in the manifest that still exists
+type retainedFile struct {
+	path      string
+	thumbnail string // Thumbnail deleted along with the screenshot, if it exists
+	timestamp time.Time
+	size      int64 // Size of the screenshot and its thumbnail
+	replaced  bool  // Overwritten by a later screenshot of the same path
+}
+
+// Prune deletes the oldest screenshots in dir until the policy is satisfied.
+// Only files recorded in the directory's manifest are considered, so files
+// that sshot did not create are never touched. With dryRun set the files
+// are reported but not deleted.
+func Prune(dir string, policy RetentionPolicy, now time.Time, dryRun bool) ([]string, error) {
+	if !policy.Enabled() {
+		return nil, nil
+	}
+
+	manifestPath, format, err := FindManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	retained, err := loadRetained(dir, manifestPath, format)
+	if err != nil {
+		return nil, err
+	}
+
+	expired := retained.expire(policy, now)
+	if dryRun {
+		var paths []string
+		for _, file := range expired {
+			paths = append(paths, file.paths()...)
+		}
+		return paths, nil
+	}
+	return deleteRetained(dir, expired)
+}
+
+// retainedSet holds the retained screenshots of a directory, oldest first. A
+// batch run loads it once and adds every screenshot it saves, so enforcing
+// the policy after each save does not re-read the manifest.
+type retainedSet struct {
+	files  []*retainedFile
+	byPath map[string]*retainedFile // Current file of each path
+	count  int                      // Number of files not replaced
+	total  int64                    // Size of files not replaced
+}
+
+// loadRetained collects the screenshots recorded in the manifest of dir
+func loadRetained(dir, manifestPath, format string) (*retainedSet, error) {
+	records, err := ReadManifest(manifestPath, format)
+	if err != nil {
+		return nil, err
+	}
+
+	files := existingFiles(dir, records)
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].timestamp.Before(files[j].timestamp)
+	})
+
+	s := &retainedSet{byPath: make(map[string]*retainedFile, len(files))}
+	for i := range files {
+		s.add(files[i])
+	}
+	return s, nil
+}
+
+// add records a newly saved screenshot. An earlier file of the same path was
+// overwritten and no longer counts.
+func (s *retainedSet) add(file retainedFile) {
+	if old := s.byPath[file.path]; old != nil {
+		old.replaced = true
+		s.count--
+		s.total -= old.size
+	}
+	s.files = append(s.files, &file)
+	s.byPath[file.path] = &file
+	s.count++
+	s.total += file.size
+}
+
+// expire removes the oldest files from the set until the policy is satisfied
+// and returns them
+func (s *retainedSet) expire(policy RetentionPolicy, now time.Time) []retainedFile {
+	var expired []retainedFile
+	for len(s.files) > 0 {
+		file := s.files[0]
+		if !file.replaced && !(policy.KeepLast > 0 && s.count > policy.KeepLast ||
+			policy.MaxAge > 0 && now.Sub(file.timestamp) > policy.MaxAge ||
+			policy.MaxBytes > 0 && s.total > policy.MaxBytes) {
+			break
+		}
+
+		s.files = s.files[1:]
+		if file.replaced {
+			continue
+		}
+		delete(s.byPath, file.path)
+		s.count--
+		s.total -= file.size
+		expired = append(expired, *file)
+	}
+	return expired
+}
+
+// paths returns the screenshot and, if it exists, its thumbnail
+func (f retainedFile) paths() []string {
+	if f.thumbnail == "" {
+		return []string{f.path}
+	}
+	return []string{f.path, f.thumbnail}
+}
+
+// deleteRetained deletes expired files and the directories they leave empty
+// below dir, returning the deleted paths
+func deleteRetained(dir string, expired []retainedFile) ([]string, error) {
+	var deleted []string
+	for _, file := range expired {
+		for _, path := range file.paths() {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return deleted, fmt.Errorf("failed to delete %s: %w", path, err)
+			}
+			deleted = append(deleted, path)
+			removeEmptyParents(filepath.Dir(path), dir)
+		}
+	}
+	return deleted, nil
+}
+
+// removeEmptyParents removes dir and its parents up to (excluding) root as
+// long as they are empty, cleaning up directories of templated output paths
+func removeEmptyParents(dir, root string) {
+	root = filepath.Clean(root)
+	for dir = filepath.Clean(dir); dir != root && dir != "." && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if !strings.HasPrefix(dir, root+string(filepath.Separator)) && root != "." {
+			return
+		}
+		// Remove fails for directories that still contain files
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+	}
+}
+
+// existingFiles collects the saved screenshots of records that are still on
+// disk. When a path was written more than once only its latest record counts.
+func existingFiles(dir string, records []Record) []retainedFile {
+	latest := make(map[string]int)
+	var files []retainedFile
+
+	for _, record := range records {
+		if record.Path == "" || record.Error != "" || record.DuplicateOf != "" || record.Existing {
+			continue
+		}
+
+		path := record.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		file := retainedFile{path: path, timestamp: record.Timestamp, size: info.Size()}
+		if record.Thumbnail != "" {
+			thumbnail := record.Thumbnail
+			if !filepath.IsAbs(thumbnail) {
+				thumbnail = filepath.Join(dir, thumbnail)
+			}
+			if info, err := os.Stat(thumbnail); err == nil && info.Mode().IsRegular() {
+				file.thumbnail = thumbnail
+				file.size += info.Size()
+			}
+		}
+		if i, ok := latest[path]; ok {
+			files[i] = file
+			continue
+		}
+		latest[path] = len(files)
+		files = append(files, file)
+	}
+
+	return files
+}
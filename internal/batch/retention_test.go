@@ -0,0 +1,172 @@
+package batch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPrune(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		want   []string
+	}{
+		{"keep last two", RetentionPolicy{KeepLast: 2}, []string{"shot_001.png", "shot_002.png"}},
+		{"max age", RetentionPolicy{MaxAge: 36 * time.Hour}, []string{"shot_001.png"}},
+		{"max bytes", RetentionPolicy{MaxBytes: 250}, []string{"shot_001.png", "shot_002.png"}},
+		{"disabled", RetentionPolicy{}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			m, err := OpenManifest(ManifestPath(dir, ManifestJSONL), ManifestJSONL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, age := range []time.Duration{48 * time.Hour, 24 * time.Hour, time.Hour, 0} {
+				path := filepath.Join(dir, fmt.Sprintf("shot_%03d.png", i+1))
+				if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := m.Write(&Record{Sequence: i + 1, Timestamp: now.Add(-age), Path: path}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Files not recorded in the manifest must survive
+			if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("keep"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			m.Close()
+
+			deleted, err := Prune(dir, tt.policy, now, false)
+			if err != nil {
+				t.Fatalf("Prune() error = %v", err)
+			}
+
+			if len(deleted) != len(tt.want) {
+				t.Fatalf("Prune() deleted %v, want %v", deleted, tt.want)
+			}
+			for i, path := range deleted {
+				if filepath.Base(path) != tt.want[i] {
+					t.Errorf("Prune() deleted %v, want %v", deleted, tt.want)
+				}
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Errorf("%s still exists", path)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+				t.Errorf("unrelated file was removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestPruneThumbnails(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+
+	m, err := OpenManifest(ManifestPath(dir, ManifestJSONL), ManifestJSONL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, age := range []time.Duration{24 * time.Hour, 0} {
+		path := filepath.Join(dir, fmt.Sprintf("shot_%03d.png", i+1))
+		thumbnail := filepath.Join(dir, fmt.Sprintf("shot_%03d.thumb.jpg", i+1))
+		for _, p := range []string{path, thumbnail} {
+			if err := os.WriteFile(p, make([]byte, 100), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := m.Write(&Record{Sequence: i + 1, Timestamp: now.Add(-age), Path: path, Thumbnail: thumbnail}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.Close()
+
+	// A screenshot and its thumbnail count together towards the size limit
+	deleted, err := Prune(dir, RetentionPolicy{MaxBytes: 300}, now, false)
+	if err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	want := []string{"shot_001.png", "shot_001.thumb.jpg"}
+	if len(deleted) != len(want) {
+		t.Fatalf("Prune() deleted %v, want %v", deleted, want)
+	}
+	for i, path := range deleted {
+		if filepath.Base(path) != want[i] {
+			t.Errorf("Prune() deleted %v, want %v", deleted, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "shot_002.thumb.jpg")); err != nil {
+		t.Errorf("thumbnail of a kept screenshot was removed: %v", err)
+	}
+}
+
+func TestRetainedSet(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+
+	manifestPath := ManifestPath(dir, ManifestJSONL)
+	m, err := OpenManifest(manifestPath, ManifestJSONL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Recorded out of order; the set is ordered by capture time
+	for _, i := range []int{2, 1} {
+		path := filepath.Join(dir, fmt.Sprintf("shot_%03d.png", i))
+		if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Write(&Record{Sequence: i, Timestamp: now.Add(time.Duration(i) * time.Minute), Path: path}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.Close()
+
+	retained, err := loadRetained(dir, manifestPath, ManifestJSONL)
+	if err != nil {
+		t.Fatalf("loadRetained() error = %v", err)
+	}
+	// Saves during the run are tracked without reading the manifest again
+	if err := os.Remove(manifestPath); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := RetentionPolicy{KeepLast: 2, MaxBytes: 250}
+	for i, want := range [][]string{{"shot_001.png"}, {"shot_002.png"}} {
+		sequence := i + 3
+		retained.add(retainedFile{
+			path:      filepath.Join(dir, fmt.Sprintf("shot_%03d.png", sequence)),
+			timestamp: now.Add(time.Duration(sequence) * time.Minute),
+			size:      100,
+		})
+
+		var got []string
+		for _, file := range retained.expire(policy, now) {
+			got = append(got, filepath.Base(file.path))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("save %d: expire() = %v, want %v", sequence, got, want)
+		}
+		if retained.count != 2 || retained.total != 200 {
+			t.Errorf("save %d: %d files of %d bytes retained, want 2 of 200", sequence, retained.count, retained.total)
+		}
+	}
+
+	// Overwriting a retained path replaces its file instead of expiring one
+	retained.add(retainedFile{path: filepath.Join(dir, "shot_003.png"), timestamp: now.Add(5 * time.Minute), size: 100})
+	if expired := retained.expire(policy, now); len(expired) != 0 {
+		t.Errorf("expire() after overwrite = %v, want none", expired)
+	}
+	if retained.count != 2 || retained.total != 200 {
+		t.Errorf("after overwrite: %d files of %d bytes retained, want 2 of 200", retained.count, retained.total)
+	}
+}
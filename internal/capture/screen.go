@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"image"
 	"runtime"
+	"time"
 
 	"github.com/funnyzak/screenshot-cli/internal/config"
 	"github.com/kbinani/screenshot"
@@ -11,10 +12,25 @@ import (
 
 // CaptureScreen captures a screenshot based on the configuration
 func CaptureScreen(config *config.Config) (image.Image, error) {
+	img, _, err := CaptureScreenWithTime(config)
+	return img, err
+}
+
+// CaptureScreenWithTime captures a screenshot like CaptureScreen and also
+// returns the instant of the capture, so file names and manifests can refer
+// to a single consistent timestamp
+func CaptureScreenWithTime(config *config.Config) (image.Image, time.Time, error) {
+	capturedAt := time.Now()
+
+	var img image.Image
+	var err error
 	if config.Region != nil {
-		return captureRegion(config.Region)
+		img, err = captureRegion(config.Region)
+	} else {
+		img, err = captureFullScreen(config.Display)
 	}
-	return captureFullScreen(config.Display)
+
+	return img, capturedAt, err
 }
 
 // captureFullScreen captures the entire screen using kbinani/screenshot
@@ -2,10 +2,17 @@ package config
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+
+	"github.com/funnyzak/screenshot-cli/internal/animate"
+	"github.com/funnyzak/screenshot-cli/internal/formats"
+	"github.com/funnyzak/screenshot-cli/internal/resize"
 )
 
 // Config holds all configuration for the screenshot tool
@@ -15,20 +22,77 @@ type Config struct {
 	OutputPath string
 	Display    int // Display index to capture
 
+	// Resizing applied to captures before they are saved
+	Scale         float64 // Scale factor, 0 or 1 for none
+	MaxWidth      int     // Maximum width in pixels, 0 for no limit
+	MaxHeight     int     // Maximum height in pixels, 0 for no limit
+	Interpolation string  // Interpolation method: nearest, bilinear or catmull-rom
+
+	// Thumbnail written next to each screenshot
+	ThumbnailWidth    int    // Maximum thumbnail width, 0 for no limit
+	ThumbnailHeight   int    // Maximum thumbnail height, 0 for no limit
+	ThumbnailTemplate string // Thumbnail path template, relative to the screenshot
+	ThumbnailFormat   string
+	ThumbnailQuality  int
+
 	// Output control
-	Format    string
-	Quality   int
-	Clipboard bool
-	Template  string
+	Format          string
+	Quality         int
+	Lossless        bool   // Lossless compression for formats that offer it (webp)
+	TIFFCompression string // TIFF compression: none, deflate or lzw
+	PNGCompression  string // PNG compression level: none, fast, default or best
+	PNGPalette      bool   // Write PNGs with at most 256 colors as paletted images
+	Colors          int    // Palette size of paletted output, 0 unless set with --colors
+	Dither          string // Dithering of paletted output: none or floyd-steinberg
+	PDFCompression  string // PDF page image compression: jpeg or flate
+	PDFCaption      bool   // Add a caption line with time, display and hostname to PDF pages
+	MaxSize         int64  // Largest encoded size in bytes, reached by lowering quality and size (0 = unlimited)
+	Clipboard       bool
+	Template        string
+	IfExists        string // Policy for existing files: overwrite, skip, error or suffix
+	Fsync           bool   // Flush files to stable storage before renaming them into place
+	Verbose         bool   // Print details such as encoder notes
+	Encoding        string // Text encoding of stdout output: "" (raw), base64 or data-url
+	ForceStdout     bool   // Write raw image data to stdout even if it is a terminal
 
 	// Batch processing
 	Count    int
 	Interval int
 	Prefix   string
 	Dir      string
+	Resume   bool // Continue numbering after the highest existing counter
+
+	// Animation
+	Animate        string // Animation format collecting all frames of a run: "" (disabled) or e.g. "gif"
+	Loop           int    // Number of times the animation plays, 0 forever
+	AnimatePalette string // Palette of paletted animations: frame or shared
+	FPS            int    // Frame rate of video animations
+
+	// Deduplication
+	Dedupe          string // Deduplication mode: "" (disabled) or "phash"
+	DedupeThreshold int    // Maximum Hamming distance treated as a duplicate
+	DedupeIndex     bool   // Persist the hash index in the output directory
+
+	// Manifest
+	Manifest string // Manifest format: "" (disabled), "jsonl" or "csv"
+
+	// Error handling
+	OnError      string        // Batch error policy: abort, continue or retry
+	Retries      int           // Number of retries per screenshot in retry mode
+	RetryBackoff time.Duration // Delay before the first retry, doubled on each attempt
+
+	// Retention
+	KeepLast int           // Keep at most this many screenshots (0 = unlimited)
+	MaxAge   time.Duration // Delete screenshots older than this (0 = unlimited)
+	MaxBytes int64         // Keep the output directory below this size (0 = unlimited)
+	DryRun   bool          // Report what would be pruned without deleting
+
+	// Time zone used for date and time template variables
+	Location *time.Location
 
 	// Internal
-	Counter int
+	Counter     int
+	CaptureTime time.Time // Instant of the capture being saved, used for templates
 }
 
 // Region represents a screenshot region
@@ -44,6 +108,31 @@ const (
 	ExitCaptureError   = 3
 	ExitFormatError    = 4
 	ExitClipboardError = 5
+	ExitBatchFailure   = 6
+)
+
+// Policies for output files that already exist
+const (
+	IfExistsOverwrite = "overwrite"
+	IfExistsSkip      = "skip"
+	IfExistsError     = "error"
+	IfExistsSuffix    = "suffix"
+)
+
+// StdoutPath is the output path that writes the image to stdout
+const StdoutPath = "-"
+
+// Text encodings of images written to stdout
+const (
+	EncodingBase64  = "base64"
+	EncodingDataURL = "data-url"
+)
+
+// Batch error policies
+const (
+	OnErrorAbort    = "abort"
+	OnErrorContinue = "continue"
+	OnErrorRetry    = "retry"
 )
 
 // ParseArgs parses command line arguments and returns a Config
@@ -66,6 +155,10 @@ func ParseArgs(cmd *cobra.Command, args []string) (*Config, error) {
 	}
 	config.Display = display
 
+	if err := parseResize(cmd, config); err != nil {
+		return nil, err
+	}
+
 	// Parse output path
 	outputFlag := cmd.Flags().Lookup("output")
 	outputPath, _ := cmd.Flags().GetString("output")
@@ -84,13 +177,6 @@ func ParseArgs(cmd *cobra.Command, args []string) (*Config, error) {
 		config.OutputPath = outputPath
 	}
 
-	// Parse format
-	format, _ := cmd.Flags().GetString("format")
-	if !isValidFormat(format) {
-		return nil, fmt.Errorf("unsupported format: %s", format)
-	}
-	config.Format = format
-
 	// Parse quality
 	quality, _ := cmd.Flags().GetInt("quality")
 	if quality < 1 || quality > 100 {
@@ -98,10 +184,116 @@ func ParseArgs(cmd *cobra.Command, args []string) (*Config, error) {
 	}
 	config.Quality = quality
 
+	lossless, _ := cmd.Flags().GetBool("lossless")
+	config.Lossless = lossless
+
+	tiffCompression, _ := cmd.Flags().GetString("tiff-compression")
+	tiffCompression = strings.ToLower(tiffCompression)
+	if tiffCompression == "" {
+		tiffCompression = formats.DefaultTIFFCompression
+	}
+	if !formats.IsTIFFCompression(tiffCompression) {
+		return nil, fmt.Errorf("unsupported TIFF compression: %s", tiffCompression)
+	}
+	config.TIFFCompression = tiffCompression
+
+	pngCompression, _ := cmd.Flags().GetString("png-compression")
+	pngCompression = strings.ToLower(pngCompression)
+	if pngCompression == "" {
+		pngCompression = formats.DefaultPNGCompression
+	}
+	if !formats.IsPNGCompression(pngCompression) {
+		return nil, fmt.Errorf("unsupported PNG compression: %s", pngCompression)
+	}
+	config.PNGCompression = pngCompression
+
+	pngPalette, _ := cmd.Flags().GetBool("png-palette")
+	config.PNGPalette = pngPalette
+
+	// Parse palette settings; an unset --colors leaves the choice to the format
+	if cmd.Flags().Changed("colors") {
+		colors, _ := cmd.Flags().GetInt("colors")
+		if colors < 2 || colors > formats.MaxColors {
+			return nil, fmt.Errorf("colors must be between 2 and %d", formats.MaxColors)
+		}
+		config.Colors = colors
+	}
+
+	dither, _ := cmd.Flags().GetString("dither")
+	dither = strings.ToLower(dither)
+	if dither == "" {
+		dither = formats.DefaultDither
+	}
+	if !formats.IsDither(dither) {
+		return nil, fmt.Errorf("unsupported dithering: %s", dither)
+	}
+	config.Dither = dither
+
+	pdfCompression, _ := cmd.Flags().GetString("pdf-compression")
+	pdfCompression = strings.ToLower(pdfCompression)
+	if pdfCompression == "" {
+		pdfCompression = formats.DefaultPDFCompression
+	}
+	if !formats.IsPDFCompression(pdfCompression) {
+		return nil, fmt.Errorf("unsupported PDF compression: %s", pdfCompression)
+	}
+	config.PDFCompression = pdfCompression
+
+	pdfCaption, _ := cmd.Flags().GetBool("pdf-caption")
+	config.PDFCaption = pdfCaption
+
+	if maxSize, _ := cmd.Flags().GetString("max-size"); maxSize != "" {
+		size, err := parseByteSize(maxSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max-size: %w", err)
+		}
+		if size <= 0 {
+			return nil, fmt.Errorf("max-size must be greater than 0")
+		}
+		config.MaxSize = size
+	}
+
+	// Parse time zone
+	tz, _ := cmd.Flags().GetString("tz")
+	location, err := parseLocation(tz)
+	if err != nil {
+		return nil, err
+	}
+	config.Location = location
+
 	// Parse template
 	template, _ := cmd.Flags().GetString("template")
 	config.Template = template
 
+	outputExplicit := len(args) > 0 || (outputFlag != nil && outputFlag.Changed)
+	if err := parseStdout(cmd, config, outputExplicit); err != nil {
+		return nil, err
+	}
+
+	// Parse format, inferring it from the template or output extension
+	if err := parseFormat(cmd, config, outputExplicit); err != nil {
+		return nil, err
+	}
+
+	// Parse overwrite policy
+	ifExists, _ := cmd.Flags().GetString("if-exists")
+	ifExists = strings.ToLower(ifExists)
+	if ifExists == "" {
+		ifExists = IfExistsOverwrite
+	}
+	switch ifExists {
+	case IfExistsOverwrite, IfExistsSkip, IfExistsError, IfExistsSuffix:
+		config.IfExists = ifExists
+	default:
+		return nil, fmt.Errorf("unsupported if-exists policy: %s", ifExists)
+	}
+
+	fsync, _ := cmd.Flags().GetBool("fsync")
+	config.Fsync = fsync
+
+	verbose, _ := cmd.Flags().GetBool("verbose")
+	config.Verbose = verbose
+
 	// Parse batch settings
 	count, _ := cmd.Flags().GetInt("count")
 	if count < 1 {
@@ -119,19 +311,502 @@ func ParseArgs(cmd *cobra.Command, args []string) (*Config, error) {
 	config.Prefix = prefix
 
 	dir, _ := cmd.Flags().GetString("directory")
+	if _, err := NewTemplateProcessor().ExpandTemplate(dir, config); err != nil {
+		return nil, fmt.Errorf("invalid directory template: %w", err)
+	}
 	config.Dir = dir
 
+	resume, _ := cmd.Flags().GetBool("resume")
+	config.Resume = resume
+
+	// Parse deduplication settings
+	dedupe, _ := cmd.Flags().GetString("dedupe")
+	if !isValidDedupeMode(dedupe) {
+		return nil, fmt.Errorf("unsupported dedupe mode: %s", dedupe)
+	}
+	dedupe = strings.ToLower(dedupe)
+	if dedupe == "none" {
+		dedupe = ""
+	}
+	config.Dedupe = dedupe
+
+	dedupeThreshold, _ := cmd.Flags().GetInt("dedupe-threshold")
+	if dedupeThreshold < 0 || dedupeThreshold > 64 {
+		return nil, fmt.Errorf("dedupe threshold must be between 0 and 64")
+	}
+	config.DedupeThreshold = dedupeThreshold
+
+	dedupeIndex, _ := cmd.Flags().GetBool("dedupe-index")
+	config.DedupeIndex = dedupeIndex
+
+	// Parse manifest format
+	manifest, _ := cmd.Flags().GetString("manifest")
+	manifest = strings.ToLower(manifest)
+	if !isValidManifestFormat(manifest) {
+		return nil, fmt.Errorf("unsupported manifest format: %s", manifest)
+	}
+	if manifest == "none" {
+		manifest = ""
+	}
+	config.Manifest = manifest
+
+	// Parse error handling policy
+	onError, _ := cmd.Flags().GetString("on-error")
+	onError = strings.ToLower(onError)
+	if onError == "" {
+		onError = OnErrorAbort
+	}
+	if onError != OnErrorAbort && onError != OnErrorContinue && onError != OnErrorRetry {
+		return nil, fmt.Errorf("unsupported error policy: %s", onError)
+	}
+	config.OnError = onError
+
+	retries, _ := cmd.Flags().GetInt("retries")
+	if retries < 0 {
+		return nil, fmt.Errorf("retries must be non-negative")
+	}
+	config.Retries = retries
+
+	retryBackoff, _ := cmd.Flags().GetDuration("retry-backoff")
+	if retryBackoff < 0 {
+		return nil, fmt.Errorf("retry backoff must be non-negative")
+	}
+	config.RetryBackoff = retryBackoff
+
+	// Parse retention limits
+	if err := parseRetention(cmd, config); err != nil {
+		return nil, err
+	}
+
+	// Retention only deletes files recorded in a manifest
+	if (config.KeepLast > 0 || config.MaxAge > 0 || config.MaxBytes > 0) && config.Manifest == "" {
+		config.Manifest = "jsonl"
+	}
+
 	// Process template if provided
 	if config.Template != "" {
+		if err := ValidateTemplate(config.Template, config); err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
 		config.OutputPath = config.Template
 	}
 
+	if config.WritesToStdout() && config.Count > 1 {
+		return nil, fmt.Errorf("stdout output takes a single screenshot, not a batch")
+	}
+
+	if err := parseAnimate(cmd, config, outputExplicit); err != nil {
+		return nil, err
+	}
+
+	if config.MaxSize > 0 && config.Animate != "" {
+		return nil, fmt.Errorf("--max-size cannot be combined with --animate")
+	}
+
+	if err := parseThumbnail(cmd, config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// WritesToStdout reports whether the image is written to stdout rather than
+// to a file
+func (c *Config) WritesToStdout() bool {
+	return c.OutputPath == StdoutPath
+}
+
+// parseStdout parses the stdout output settings. --base64 and --data-url
+// write text to stdout, so they imply -o - and conflict with a file path.
+func parseStdout(cmd *cobra.Command, config *Config, outputExplicit bool) error {
+	base64, _ := cmd.Flags().GetBool("base64")
+	dataURL, _ := cmd.Flags().GetBool("data-url")
+	switch {
+	case base64 && dataURL:
+		return fmt.Errorf("--base64 and --data-url cannot be combined")
+	case base64:
+		config.Encoding = EncodingBase64
+	case dataURL:
+		config.Encoding = EncodingDataURL
+	}
+
+	if config.Encoding != "" {
+		if outputExplicit && !config.WritesToStdout() {
+			return fmt.Errorf("--%s writes to stdout and cannot be combined with the output file %s", config.Encoding, config.OutputPath)
+		}
+		config.OutputPath = StdoutPath
+	}
+
+	if config.WritesToStdout() && config.Template != "" {
+		return fmt.Errorf("stdout output cannot be combined with a filename template")
+	}
+
+	forceStdout, _ := cmd.Flags().GetBool("force-stdout")
+	config.ForceStdout = forceStdout
+	return nil
+}
+
+// parseAnimate parses the animation settings of a batch run. The output path,
+// which must already be set, takes the extension of the animation format when
+// it is the implicit default; an explicit path must already have it.
+func parseAnimate(cmd *cobra.Command, config *Config, outputExplicit bool) error {
+	name, _ := cmd.Flags().GetString("animate")
+	name = strings.ToLower(name)
+	if name == "" || name == "none" {
+		return nil
+	}
+	kind, ok := animate.Lookup(name)
+	if !ok {
+		return fmt.Errorf("unsupported animation format: %s", name)
+	}
+	config.Animate = kind.Name
+
+	if config.Count < 2 {
+		return fmt.Errorf("--animate needs a batch run (-n 2 or more)")
+	}
+	if config.KeepLast > 0 || config.MaxAge > 0 || config.MaxBytes > 0 {
+		return fmt.Errorf("retention limits cannot be combined with --animate")
+	}
+
+	ext := filepath.Ext(config.OutputPath)
+	if !kind.HasExtension(ext) {
+		if outputExplicit || config.Template != "" {
+			return fmt.Errorf("--animate %s conflicts with the extension of %s", kind.Name, config.OutputPath)
+		}
+		config.OutputPath = strings.TrimSuffix(config.OutputPath, ext) + kind.Extension()
+	}
+
+	loop, _ := cmd.Flags().GetInt("loop")
+	if loop < 0 || loop > 0xffff {
+		return fmt.Errorf("loop must be between 0 and 65535")
+	}
+	config.Loop = loop
+
+	palette, _ := cmd.Flags().GetString("palette")
+	palette = strings.ToLower(palette)
+	if palette == "" {
+		palette = animate.PaletteFrame
+	}
+	if !animate.IsPalette(palette) {
+		return fmt.Errorf("unsupported palette mode: %s", palette)
+	}
+	config.AnimatePalette = palette
+
+	fps, _ := cmd.Flags().GetInt("fps")
+	if fps == 0 {
+		fps = animate.DefaultFPS
+	}
+	if fps < 1 || fps > 1000 {
+		return fmt.Errorf("fps must be between 1 and 1000")
+	}
+	config.FPS = fps
+
+	return nil
+}
+
+// ParsePruneArgs parses the arguments of the prune command
+func ParsePruneArgs(cmd *cobra.Command, args []string) (*Config, error) {
+	config := &Config{Dir: "."}
+	if len(args) > 0 {
+		config.Dir = args[0]
+	}
+
+	if err := parseRetention(cmd, config); err != nil {
+		return nil, err
+	}
+	if config.KeepLast == 0 && config.MaxAge == 0 && config.MaxBytes == 0 {
+		return nil, fmt.Errorf("at least one of --keep-last, --max-age or --max-bytes is required")
+	}
+
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	config.DryRun = dryRun
+
 	return config, nil
 }
 
+// ParseTemplateArgs parses the arguments of the template preview command.
+// The template is taken from the first argument, or -t if none is given.
+func ParseTemplateArgs(cmd *cobra.Command, args []string) (*Config, error) {
+	config := &Config{}
+
+	template, _ := cmd.Flags().GetString("template")
+	if len(args) > 0 {
+		template = args[0]
+	}
+	config.Template = template
+
+	output, _ := cmd.Flags().GetString("output")
+	config.OutputPath = output
+	if template != "" {
+		config.OutputPath = template
+	}
+
+	outputFlag := cmd.Flags().Lookup("output")
+	if err := parseFormat(cmd, config, outputFlag != nil && outputFlag.Changed); err != nil {
+		return nil, err
+	}
+
+	display, _ := cmd.Flags().GetInt("display")
+	if display < 0 {
+		return nil, fmt.Errorf("display index must be non-negative")
+	}
+	config.Display = display
+
+	if regionStr, _ := cmd.Flags().GetString("region"); regionStr != "" {
+		region, err := parseRegion(regionStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid region format: %w", err)
+		}
+		config.Region = region
+	}
+
+	if err := parseResize(cmd, config); err != nil {
+		return nil, err
+	}
+
+	count, _ := cmd.Flags().GetInt("count")
+	if count < 1 {
+		return nil, fmt.Errorf("count must be at least 1")
+	}
+	config.Count = count
+
+	interval, _ := cmd.Flags().GetInt("interval")
+	if interval < 1 {
+		return nil, fmt.Errorf("interval must be at least 1 second")
+	}
+	config.Interval = interval
+
+	prefix, _ := cmd.Flags().GetString("prefix")
+	config.Prefix = prefix
+
+	dir, _ := cmd.Flags().GetString("directory")
+	config.Dir = dir
+
+	tz, _ := cmd.Flags().GetString("tz")
+	location, err := parseLocation(tz)
+	if err != nil {
+		return nil, err
+	}
+	config.Location = location
+
+	return config, nil
+}
+
+// Resize returns the resize options of captures
+func (c *Config) Resize() resize.Options {
+	return resize.Options{
+		Scale:         c.Scale,
+		MaxWidth:      c.MaxWidth,
+		MaxHeight:     c.MaxHeight,
+		Interpolation: c.Interpolation,
+	}
+}
+
+// Thumbnail returns whether a thumbnail is written next to each screenshot
+func (c *Config) Thumbnail() bool {
+	return c.ThumbnailWidth > 0 || c.ThumbnailHeight > 0
+}
+
+// ThumbnailResize returns the resize options of thumbnails
+func (c *Config) ThumbnailResize() resize.Options {
+	return resize.Options{
+		MaxWidth:      c.ThumbnailWidth,
+		MaxHeight:     c.ThumbnailHeight,
+		Interpolation: c.Interpolation,
+	}
+}
+
+// DefaultThumbnailTemplate names thumbnails after their screenshot
+const DefaultThumbnailTemplate = "{name}.thumb.jpg"
+
+// parseThumbnail parses the thumbnail settings. The format follows the
+// thumbnail template; with an explicit --thumbnail-format the default
+// template takes its extension instead.
+func parseThumbnail(cmd *cobra.Command, config *Config) error {
+	size, _ := cmd.Flags().GetString("thumbnail")
+	if size == "" {
+		return nil
+	}
+	width, height, err := parseSize(size)
+	if err != nil {
+		return fmt.Errorf("invalid thumbnail size: %w", err)
+	}
+
+	switch {
+	case config.WritesToStdout() || (config.Count == 1 && config.OutputPath == ""):
+		return fmt.Errorf("--thumbnail needs screenshots saved to files")
+	case config.Animate != "":
+		return fmt.Errorf("--thumbnail cannot be combined with --animate")
+	}
+
+	template, _ := cmd.Flags().GetString("thumbnail-template")
+	format, _ := cmd.Flags().GetString("thumbnail-format")
+	if template == "" || !cmd.Flags().Changed("thumbnail-template") {
+		template = DefaultThumbnailTemplate
+		if format != "" {
+			template = strings.TrimSuffix(template, filepath.Ext(template)) + formatExtension(strings.ToLower(format))
+		}
+	}
+	force, _ := cmd.Flags().GetBool("force-format")
+	format, err = ResolveFormat(format, template, force)
+	if err != nil {
+		return fmt.Errorf("invalid thumbnail format: %w", err)
+	}
+
+	quality, _ := cmd.Flags().GetInt("thumbnail-quality")
+	if quality < 1 || quality > 100 {
+		return fmt.Errorf("thumbnail quality must be between 1 and 100")
+	}
+
+	config.ThumbnailWidth = width
+	config.ThumbnailHeight = height
+	config.ThumbnailTemplate = template
+	config.ThumbnailFormat = format
+	config.ThumbnailQuality = quality
+
+	// Expand the template once to report unknown variables early
+	tp := NewTemplateProcessor()
+	if _, err := tp.ThumbnailPath("screenshot.png", width, height, config); err != nil {
+		return fmt.Errorf("invalid thumbnail template: %w", err)
+	}
+	return nil
+}
+
+// maxScale is the largest --scale factor, which keeps upscaled captures of
+// large screens within reasonable memory
+const maxScale = 4
+
+// parseResize parses the resize flags shared by captures and the template
+// preview. --fit WxH limits both dimensions at once and replaces --max-width
+// and --max-height.
+func parseResize(cmd *cobra.Command, config *Config) error {
+	config.Scale = 1
+	if cmd.Flags().Changed("scale") {
+		scale, _ := cmd.Flags().GetFloat64("scale")
+		if scale <= 0 || scale > maxScale {
+			return fmt.Errorf("scale must be greater than 0 and at most %d", maxScale)
+		}
+		config.Scale = scale
+	}
+
+	maxWidth, _ := cmd.Flags().GetInt("max-width")
+	maxHeight, _ := cmd.Flags().GetInt("max-height")
+	if maxWidth < 0 || maxHeight < 0 {
+		return fmt.Errorf("max-width and max-height must be non-negative")
+	}
+
+	if fit, _ := cmd.Flags().GetString("fit"); fit != "" {
+		if maxWidth > 0 || maxHeight > 0 {
+			return fmt.Errorf("--fit cannot be combined with --max-width or --max-height")
+		}
+		width, height, err := parseSize(fit)
+		if err != nil {
+			return fmt.Errorf("invalid fit size: %w", err)
+		}
+		maxWidth, maxHeight = width, height
+	}
+	config.MaxWidth = maxWidth
+	config.MaxHeight = maxHeight
+
+	interpolation, _ := cmd.Flags().GetString("interpolation")
+	interpolation = strings.ToLower(interpolation)
+	if interpolation == "" {
+		interpolation = resize.DefaultInterpolation
+	}
+	if !resize.IsInterpolation(interpolation) {
+		return fmt.Errorf("unsupported interpolation: %s", interpolation)
+	}
+	config.Interpolation = interpolation
+
+	return nil
+}
+
+// parseSize parses a size in the format "WxH". Either dimension may be left
+// out ("1920x", "x1080") to leave it unconstrained.
+func parseSize(s string) (int, int, error) {
+	w, h, ok := strings.Cut(strings.ToLower(strings.TrimSpace(s)), "x")
+	if !ok {
+		return 0, 0, fmt.Errorf("size must be in format 'WIDTHxHEIGHT'")
+	}
+
+	var dims [2]int
+	for i, part := range []string{w, h} {
+		if part == "" {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("invalid dimension: %s", part)
+		}
+		dims[i] = n
+	}
+	if dims[0] == 0 && dims[1] == 0 {
+		return 0, 0, fmt.Errorf("size needs a width or a height")
+	}
+	return dims[0], dims[1], nil
+}
+
+// parseRetention parses the retention flags shared by batch mode and prune
+func parseRetention(cmd *cobra.Command, config *Config) error {
+	keepLast, _ := cmd.Flags().GetInt("keep-last")
+	if keepLast < 0 {
+		return fmt.Errorf("keep-last must be non-negative")
+	}
+	config.KeepLast = keepLast
+
+	if maxAge, _ := cmd.Flags().GetString("max-age"); maxAge != "" {
+		age, err := parseDuration(maxAge)
+		if err != nil {
+			return fmt.Errorf("invalid max-age: %w", err)
+		}
+		config.MaxAge = age
+	}
+
+	if maxBytes, _ := cmd.Flags().GetString("max-bytes"); maxBytes != "" {
+		size, err := parseByteSize(maxBytes)
+		if err != nil {
+			return fmt.Errorf("invalid max-bytes: %w", err)
+		}
+		config.MaxBytes = size
+	}
+
+	return nil
+}
+
 // NewTemplateProcessor creates a new template processor for this config
 func (c *Config) NewTemplateProcessor() *TemplateProcessor {
-	return NewTemplateProcessor()
+	tp := NewTemplateProcessor()
+	tp.SetTime(c.CaptureTime)
+	return tp
+}
+
+// Caption returns the caption line of document formats for a capture taken
+// at t: the time in the configured time zone, the display and the hostname
+func (c *Config) Caption(t time.Time) string {
+	if c.Location != nil {
+		t = t.In(c.Location)
+	}
+	parts := []string{t.Format("2006-01-02 15:04:05 MST"), fmt.Sprintf("display %d", c.Display)}
+	if hostname, err := os.Hostname(); err == nil {
+		parts = append(parts, hostname)
+	}
+	return strings.Join(parts, " | ")
+}
+
+// parseLocation resolves a --tz value: UTC, Local or an IANA zone name
+func parseLocation(tz string) (*time.Location, error) {
+	switch strings.ToLower(tz) {
+	case "", "local":
+		return time.Local, nil
+	case "utc":
+		return time.UTC, nil
+	}
+
+	location, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %q: %w", tz, err)
+	}
+	return location, nil
 }
 
 // parseRegion parses a region string in format "x,y,width,height"
@@ -160,12 +835,112 @@ func parseRegion(regionStr string) (*Region, error) {
 
 // isValidFormat checks if the format is supported
 func isValidFormat(format string) bool {
-	validFormats := map[string]bool{
-		"png":  true,
-		"jpg":  true,
-		"jpeg": true,
-		"bmp":  true,
-		"gif":  true,
-	}
-	return validFormats[strings.ToLower(format)]
+	_, ok := formats.Lookup(format)
+	return ok
+}
+
+// isValidDedupeMode checks if the deduplication mode is supported
+func isValidDedupeMode(mode string) bool {
+	switch strings.ToLower(mode) {
+	case "", "none", "phash":
+		return true
+	default:
+		return false
+	}
+}
+
+// isValidManifestFormat checks if the manifest format is supported
+func isValidManifestFormat(format string) bool {
+	switch format {
+	case "", "none", "jsonl", "csv":
+		return true
+	default:
+		return false
+	}
+}
+
+// parseDuration parses a duration like time.ParseDuration, additionally
+// accepting whole days ("7d") and weeks ("2w") in any position (e.g. "1d12h",
+// "3d2w" or "12h1w")
+func parseDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	isNumber := func(r rune) bool { return r >= '0' && r <= '9' || r == '.' }
+
+	// Days and weeks are summed up, everything else is left to time.ParseDuration
+	var total time.Duration
+	var rest strings.Builder
+	for value != "" {
+		unitStart := strings.IndexFunc(value, func(r rune) bool { return !isNumber(r) })
+		if unitStart <= 0 {
+			rest.WriteString(value)
+			break
+		}
+		end := len(value)
+		if i := strings.IndexFunc(value[unitStart:], isNumber); i >= 0 {
+			end = unitStart + i
+		}
+
+		var size time.Duration
+		switch value[unitStart:end] {
+		case "w":
+			size = 7 * 24 * time.Hour
+		case "d":
+			size = 24 * time.Hour
+		default:
+			rest.WriteString(value[:end])
+			value = value[end:]
+			continue
+		}
+		n, err := strconv.Atoi(value[:unitStart])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q: days and weeks must be whole numbers", value[:end])
+		}
+		total += time.Duration(n) * size
+		value = value[end:]
+	}
+
+	if rest.Len() > 0 {
+		d, err := time.ParseDuration(rest.String())
+		if err != nil {
+			return 0, err
+		}
+		total += d
+	}
+
+	if total < 0 {
+		return 0, fmt.Errorf("duration must be non-negative")
+	}
+	return total, nil
+}
+
+// parseByteSize parses a size such as "500", "900KB", "1.5MB" or "2GB".
+// Units are binary (1KB = 1024 bytes); the KiB/MiB/GiB spellings are accepted too.
+func parseByteSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+
+	units := []struct {
+		suffix string
+		size   float64
+	}{
+		{"TIB", 1 << 40}, {"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+		{"B", 1},
+	}
+
+	multiplier := 1.0
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			multiplier = unit.size
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %s", value)
+	}
+
+	return int64(n * multiplier), nil
 }
@@ -2,6 +2,7 @@ package config
 
 import (
 	"testing"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -72,7 +73,10 @@ func TestIsValidFormat(t *testing.T) {
 		{"gif format", "gif", true},
 		{"uppercase PNG", "PNG", true},
 		{"uppercase JPG", "JPG", true},
-		{"invalid format", "tiff", false},
+		{"tiff format", "tiff", true},
+		{"tif alias", "tif", true},
+		{"qoi format", "qoi", true},
+		{"invalid format", "heic", false},
 		{"empty string", "", false},
 	}
 
@@ -103,14 +107,14 @@ func TestParseArgs(t *testing.T) {
 	cmd.Flags().Set("format", "jpg")
 	cmd.Flags().Set("quality", "85")
 
-	config, err := ParseArgs(cmd, []string{"test.png"})
+	config, err := ParseArgs(cmd, []string{"test.jpg"})
 	if err != nil {
 		t.Errorf("ParseArgs() error = %v", err)
 		return
 	}
 
-	if config.OutputPath != "test.png" {
-		t.Errorf("ParseArgs() output path = %v, want test.png", config.OutputPath)
+	if config.OutputPath != "test.jpg" {
+		t.Errorf("ParseArgs() output path = %v, want test.jpg", config.OutputPath)
 	}
 
 	if config.Region == nil || config.Region.X != 100 || config.Region.Y != 200 || config.Region.Width != 800 || config.Region.Height != 600 {
@@ -125,3 +129,285 @@ func TestParseArgs(t *testing.T) {
 		t.Errorf("ParseArgs() quality = %v, want 85", config.Quality)
 	}
 }
+
+func TestParseAnimate(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		flags    map[string]string
+		wantPath string
+		wantErr  bool
+	}{
+		{"disabled", nil, map[string]string{"count": "5"}, "screenshot.png", false},
+		{"default output takes the extension", nil, map[string]string{"count": "5", "animate": "gif"}, "screenshot.gif", false},
+		{"explicit output", []string{"run.gif"}, map[string]string{"count": "5", "animate": "GIF"}, "run.gif", false},
+		{"apng written as png", []string{"run.png"}, map[string]string{"count": "5", "animate": "apng"}, "run.png", false},
+		{"conflicting extension", []string{"run.png"}, map[string]string{"count": "5", "animate": "gif"}, "", true},
+		{"pdf document", []string{"audit.pdf"}, map[string]string{"count": "5", "animate": "pdf", "pdf-compression": "flate"}, "audit.pdf", false},
+		{"unknown pdf compression", nil, map[string]string{"count": "5", "animate": "pdf", "pdf-compression": "lzw"}, "", true},
+		{"single screenshot", nil, map[string]string{"animate": "gif"}, "", true},
+		{"unknown format", nil, map[string]string{"count": "5", "animate": "mng"}, "", true},
+		{"negative loop", nil, map[string]string{"count": "5", "animate": "gif", "loop": "-1"}, "", true},
+		{"unknown palette", nil, map[string]string{"count": "5", "animate": "gif", "palette": "global"}, "", true},
+		{"retention", nil, map[string]string{"count": "5", "animate": "gif", "keep-last": "10"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := &cobra.Command{}
+			cmd.Flags().StringP("output", "o", "screenshot.png", "Output file path")
+			cmd.Flags().IntP("count", "n", 1, "Number of screenshots")
+			cmd.Flags().IntP("interval", "i", 1, "Screenshot interval")
+			cmd.Flags().IntP("quality", "q", 90, "JPG quality")
+			cmd.Flags().String("animate", "none", "Animation format")
+			cmd.Flags().Int("loop", 0, "Loop count")
+			cmd.Flags().String("palette", "frame", "Palette mode")
+			cmd.Flags().Int("keep-last", 0, "Retention")
+			cmd.Flags().String("pdf-compression", "jpeg", "PDF compression")
+			for name, value := range tt.flags {
+				if err := cmd.Flags().Set(name, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			config, err := ParseArgs(cmd, tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && config.OutputPath != tt.wantPath {
+				t.Errorf("ParseArgs() output path = %q, want %q", config.OutputPath, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestParseStdout(t *testing.T) {
+	tests := []struct {
+		name         string
+		args         []string
+		flags        map[string]string
+		wantPath     string
+		wantFormat   string
+		wantEncoding string
+		wantErr      bool
+	}{
+		{"raw", []string{"-"}, nil, "-", "png", "", false},
+		{"raw with format", nil, map[string]string{"output": "-", "format": "jpg"}, "-", "jpg", "", false},
+		{"base64 implies stdout", nil, map[string]string{"base64": "true"}, "-", "png", EncodingBase64, false},
+		{"data url", []string{"-"}, map[string]string{"data-url": "true", "format": "webp"}, "-", "webp", EncodingDataURL, false},
+		{"clipboard and data url", nil, map[string]string{"clipboard": "true", "data-url": "true"}, "-", "png", EncodingDataURL, false},
+		{"base64 with a file", []string{"shot.png"}, map[string]string{"base64": "true"}, "", "", "", true},
+		{"both encodings", nil, map[string]string{"base64": "true", "data-url": "true"}, "", "", "", true},
+		{"template", nil, map[string]string{"output": "-", "template": "{date}.png"}, "", "", "", true},
+		{"batch", []string{"-"}, map[string]string{"count": "3"}, "", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := &cobra.Command{}
+			cmd.Flags().StringP("output", "o", "screenshot.png", "Output file path")
+			cmd.Flags().StringP("format", "f", "png", "Output format")
+			cmd.Flags().IntP("quality", "q", 90, "JPG quality")
+			cmd.Flags().BoolP("clipboard", "c", false, "Copy to clipboard")
+			cmd.Flags().StringP("template", "t", "", "Filename template")
+			cmd.Flags().IntP("count", "n", 1, "Number of screenshots")
+			cmd.Flags().IntP("interval", "i", 1, "Screenshot interval")
+			cmd.Flags().Bool("base64", false, "Base64 output")
+			cmd.Flags().Bool("data-url", false, "Data URL output")
+			for name, value := range tt.flags {
+				if err := cmd.Flags().Set(name, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			config, err := ParseArgs(cmd, tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if config.OutputPath != tt.wantPath || config.Format != tt.wantFormat || config.Encoding != tt.wantEncoding {
+				t.Errorf("ParseArgs() = (%q, %q, %q), want (%q, %q, %q)", config.OutputPath, config.Format, config.Encoding,
+					tt.wantPath, tt.wantFormat, tt.wantEncoding)
+			}
+		})
+	}
+}
+
+func TestParseResize(t *testing.T) {
+	tests := []struct {
+		name       string
+		flags      map[string]string
+		wantScale  float64
+		wantWidth  int
+		wantHeight int
+		wantErr    bool
+	}{
+		{"defaults", nil, 1, 0, 0, false},
+		{"scale", map[string]string{"scale": "0.5"}, 0.5, 0, 0, false},
+		{"max width", map[string]string{"max-width": "1920"}, 1, 1920, 0, false},
+		{"fit", map[string]string{"fit": "1920x1080"}, 1, 1920, 1080, false},
+		{"fit width only", map[string]string{"fit": "1280X"}, 1, 1280, 0, false},
+		{"fit height only", map[string]string{"fit": "x720"}, 1, 0, 720, false},
+		{"zero scale", map[string]string{"scale": "0"}, 0, 0, 0, true},
+		{"large scale", map[string]string{"scale": "8"}, 0, 0, 0, true},
+		{"negative max height", map[string]string{"max-height": "-1"}, 0, 0, 0, true},
+		{"fit with max width", map[string]string{"fit": "800x600", "max-width": "1024"}, 0, 0, 0, true},
+		{"fit without separator", map[string]string{"fit": "1920"}, 0, 0, 0, true},
+		{"empty fit size", map[string]string{"fit": "x"}, 0, 0, 0, true},
+		{"unknown interpolation", map[string]string{"interpolation": "lanczos"}, 0, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := &cobra.Command{}
+			cmd.Flags().Float64("scale", 1, "Scale factor")
+			cmd.Flags().Int("max-width", 0, "Maximum width")
+			cmd.Flags().Int("max-height", 0, "Maximum height")
+			cmd.Flags().String("fit", "", "Fit size")
+			cmd.Flags().String("interpolation", "catmull-rom", "Interpolation")
+			for name, value := range tt.flags {
+				if err := cmd.Flags().Set(name, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			config := &Config{}
+			err := parseResize(cmd, config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseResize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if config.Scale != tt.wantScale || config.MaxWidth != tt.wantWidth || config.MaxHeight != tt.wantHeight {
+				t.Errorf("parseResize() = (%v, %d, %d), want (%v, %d, %d)", config.Scale, config.MaxWidth, config.MaxHeight,
+					tt.wantScale, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
+
+func TestParseThumbnail(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       Config
+		flags        map[string]string
+		wantWidth    int
+		wantHeight   int
+		wantTemplate string
+		wantFormat   string
+		wantErr      bool
+	}{
+		{"disabled", Config{OutputPath: "shot.png"}, nil, 0, 0, "", "", false},
+		{"width only", Config{OutputPath: "shot.png"}, map[string]string{"thumbnail": "320x"}, 320, 0, "{name}.thumb.jpg", "jpg", false},
+		{"format changes the default template", Config{OutputPath: "shot.png"},
+			map[string]string{"thumbnail": "320x200", "thumbnail-format": "webp"}, 320, 200, "{name}.thumb.webp", "webp", false},
+		{"format from template", Config{OutputPath: "shot.png"},
+			map[string]string{"thumbnail": "x120", "thumbnail-template": "thumbs/{name}.png"}, 0, 120, "thumbs/{name}.png", "png", false},
+		{"conflicting format", Config{OutputPath: "shot.png"},
+			map[string]string{"thumbnail": "320x", "thumbnail-template": "{name}.png", "thumbnail-format": "jpg"}, 0, 0, "", "", true},
+		{"unknown variable", Config{OutputPath: "shot.png"},
+			map[string]string{"thumbnail": "320x", "thumbnail-template": "{nope}.jpg"}, 0, 0, "", "", true},
+		{"invalid size", Config{OutputPath: "shot.png"}, map[string]string{"thumbnail": "320"}, 0, 0, "", "", true},
+		{"stdout", Config{OutputPath: StdoutPath}, map[string]string{"thumbnail": "320x"}, 0, 0, "", "", true},
+		{"clipboard only", Config{Count: 1}, map[string]string{"thumbnail": "320x"}, 0, 0, "", "", true},
+		{"animation", Config{OutputPath: "run.gif", Animate: "gif"}, map[string]string{"thumbnail": "320x"}, 0, 0, "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := &cobra.Command{}
+			cmd.Flags().String("thumbnail", "", "Thumbnail size")
+			cmd.Flags().String("thumbnail-template", DefaultThumbnailTemplate, "Thumbnail template")
+			cmd.Flags().String("thumbnail-format", "", "Thumbnail format")
+			cmd.Flags().Int("thumbnail-quality", 80, "Thumbnail quality")
+			for name, value := range tt.flags {
+				if err := cmd.Flags().Set(name, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			config := tt.config
+			err := parseThumbnail(cmd, &config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseThumbnail() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if config.ThumbnailWidth != tt.wantWidth || config.ThumbnailHeight != tt.wantHeight ||
+				config.ThumbnailTemplate != tt.wantTemplate || config.ThumbnailFormat != tt.wantFormat {
+				t.Errorf("parseThumbnail() = (%d, %d, %q, %q), want (%d, %d, %q, %q)",
+					config.ThumbnailWidth, config.ThumbnailHeight, config.ThumbnailTemplate, config.ThumbnailFormat,
+					tt.wantWidth, tt.wantHeight, tt.wantTemplate, tt.wantFormat)
+			}
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"90m", 90 * time.Minute, false},
+		{"7d", 7 * 24 * time.Hour, false},
+		{"1d12h", 36 * time.Hour, false},
+		{"2w", 14 * 24 * time.Hour, false},
+		{"3d2w", 17 * 24 * time.Hour, false},
+		{"2w3d", 17 * 24 * time.Hour, false},
+		{"1w1d1h", 8*24*time.Hour + time.Hour, false},
+		{"1d1w1h", 8*24*time.Hour + time.Hour, false},
+		{"1h1d1w", 8*24*time.Hour + time.Hour, false},
+		{"30m1d", 24*time.Hour + 30*time.Minute, false},
+		{"1.5d", 0, true},
+		{"-1d", 0, true},
+		{"1x1d", 0, true},
+		{"soon", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseDuration(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseDuration() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseDuration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{"500", 500, false},
+		{"900KB", 900 * 1024, false},
+		{"1.5MB", 3 << 19, false},
+		{"2GB", 2 << 30, false},
+		{"2 gib", 2 << 30, false},
+		{"-1KB", 0, true},
+		{"lots", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseByteSize(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseByteSize() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseByteSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/funnyzak/screenshot-cli/internal/formats"
+)
+
+// DefaultFormat is used when neither -f nor the output extension name a format
+const DefaultFormat = "png"
+
+// FormatFromPath returns the format implied by the extension of path. Paths
+// without a known extension, or whose extension is produced by a template
+// variable such as {format}, imply no format.
+func FormatFromPath(path string) (string, bool) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if strings.ContainsAny(ext, "{}") {
+		return "", false
+	}
+	f, ok := formats.FromExtension(ext)
+	if !ok {
+		return "", false
+	}
+	return f.Name, true
+}
+
+// ResolveFormat determines the output format. An explicit format wins,
+// otherwise the extension of path decides, otherwise DefaultFormat is used.
+// An explicit format that contradicts the extension is an error unless force
+// is set, in which case the file is written in the explicit format anyway.
+func ResolveFormat(explicit, path string, force bool) (string, error) {
+	inferred, hasExtension := FormatFromPath(path)
+
+	if explicit == "" {
+		if hasExtension {
+			return inferred, nil
+		}
+		return DefaultFormat, nil
+	}
+
+	explicit = strings.ToLower(explicit)
+	if !isValidFormat(explicit) {
+		return "", fmt.Errorf("unsupported format: %s", explicit)
+	}
+
+	if hasExtension && !force && canonicalFormat(explicit) != inferred {
+		return "", fmt.Errorf("format %s conflicts with the extension of %s (use --force-format to write %s anyway)",
+			explicit, path, explicit)
+	}
+
+	return explicit, nil
+}
+
+// canonicalFormat folds format aliases such as jpeg into one name
+func canonicalFormat(format string) string {
+	if f, ok := formats.Lookup(format); ok {
+		return f.Name
+	}
+	return format
+}
+
+// formatExtension returns the file extension written for a format
+func formatExtension(format string) string {
+	if f, ok := formats.Lookup(format); ok {
+		return f.Extension()
+	}
+	return "." + strings.ToLower(format)
+}
+
+// parseFormat resolves the output format of config from the format flag and
+// the template or output path, which must already be set. When the output
+// path is the implicit default it takes the extension of the resolved format.
+func parseFormat(cmd *cobra.Command, config *Config, outputExplicit bool) error {
+	explicit := ""
+	if flag := cmd.Flags().Lookup("format"); flag != nil && flag.Changed {
+		explicit = flag.Value.String()
+	}
+	force, _ := cmd.Flags().GetBool("force-format")
+
+	path := config.Template
+	if path == "" && outputExplicit {
+		path = config.OutputPath
+	}
+
+	format, err := ResolveFormat(explicit, path, force)
+	if err != nil {
+		return err
+	}
+	config.Format = format
+
+	// The default output name follows the format, e.g. screenshot.jpg for -f jpg
+	if !outputExplicit && config.Template == "" && config.OutputPath != "" && !config.WritesToStdout() {
+		ext := filepath.Ext(config.OutputPath)
+		config.OutputPath = strings.TrimSuffix(config.OutputPath, ext) + formatExtension(format)
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package config
+
+import "testing"
+
+func TestResolveFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		explicit string
+		path     string
+		force    bool
+		want     string
+		wantErr  bool
+	}{
+		{"default", "", "", false, "png", false},
+		{"from extension", "", "shot.jpg", false, "jpg", false},
+		{"jpeg extension", "", "shot.JPEG", false, "jpg", false},
+		{"webp extension", "", "shot.webp", false, "webp", false},
+		{"from template extension", "", "{date}_{counter}.bmp", false, "bmp", false},
+		{"unknown extension", "", "shot.v2", false, "png", false},
+		{"templated extension", "", "shot.{format}", false, "png", false},
+		{"explicit without extension", "gif", "shot_{counter}", false, "gif", false},
+		{"explicit matches", "jpg", "shot.jpg", false, "jpg", false},
+		{"explicit alias matches", "jpeg", "shot.jpg", false, "jpeg", false},
+		{"conflict", "png", "shot.jpg", false, "", true},
+		{"forced conflict", "png", "shot.jpg", true, "png", false},
+		{"unsupported", "heic", "shot.png", true, "", true},
+		{"tif extension", "", "scan.TIF", false, "tiff", false},
+		{"qoi extension", "", "frame_{counter}.qoi", false, "qoi", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveFormat(tt.explicit, tt.path, tt.force)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveFormat() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ResolveFormat() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,8 +1,13 @@
 package config
 
 import (
+	"crypto/rand"
 	"fmt"
-	"math/rand"
+	mathrand "math/rand"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -11,6 +16,10 @@ import (
 // TemplateProcessor handles filename template processing
 type TemplateProcessor struct {
 	counter int
+	width   int
+	height  int
+	time    time.Time
+	name    string // Screenshot name for {name}, set for thumbnail templates
 }
 
 // NewTemplateProcessor creates a new template processor
@@ -20,29 +29,148 @@ func NewTemplateProcessor() *TemplateProcessor {
 	}
 }
 
-// ProcessTemplate processes a filename template with variables
-func (tp *TemplateProcessor) ProcessTemplate(template string, config *Config) string {
+// templateSegment is either literal text or a {name:spec} variable
+type templateSegment struct {
+	literal string
+	name    string
+	spec    string
+}
+
+// templateVariable expands a variable with an optional format specifier
+type templateVariable func(tp *TemplateProcessor, spec string, config *Config) (string, error)
+
+// templateVariables lists all supported template variables
+var templateVariables = map[string]templateVariable{
+	"timestamp": expandTimestamp,
+	"datetime":  timeVariable("20060102_150405"),
+	"date":      timeVariable("20060102"),
+	"time":      timeVariable("150405"),
+	"counter":   expandCounter,
+	"random":    expandRandom,
+	"prefix": func(_ *TemplateProcessor, _ string, config *Config) (string, error) {
+		return config.Prefix, nil
+	},
+	"display": func(_ *TemplateProcessor, _ string, config *Config) (string, error) {
+		return strconv.Itoa(config.Display), nil
+	},
+	"width": func(tp *TemplateProcessor, _ string, _ *Config) (string, error) {
+		return strconv.Itoa(tp.width), nil
+	},
+	"height": func(tp *TemplateProcessor, _ string, _ *Config) (string, error) {
+		return strconv.Itoa(tp.height), nil
+	},
+	"format": func(_ *TemplateProcessor, _ string, config *Config) (string, error) {
+		return strings.ToLower(config.Format), nil
+	},
+	"name": func(tp *TemplateProcessor, _ string, _ *Config) (string, error) {
+		if tp.name == "" {
+			return "", fmt.Errorf("only available in thumbnail templates")
+		}
+		return tp.name, nil
+	},
+	"hostname": expandHostname,
+	"user":     expandUser,
+	"env":      expandEnv,
+	"uuid":     expandUUID,
+}
+
+// ProcessTemplate processes a filename template with variables and adds the
+// file extension of the configured format if the result has none.
+// Variables are written as {name} or {name:spec}, e.g. {counter:05} or
+// {date:2006-01-02}; use {{ and }} for literal braces.
+func (tp *TemplateProcessor) ProcessTemplate(template string, config *Config) (string, error) {
 	if template == "" {
-		return config.OutputPath
+		return config.OutputPath, nil
 	}
 
-	result := template
-
-	// Replace template variables
-	result = strings.ReplaceAll(result, "{timestamp}", strconv.FormatInt(time.Now().Unix(), 10))
-	result = strings.ReplaceAll(result, "{datetime}", time.Now().Format("20060102_150405"))
-	result = strings.ReplaceAll(result, "{date}", time.Now().Format("20060102"))
-	result = strings.ReplaceAll(result, "{time}", time.Now().Format("150405"))
-	result = strings.ReplaceAll(result, "{counter}", fmt.Sprintf("%03d", tp.counter))
-	result = strings.ReplaceAll(result, "{random}", generateRandomString(6))
-	result = strings.ReplaceAll(result, "{prefix}", config.Prefix)
+	output, err := tp.ExpandTemplate(template, config)
+	if err != nil {
+		return "", err
+	}
 
 	// Add file extension if not present
-	if !hasFileExtension(result) {
-		result += "." + config.Format
+	if !hasFileExtension(output) {
+		output += formatExtension(config.Format)
 	}
 
-	return result
+	return output, nil
+}
+
+// ExpandTemplate replaces the variables of a template without adding a file
+// extension, e.g. for output directories
+func (tp *TemplateProcessor) ExpandTemplate(template string, config *Config) (string, error) {
+	segments, err := parseTemplate(template)
+	if err != nil {
+		return "", err
+	}
+
+	var result strings.Builder
+	for _, segment := range segments {
+		if segment.name == "" {
+			result.WriteString(segment.literal)
+			continue
+		}
+
+		value, err := templateVariables[segment.name](tp, segment.spec, config)
+		if err != nil {
+			return "", fmt.Errorf("template variable {%s}: %w", segment.name, err)
+		}
+		result.WriteString(value)
+	}
+
+	return result.String(), nil
+}
+
+// TemplateVariableNames returns the names of the variables used in a template
+func TemplateVariableNames(template string) ([]string, error) {
+	segments, err := parseTemplate(template)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, segment := range segments {
+		if segment.name != "" {
+			names = append(names, segment.name)
+		}
+	}
+	return names, nil
+}
+
+// TemplateBaseDir returns the leading part of a directory template that does
+// not depend on any variable, e.g. "captures" for "captures/{date}/{time:15}".
+// State shared across all expanded directories, such as the manifest, is kept there.
+func TemplateBaseDir(dir string) string {
+	segments, err := parseTemplate(dir)
+	if err != nil || len(segments) == 0 {
+		return filepath.Clean(dir)
+	}
+
+	prefix := segments[0].literal
+	if segments[0].name != "" {
+		prefix = ""
+	}
+	if len(segments) == 1 && segments[0].name == "" {
+		return filepath.Clean(prefix)
+	}
+
+	// Cut at the last separator before the first variable
+	cut := strings.LastIndexAny(prefix, `/\`)
+	switch {
+	case cut < 0:
+		return "."
+	case cut == 0:
+		return prefix[:1]
+	default:
+		return filepath.Clean(prefix[:cut])
+	}
+}
+
+// ValidateTemplate checks that a template only uses known variables with
+// valid format specifiers
+func ValidateTemplate(template string, config *Config) error {
+	_, err := NewTemplateProcessor().ProcessTemplate(template, config)
+	return err
 }
 
 // IncrementCounter increments the internal counter
@@ -55,10 +183,271 @@ func (tp *TemplateProcessor) SetCounter(value int) {
 	tp.counter = value
 }
 
+// SetTime sets the capture time used for all date and time variables. If no
+// time is set, the current time is used at each expansion.
+func (tp *TemplateProcessor) SetTime(t time.Time) {
+	tp.time = t
+}
+
+// now returns the capture time in the configured time zone
+func (tp *TemplateProcessor) now(config *Config) time.Time {
+	t := tp.time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	if config.Location != nil {
+		t = t.In(config.Location)
+	}
+	return t
+}
+
+// SetImageSize sets the dimensions used for {width} and {height}
+func (tp *TemplateProcessor) SetImageSize(width, height int) {
+	tp.width = width
+	tp.height = height
+}
+
+// ThumbnailPath returns the path of the thumbnail of a screenshot saved at
+// screenshotPath, expanding the thumbnail template with {name} set to the
+// screenshot's file name without extension and {width} and {height} to the
+// thumbnail size. Relative paths are placed next to the screenshot.
+func (tp *TemplateProcessor) ThumbnailPath(screenshotPath string, width, height int, config *Config) (string, error) {
+	thumb := *tp
+	base := filepath.Base(screenshotPath)
+	thumb.name = strings.TrimSuffix(base, filepath.Ext(base))
+	thumb.SetImageSize(width, height)
+
+	// {format} is the thumbnail format
+	thumbConfig := *config
+	thumbConfig.Format = config.ThumbnailFormat
+
+	path, err := thumb.ExpandTemplate(config.ThumbnailTemplate, &thumbConfig)
+	if err != nil {
+		return "", err
+	}
+	if !hasFileExtension(path) {
+		path += formatExtension(config.ThumbnailFormat)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(screenshotPath), path)
+	}
+	return path, nil
+}
+
+// parseTemplate splits a template into literal text and variables
+func parseTemplate(template string) ([]templateSegment, error) {
+	var segments []templateSegment
+	var literal strings.Builder
+
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+
+		switch {
+		case c == '{' && strings.HasPrefix(template[i:], "{{"):
+			literal.WriteByte('{')
+			i++
+		case c == '}' && strings.HasPrefix(template[i:], "}}"):
+			literal.WriteByte('}')
+			i++
+		case c == '}':
+			return nil, fmt.Errorf("unexpected '}' at position %d in template %q", i, template)
+		case c == '{':
+			end := strings.IndexByte(template[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated variable at position %d in template %q", i, template)
+			}
+
+			name, spec, _ := strings.Cut(template[i+1:i+end], ":")
+			if _, ok := templateVariables[name]; !ok {
+				return nil, fmt.Errorf("unknown template variable {%s}", name)
+			}
+
+			if literal.Len() > 0 {
+				segments = append(segments, templateSegment{literal: literal.String()})
+				literal.Reset()
+			}
+			segments = append(segments, templateSegment{name: name, spec: spec})
+			i += end
+		default:
+			literal.WriteByte(c)
+		}
+	}
+
+	if literal.Len() > 0 {
+		segments = append(segments, templateSegment{literal: literal.String()})
+	}
+
+	return segments, nil
+}
+
+// timeVariable returns a variable formatting the current time with the
+// given default layout; a Go time layout may be passed as specifier
+func timeVariable(layout string) templateVariable {
+	return func(tp *TemplateProcessor, spec string, config *Config) (string, error) {
+		if spec == "" {
+			spec = layout
+		}
+		return tp.now(config).Format(spec), nil
+	}
+}
+
+// expandTimestamp formats the Unix time in s (default), ms, us or ns
+func expandTimestamp(tp *TemplateProcessor, spec string, config *Config) (string, error) {
+	now := tp.now(config)
+
+	switch spec {
+	case "", "s":
+		return strconv.FormatInt(now.Unix(), 10), nil
+	case "ms":
+		return strconv.FormatInt(now.UnixMilli(), 10), nil
+	case "us":
+		return strconv.FormatInt(now.UnixMicro(), 10), nil
+	case "ns":
+		return strconv.FormatInt(now.UnixNano(), 10), nil
+	default:
+		return "", fmt.Errorf("unsupported unit %q (use s, ms, us or ns)", spec)
+	}
+}
+
+// expandCounter formats the counter zero-padded to the width given as
+// specifier, three digits by default
+func expandCounter(tp *TemplateProcessor, spec string, _ *Config) (string, error) {
+	width := 3
+	if spec != "" {
+		n, err := strconv.Atoi(spec)
+		if err != nil || n < 0 || n > 20 {
+			return "", fmt.Errorf("invalid width %q", spec)
+		}
+		width = n
+	}
+	return fmt.Sprintf("%0*d", width, tp.counter), nil
+}
+
+// expandRandom generates a random string with the length given as
+// specifier, six characters by default
+func expandRandom(_ *TemplateProcessor, spec string, _ *Config) (string, error) {
+	length := 6
+	if spec != "" {
+		n, err := strconv.Atoi(spec)
+		if err != nil || n < 1 || n > 64 {
+			return "", fmt.Errorf("invalid length %q", spec)
+		}
+		length = n
+	}
+	return generateRandomString(length), nil
+}
+
+// expandHostname returns the name of the local machine
+func expandHostname(_ *TemplateProcessor, _ string, _ *Config) (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	return hostname, nil
+}
+
+// expandUser returns the name of the current user without any domain part
+func expandUser(_ *TemplateProcessor, _ string, _ *Config) (string, error) {
+	name := ""
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if name == "" {
+		name = os.Getenv("USER")
+	}
+	if name == "" {
+		name = os.Getenv("USERNAME")
+	}
+	if name == "" {
+		return "", fmt.Errorf("cannot determine current user")
+	}
+
+	// Windows usernames are reported as DOMAIN\user
+	if i := strings.LastIndex(name, `\`); i >= 0 {
+		name = name[i+1:]
+	}
+	return name, nil
+}
+
+// expandEnv returns the value of the environment variable named by spec
+func expandEnv(_ *TemplateProcessor, spec string, _ *Config) (string, error) {
+	if spec == "" {
+		return "", fmt.Errorf("missing variable name, use {env:NAME}")
+	}
+	value, ok := os.LookupEnv(spec)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", spec)
+	}
+	return value, nil
+}
+
+// expandUUID generates a random (version 4) UUID
+func expandUUID(_ *TemplateProcessor, _ string, _ *Config) (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// CounterPattern returns a regular expression matching file names produced
+// by template. The first submatch captures the value of {counter}; all other
+// variables except {prefix} and {format} match any text.
+func CounterPattern(template string, config *Config) (*regexp.Regexp, error) {
+	segments, err := parseTemplate(template)
+	if err != nil {
+		return nil, err
+	}
+
+	var pattern strings.Builder
+	var name strings.Builder
+	counterSeen := false
+	pattern.WriteString("^")
+	for _, segment := range segments {
+		switch segment.name {
+		case "":
+			pattern.WriteString(regexp.QuoteMeta(segment.literal))
+			name.WriteString(segment.literal)
+			continue
+		case "counter":
+			if counterSeen {
+				pattern.WriteString(`\d+`)
+			} else {
+				pattern.WriteString(`(\d+)`)
+			}
+			counterSeen = true
+		case "prefix":
+			pattern.WriteString(regexp.QuoteMeta(config.Prefix))
+			name.WriteString(config.Prefix)
+			continue
+		case "format":
+			pattern.WriteString(regexp.QuoteMeta(strings.ToLower(config.Format)))
+			name.WriteString(strings.ToLower(config.Format))
+			continue
+		default:
+			pattern.WriteString(`.+?`)
+		}
+		name.WriteString("x")
+	}
+
+	if !counterSeen {
+		return nil, fmt.Errorf("template %q does not contain {counter}", template)
+	}
+
+	if !hasFileExtension(name.String()) {
+		pattern.WriteString(regexp.QuoteMeta(formatExtension(config.Format)))
+	}
+	pattern.WriteString("$")
+
+	return regexp.Compile(pattern.String())
+}
+
 // generateRandomString generates a random string of specified length
 func generateRandomString(length int) string {
 	const charset = "abcdefghijklmnopqrstuvwxyz0123456789"
-	seededRand := rand.New(rand.NewSource(time.Now().UnixNano()))
+	seededRand := mathrand.New(mathrand.NewSource(time.Now().UnixNano()))
 
 	b := make([]byte, length)
 	for i := range b {
@@ -67,13 +456,8 @@ func generateRandomString(length int) string {
 	return string(b)
 }
 
-// hasFileExtension checks if a filename has a file extension
+// hasFileExtension checks if a filename has the extension of a supported format
 func hasFileExtension(filename string) bool {
-	extensions := []string{".png", ".jpg", ".jpeg", ".bmp", ".gif"}
-	for _, ext := range extensions {
-		if strings.HasSuffix(strings.ToLower(filename), ext) {
-			return true
-		}
-	}
-	return false
+	_, ok := FormatFromPath(filename)
+	return ok
 }
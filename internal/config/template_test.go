@@ -0,0 +1,183 @@
+package config
+
+import (
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestCounterPattern(t *testing.T) {
+	cfg := &Config{Format: "png", Prefix: "shot"}
+
+	tests := []struct {
+		name     string
+		template string
+		file     string
+		want     string
+	}{
+		{"counter only", "{counter}.png", "042.png", "042"},
+		{"prefix and date", "{prefix}_{date}_{counter}.png", "shot_20240101_007.png", "007"},
+		{"extension appended", "cap_{counter}", "cap_12.png", "12"},
+		{"other prefix", "{prefix}_{counter}.png", "other_001.png", ""},
+		{"literal dots escaped", "a.b_{counter}.png", "axb_001.png", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pattern, err := CounterPattern(tt.template, cfg)
+			if err != nil {
+				t.Fatalf("CounterPattern() error = %v", err)
+			}
+			match := pattern.FindStringSubmatch(tt.file)
+			got := ""
+			if match != nil {
+				got = match[1]
+			}
+			if got != tt.want {
+				t.Errorf("CounterPattern(%q) matched %q in %q, want %q", tt.template, got, tt.file, tt.want)
+			}
+		})
+	}
+
+	if _, err := CounterPattern("{date}.png", cfg); err == nil {
+		t.Error("CounterPattern() without {counter} should fail")
+	}
+}
+
+func TestProcessTemplate(t *testing.T) {
+	t.Setenv("SSHOT_TEST_PROJECT", "alpha")
+
+	cfg := &Config{Format: "png", Prefix: "shot", Display: 1}
+	tp := NewTemplateProcessor()
+	tp.SetCounter(7)
+	tp.SetImageSize(1920, 1080)
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{"default counter", "{prefix}_{counter}.png", "shot_007.png", false},
+		{"counter width", "{counter:05}", "00007.png", false},
+		{"image variables", "d{display}_{width}x{height}.{format}", "d1_1920x1080.png", false},
+		{"environment", "{env:SSHOT_TEST_PROJECT}/{counter}.jpg", "alpha/007.jpg", false},
+		{"escaped braces", "{{raw}}_{counter}.png", "{raw}_007.png", false},
+		{"unknown variable", "{nope}.png", "", true},
+		{"unset environment", "{env:SSHOT_TEST_UNSET}.png", "", true},
+		{"bad counter width", "{counter:abc}.png", "", true},
+		{"unterminated", "{counter.png", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tp.ProcessTemplate(tt.template, cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ProcessTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ProcessTemplate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessTemplateFormatExtension(t *testing.T) {
+	tp := NewTemplateProcessor()
+	tp.SetCounter(7)
+
+	for format, want := range map[string]string{"jpeg": "shot_007.jpg", "tif": "shot_007.tiff", "qoi": "shot_007.qoi"} {
+		got, err := tp.ProcessTemplate("shot_{counter}", &Config{Format: format})
+		if err != nil {
+			t.Fatalf("ProcessTemplate() error = %v", err)
+		}
+		if got != want {
+			t.Errorf("ProcessTemplate() with format %s = %q, want %q", format, got, want)
+		}
+	}
+}
+
+func TestProcessTemplateGeneratedValues(t *testing.T) {
+	cfg := &Config{Format: "png"}
+	tp := NewTemplateProcessor()
+
+	tests := []struct {
+		template string
+		pattern  string
+	}{
+		{"{date:2006-01-02}", `^\d{4}-\d{2}-\d{2}\.png$`},
+		{"{time:15-04-05.000}", `^\d{2}-\d{2}-\d{2}\.\d{3}\.png$`},
+		{"{timestamp:ms}", `^\d{13}\.png$`},
+		{"{random:10}", `^[a-z0-9]{10}\.png$`},
+		{"{uuid}", `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}\.png$`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			got, err := tp.ProcessTemplate(tt.template, cfg)
+			if err != nil {
+				t.Fatalf("ProcessTemplate() error = %v", err)
+			}
+			if !regexp.MustCompile(tt.pattern).MatchString(got) {
+				t.Errorf("ProcessTemplate() = %q, want match for %s", got, tt.pattern)
+			}
+		})
+	}
+}
+
+func TestProcessTemplateCaptureTime(t *testing.T) {
+	tokyo, err := parseLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatalf("parseLocation() error = %v", err)
+	}
+
+	captured := time.Date(2024, 3, 9, 23, 59, 59, 999_000_000, time.UTC)
+	tp := NewTemplateProcessor()
+	tp.SetTime(captured)
+
+	tests := []struct {
+		name     string
+		location *time.Location
+		want     string
+	}{
+		{"utc", time.UTC, "20240309_235959_20240309_235959.999_1710028799.png"},
+		{"tokyo", tokyo, "20240310_085959_20240310_085959.999_1710028799.png"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Format: "png", Location: tt.location}
+			got, err := tp.ProcessTemplate("{datetime}_{date}_{time:150405.000}_{timestamp}", cfg)
+			if err != nil {
+				t.Fatalf("ProcessTemplate() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ProcessTemplate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTemplateBaseDir(t *testing.T) {
+	tests := []struct {
+		dir  string
+		want string
+	}{
+		{"./captures", "captures"},
+		{"./captures/{date}", "captures"},
+		{"captures/{date:2006}/{date:01}/{date:02}", "captures"},
+		{"{date:2006}/{date:01}", "."},
+		{"/var/shots/run_{date}", "/var/shots"},
+		{"shots_{date}", "."},
+		{"", "."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dir, func(t *testing.T) {
+			if got := TemplateBaseDir(tt.dir); got != filepath.FromSlash(tt.want) {
+				t.Errorf("TemplateBaseDir(%q) = %q, want %q", tt.dir, got, tt.want)
+			}
+		})
+	}
+}
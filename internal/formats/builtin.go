@@ -0,0 +1,103 @@
+package formats
+
+import (
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"io"
+
+	"golang.org/x/image/bmp"
+
+	"github.com/funnyzak/screenshot-cli/internal/webp"
+)
+
+func init() {
+	Register(&Format{
+		Name:        "png",
+		Extensions:  []string{".png"},
+		MIME:        "image/png",
+		Description: "Portable Network Graphics",
+		Options:     []string{"png-compression", "png-palette", "colors", "dither"},
+		Encode:      encodePNG,
+	})
+	Register(&Format{
+		Name:        "jpg",
+		Aliases:     []string{"jpeg"},
+		Extensions:  []string{".jpg", ".jpeg"},
+		MIME:        "image/jpeg",
+		Description: "JPEG",
+		Quality:     QualityLossy,
+		Encode: func(w io.Writer, img image.Image, opts Options) error {
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: opts.Quality})
+		},
+	})
+	Register(&Format{
+		Name:        "bmp",
+		Extensions:  []string{".bmp"},
+		MIME:        "image/bmp",
+		Description: "Bitmap",
+		Encode: func(w io.Writer, img image.Image, opts Options) error {
+			return bmp.Encode(w, img)
+		},
+	})
+	Register(&Format{
+		Name:        "gif",
+		Extensions:  []string{".gif"},
+		MIME:        "image/gif",
+		Description: "Graphics Interchange Format, adaptive palette",
+		Options:     []string{"colors", "dither"},
+		Encode: func(w io.Writer, img image.Image, opts Options) error {
+			return gif.Encode(w, Quantize(ToNRGBA(img), opts.PaletteSize(), opts.Dither), nil)
+		},
+	})
+	Register(&Format{
+		Name:        "webp",
+		Extensions:  []string{".webp"},
+		MIME:        "image/webp",
+		Description: "WebP, lossy or lossless",
+		Quality:     QualityLossy,
+		Options:     []string{"lossless"},
+		Encode: func(w io.Writer, img image.Image, opts Options) error {
+			return webp.Encode(w, img, &webp.Options{Lossless: opts.Lossless, Quality: opts.Quality})
+		},
+	})
+	Register(&Format{
+		Name:        "tiff",
+		Aliases:     []string{"tif"},
+		Extensions:  []string{".tiff", ".tif"},
+		MIME:        "image/tiff",
+		Description: "Tagged Image File Format",
+		Options:     []string{"tiff-compression"},
+		Encode:      encodeTIFF,
+	})
+	Register(&Format{
+		Name:        "ppm",
+		Extensions:  []string{".ppm"},
+		MIME:        "image/x-portable-pixmap",
+		Description: "Portable Pixmap, raw RGB for image-processing tools",
+		Encode:      encodePPM,
+	})
+	Register(&Format{
+		Name:        "pam",
+		Extensions:  []string{".pam"},
+		MIME:        "image/x-portable-arbitrarymap",
+		Description: "Portable Arbitrary Map, raw RGBA for image-processing tools",
+		Encode:      encodePAM,
+	})
+	Register(&Format{
+		Name:        "qoi",
+		Extensions:  []string{".qoi"},
+		MIME:        "image/qoi",
+		Description: "Quite OK Image, very fast lossless saves",
+		Encode:      encodeQOI,
+	})
+	Register(&Format{
+		Name:        "pdf",
+		Extensions:  []string{".pdf"},
+		MIME:        "application/pdf",
+		Description: "PDF document, page sized to the capture",
+		Quality:     QualityLossy,
+		Options:     []string{"pdf-compression", "pdf-caption"},
+		Encode:      encodePDF,
+	})
+}
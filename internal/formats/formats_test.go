@@ -0,0 +1,460 @@
+package formats
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/tiff"
+)
+
+// testImage draws a gradient with flat areas and an optional alpha ramp
+func testImage(width, height int, alpha bool) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBA{R: uint8(x * 7), G: uint8(y * 3), B: uint8((x ^ y) & 0xf0), A: 255}
+			if x > width/2 {
+				c = color.NRGBA{R: 250, G: 250, B: 250, A: 255}
+			}
+			if alpha {
+				c.A = uint8(x * 255 / width)
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantExt string
+	}{
+		{"png", "png", ".png"},
+		{"JPEG", "jpg", ".jpg"},
+		{"tif", "tiff", ".tiff"},
+		{"qoi", "qoi", ".qoi"},
+		{"heic", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, ok := Lookup(tt.name)
+			if ok != (tt.want != "") {
+				t.Fatalf("Lookup(%q) ok = %v", tt.name, ok)
+			}
+			if ok && (f.Name != tt.want || f.Extension() != tt.wantExt) {
+				t.Errorf("Lookup(%q) = %s %s, want %s %s", tt.name, f.Name, f.Extension(), tt.want, tt.wantExt)
+			}
+		})
+	}
+
+	if f, ok := FromExtension(".TIF"); !ok || f.Name != "tiff" {
+		t.Errorf("FromExtension(.TIF) = %v, %v, want tiff", f, ok)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	names := map[string]bool{}
+	extensions := map[string]bool{}
+	img := testImage(8, 4, false)
+
+	for _, f := range All() {
+		if f.Name == "" || f.MIME == "" || f.Description == "" || len(f.Extensions) == 0 || f.Encode == nil {
+			t.Errorf("format %q is incompletely registered", f.Name)
+			continue
+		}
+		for _, name := range append([]string{f.Name}, f.Aliases...) {
+			if names[name] {
+				t.Errorf("name %q is registered twice", name)
+			}
+			names[name] = true
+		}
+		for _, ext := range f.Extensions {
+			if extensions[ext] || !strings.HasPrefix(ext, ".") {
+				t.Errorf("extension %q is invalid or registered twice", ext)
+			}
+			extensions[ext] = true
+		}
+		if got, ok := FromExtension(f.Extension()); !ok || got != f {
+			t.Errorf("FromExtension(%q) does not return %s", f.Extension(), f.Name)
+		}
+		if !strings.Contains(Help("png"), "  "+f.Name+" ") {
+			t.Errorf("Help() does not list %s", f.Name)
+		}
+
+		var buf bytes.Buffer
+		if err := f.Encode(&buf, img, Options{Quality: 90}); err != nil || buf.Len() == 0 {
+			t.Errorf("%s Encode() wrote %d bytes, error = %v", f.Name, buf.Len(), err)
+		}
+	}
+
+	if got := QualityNames(); strings.Join(got, ",") != "jpg,webp,pdf" {
+		t.Errorf("QualityNames() = %v, want [jpg webp pdf]", got)
+	}
+	if got := NamesWithOption("lossless"); strings.Join(got, ",") != "webp" {
+		t.Errorf("NamesWithOption(lossless) = %v, want [webp]", got)
+	}
+}
+
+func TestEncodeTIFF(t *testing.T) {
+	for _, compression := range []string{TIFFNone, TIFFDeflate, TIFFLZW} {
+		for _, size := range []image.Point{{1, 1}, {301, 211}, {640, 400}} {
+			t.Run(fmt.Sprintf("%s %dx%d", compression, size.X, size.Y), func(t *testing.T) {
+				img := testImage(size.X, size.Y, true)
+				var buf bytes.Buffer
+				if err := encodeTIFF(&buf, img, Options{TIFFCompression: compression}); err != nil {
+					t.Fatalf("encodeTIFF() error = %v", err)
+				}
+				decoded, err := tiff.Decode(&buf)
+				if err != nil {
+					t.Fatalf("tiff.Decode() error = %v", err)
+				}
+				assertSameImage(t, decoded, img)
+			})
+		}
+	}
+}
+
+func TestEncodePNG(t *testing.T) {
+	// Flat image with few colors, as typical for UI screenshots
+	flat := image.NewNRGBA(image.Rect(0, 0, 120, 80))
+	for y := 0; y < 80; y++ {
+		for x := 0; x < 120; x++ {
+			flat.SetNRGBA(x, y, color.NRGBA{R: uint8(x / 10 * 20), G: uint8(y / 20 * 60), B: 200, A: uint8(255 - x/60)})
+		}
+	}
+
+	tests := []struct {
+		name         string
+		img          *image.NRGBA
+		opts         Options
+		wantPaletted bool
+	}{
+		{"none", testImage(64, 48, true), Options{PNGCompression: PNGNone}, false},
+		{"fast", testImage(64, 48, true), Options{PNGCompression: PNGFast}, false},
+		{"best", testImage(64, 48, true), Options{PNGCompression: PNGBest}, false},
+		{"palette", flat, Options{PNGPalette: true}, true},
+		{"palette with too many colors", testImage(64, 48, true), Options{PNGPalette: true}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var notes []string
+			tt.opts.Report = func(note string) { notes = append(notes, note) }
+
+			var buf bytes.Buffer
+			if err := encodePNG(&buf, tt.img, tt.opts); err != nil {
+				t.Fatalf("encodePNG() error = %v", err)
+			}
+			decoded, err := png.Decode(&buf)
+			if err != nil {
+				t.Fatalf("png.Decode() error = %v", err)
+			}
+			if _, ok := decoded.(*image.Paletted); ok != tt.wantPaletted {
+				t.Errorf("decoded %T, want paletted %v", decoded, tt.wantPaletted)
+			}
+			if tt.opts.PNGPalette && len(notes) != 1 {
+				t.Errorf("notes = %q, want one", notes)
+			}
+			assertSameImage(t, decoded, tt.img)
+		})
+	}
+
+	if err := encodePNG(&bytes.Buffer{}, flat, Options{PNGCompression: "max"}); err == nil {
+		t.Error("encodePNG() accepted an unsupported compression level")
+	}
+}
+
+func TestQuantize(t *testing.T) {
+	photo := image.NewNRGBA(image.Rect(0, 0, 160, 90))
+	for y := 0; y < 90; y++ {
+		for x := 0; x < 160; x++ {
+			photo.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 255 / 159), G: uint8(y * 255 / 89), B: uint8((x + y) % 256), A: 255})
+		}
+	}
+
+	for _, dither := range []string{DitherNone, DitherFloydSteinberg} {
+		for _, colors := range []int{2, 16, 256} {
+			t.Run(fmt.Sprintf("%s %d", dither, colors), func(t *testing.T) {
+				p := Quantize(photo, colors, dither)
+				if len(p.Palette) > colors {
+					t.Fatalf("palette has %d colors, want at most %d", len(p.Palette), colors)
+				}
+
+				// The mean color survives quantization, with or without dithering
+				var got, want [3]float64
+				for y := 0; y < 90; y++ {
+					for x := 0; x < 160; x++ {
+						q := p.At(x, y).(color.NRGBA)
+						o := photo.NRGBAAt(x, y)
+						got[0], got[1], got[2] = got[0]+float64(q.R), got[1]+float64(q.G), got[2]+float64(q.B)
+						want[0], want[1], want[2] = want[0]+float64(o.R), want[1]+float64(o.G), want[2]+float64(o.B)
+					}
+				}
+				for c := 0; c < 3; c++ {
+					if diff := (got[c] - want[c]) / (160 * 90); diff > 16 || diff < -16 {
+						t.Errorf("channel %d mean is off by %.1f", c, diff)
+					}
+				}
+			})
+		}
+	}
+
+	t.Run("exact", func(t *testing.T) {
+		img := image.NewNRGBA(image.Rect(0, 0, 64, 48))
+		for x := 0; x < 64; x++ {
+			for y := 0; y < 48; y++ {
+				img.SetNRGBA(x, y, color.NRGBA{R: uint8(x / 8 * 32), G: uint8(y / 8 * 40), A: 255})
+			}
+		}
+		assertSameImage(t, Quantize(img, 64, DitherFloydSteinberg), img)
+	})
+
+	t.Run("transparent", func(t *testing.T) {
+		img := testImage(300, 100, false)
+		for y := 0; y < 100; y++ {
+			img.SetNRGBA(0, y, color.NRGBA{})
+		}
+		p := Quantize(img, 32, DitherFloydSteinberg)
+		if len(p.Palette) > 32 {
+			t.Fatalf("palette has %d colors, want at most 32", len(p.Palette))
+		}
+		for y := 0; y < 100; y++ {
+			if _, _, _, a := p.At(0, y).RGBA(); a != 0 {
+				t.Fatalf("pixel (0, %d) is not transparent", y)
+			}
+			if _, _, _, a := p.At(1, y).RGBA(); a == 0 {
+				t.Fatalf("pixel (1, %d) is transparent", y)
+			}
+		}
+	})
+}
+
+func TestEncodeGIF(t *testing.T) {
+	f, _ := Lookup("gif")
+	img := testImage(200, 120, false)
+
+	var buf bytes.Buffer
+	if err := f.Encode(&buf, img, Options{Colors: 16, Dither: DitherNone}); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	decoded, err := gif.Decode(&buf)
+	if err != nil {
+		t.Fatalf("gif.Decode() error = %v", err)
+	}
+	if n := len(decoded.(*image.Paletted).Palette); n > 16 {
+		t.Errorf("palette has %d colors, want at most 16", n)
+	}
+	// The flat right half keeps its exact color
+	if c := color.NRGBAModel.Convert(decoded.At(150, 60)); c != img.NRGBAAt(150, 60) {
+		t.Errorf("flat area = %v, want %v", c, img.NRGBAAt(150, 60))
+	}
+}
+
+func TestPDF(t *testing.T) {
+	img := testImage(120, 50, false)
+
+	var buf bytes.Buffer
+	p := NewPDFWriter(&buf, Options{Quality: 90})
+	if err := p.AddPage(img, "2024-01-02 15:04:05 UTC | display 0 | host (lab)"); err != nil {
+		t.Fatalf("AddPage() error = %v", err)
+	}
+	p.opts.PDFCompression = PDFFlate
+	if err := p.AddPage(img, ""); err != nil {
+		t.Fatalf("AddPage() error = %v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	data := buf.Bytes()
+
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(data, []byte("%%EOF\n")) {
+		t.Fatal("missing PDF header or trailer")
+	}
+
+	// Every cross-reference entry points at its object
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(data)
+	xref, _ := strconv.Atoi(string(m[1]))
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(data[xref:], -1)
+	if len(entries) != 9 {
+		t.Fatalf("xref has %d objects, want 9", len(entries))
+	}
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(string(entry[1]))
+		if want := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(data[offset:], []byte(want)) {
+			t.Errorf("xref entry %d does not point at its object", i+1)
+		}
+	}
+
+	if !bytes.Contains(data, []byte("/Count 2")) {
+		t.Error("page tree does not count 2 pages")
+	}
+	// The caption adds a strip below the first image only
+	if !bytes.Contains(data, []byte("/MediaBox [0 0 120 70]")) || !bytes.Contains(data, []byte("/MediaBox [0 0 120 50]")) {
+		t.Error("pages are not sized to the image and caption")
+	}
+	if !bytes.Contains(data, []byte(`(2024-01-02 15:04:05 UTC | display 0 | host \(lab\)) Tj`)) {
+		t.Error("caption is missing or not escaped")
+	}
+
+	streams := regexp.MustCompile(`(?s)/Filter /(\w+) /Length (\d+) >>\nstream\n`).FindAllSubmatchIndex(data, -1)
+	if len(streams) != 2 {
+		t.Fatalf("found %d image streams, want 2", len(streams))
+	}
+	for _, s := range streams {
+		length, _ := strconv.Atoi(string(data[s[4]:s[5]]))
+		stream := data[s[1] : s[1]+length]
+		switch filter := string(data[s[2]:s[3]]); filter {
+		case "DCTDecode":
+			if decoded, err := jpeg.Decode(bytes.NewReader(stream)); err != nil || decoded.Bounds() != img.Bounds() {
+				t.Errorf("JPEG page image: %v", err)
+			}
+		case "FlateDecode":
+			zr, err := zlib.NewReader(bytes.NewReader(stream))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rgb, _ := io.ReadAll(zr)
+			if len(rgb) != 3*120*50 || rgb[3*(120*49+119)] != img.Pix[4*(120*49+119)] {
+				t.Error("Flate page image differs from the capture")
+			}
+		}
+	}
+}
+
+func TestEncodePNM(t *testing.T) {
+	img := testImage(3, 2, true)
+
+	var ppm bytes.Buffer
+	if err := encodePPM(&ppm, img, Options{}); err != nil {
+		t.Fatalf("encodePPM() error = %v", err)
+	}
+	header := "P6\n3 2\n255\n"
+	if got := ppm.String(); len(got) != len(header)+3*6 || got[:len(header)] != header {
+		t.Fatalf("PPM = %q", got)
+	}
+	if c := img.NRGBAAt(2, 1); !bytes.Equal(ppm.Bytes()[len(header)+15:], []byte{c.R, c.G, c.B}) {
+		t.Errorf("last PPM pixel = %v, want %v", ppm.Bytes()[len(header)+15:], c)
+	}
+
+	var pam bytes.Buffer
+	if err := encodePAM(&pam, img, Options{}); err != nil {
+		t.Fatalf("encodePAM() error = %v", err)
+	}
+	header = "P7\nWIDTH 3\nHEIGHT 2\nDEPTH 4\nMAXVAL 255\nTUPLTYPE RGB_ALPHA\nENDHDR\n"
+	if got := pam.String(); got != header+string(img.Pix) {
+		t.Errorf("PAM = %q", got)
+	}
+}
+
+func TestEncodeQOI(t *testing.T) {
+	tests := []struct {
+		name         string
+		img          *image.NRGBA
+		wantChannels byte
+	}{
+		{"single pixel", testImage(1, 1, false), 3},
+		{"opaque", testImage(300, 100, false), 3},
+		{"alpha", testImage(97, 33, true), 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := encodeQOI(&buf, tt.img, Options{}); err != nil {
+				t.Fatalf("encodeQOI() error = %v", err)
+			}
+			decoded, channels, err := decodeQOI(buf.Bytes())
+			if err != nil {
+				t.Fatalf("decodeQOI() error = %v", err)
+			}
+			if channels != tt.wantChannels {
+				t.Errorf("channels = %d, want %d", channels, tt.wantChannels)
+			}
+			assertSameImage(t, decoded, tt.img)
+		})
+	}
+}
+
+// decodeQOI is a minimal QOI decoder following the specification
+func decodeQOI(data []byte) (*image.NRGBA, byte, error) {
+	if len(data) < 22 || string(data[:4]) != "qoif" {
+		return nil, 0, fmt.Errorf("bad header")
+	}
+	width := int(binary.BigEndian.Uint32(data[4:]))
+	height := int(binary.BigEndian.Uint32(data[8:]))
+	channels := data[12]
+	if !bytes.Equal(data[len(data)-8:], qoiEndMarker) {
+		return nil, 0, fmt.Errorf("missing end marker")
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	var index [64][4]byte
+	px := [4]byte{0, 0, 0, 255}
+	p, run := 14, 0
+	for i := 0; i < len(img.Pix); i += 4 {
+		if run > 0 {
+			run--
+		} else {
+			b := data[p]
+			p++
+			switch {
+			case b == qoiOpRGB:
+				copy(px[:3], data[p:p+3])
+				p += 3
+			case b == qoiOpRGBA:
+				copy(px[:], data[p:p+4])
+				p += 4
+			case b&0xc0 == qoiOpIndex:
+				px = index[b]
+			case b&0xc0 == qoiOpDiff:
+				px[0] += b>>4&3 - 2
+				px[1] += b>>2&3 - 2
+				px[2] += b&3 - 2
+			case b&0xc0 == qoiOpLuma:
+				dg := b&0x3f - 32
+				px[0] += dg + data[p]>>4 - 8
+				px[1] += dg
+				px[2] += dg + data[p]&0xf - 8
+				p++
+			default:
+				run = int(b & 0x3f)
+			}
+			index[(int(px[0])*3+int(px[1])*5+int(px[2])*7+int(px[3])*11)%64] = px
+		}
+		copy(img.Pix[i:i+4], px[:])
+	}
+	if p != len(data)-8 {
+		return nil, 0, fmt.Errorf("%d trailing bytes", len(data)-8-p)
+	}
+	return img, channels, nil
+}
+
+func assertSameImage(t *testing.T, got image.Image, want *image.NRGBA) {
+	t.Helper()
+	if got.Bounds() != want.Bounds() {
+		t.Fatalf("bounds = %v, want %v", got.Bounds(), want.Bounds())
+	}
+	b := want.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if c := color.NRGBAModel.Convert(got.At(x, y)); c != want.NRGBAAt(x, y) {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, c, want.NRGBAAt(x, y))
+			}
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package formats
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"strings"
+)
+
+// PDF image compression methods
+const (
+	PDFJPEG  = "jpeg"
+	PDFFlate = "flate"
+)
+
+// DefaultPDFCompression is used when no compression is configured
+const DefaultPDFCompression = PDFJPEG
+
+// IsPDFCompression reports whether name is a supported PDF image compression
+func IsPDFCompression(name string) bool {
+	switch strings.ToLower(name) {
+	case PDFJPEG, PDFFlate:
+		return true
+	}
+	return false
+}
+
+// Objects with fixed numbers; pages start after them
+const (
+	pdfCatalog = 1
+	pdfPages   = 2
+	pdfFont    = 3
+	pdfFirst   = 4
+)
+
+// encodePDF writes a single page PDF
+func encodePDF(w io.Writer, img image.Image, opts Options) error {
+	p := NewPDFWriter(w, opts)
+	if err := p.AddPage(img, opts.Caption); err != nil {
+		return err
+	}
+	return p.Close()
+}
+
+// PDFWriter writes a PDF document with one page per image. Each page is
+// sized to its image at 72 dpi, so one pixel is one point, with room for a
+// caption line below the image if one is given. Pages are written as they
+// are added; the page tree and cross-reference table follow on Close.
+type PDFWriter struct {
+	w       *bufio.Writer
+	opts    Options
+	written int64
+	offsets []int64 // Byte offset of every object, indexed by number-1
+	pages   []int
+}
+
+// NewPDFWriter starts a PDF document on w
+func NewPDFWriter(w io.Writer, opts Options) *PDFWriter {
+	p := &PDFWriter{w: bufio.NewWriter(w), opts: opts, offsets: make([]int64, pdfFirst-1)}
+	p.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+	return p
+}
+
+// printf writes formatted output and keeps track of the file offset
+func (p *PDFWriter) printf(format string, args ...any) {
+	n, _ := fmt.Fprintf(p.w, format, args...)
+	p.written += int64(n)
+}
+
+// write writes raw bytes and keeps track of the file offset
+func (p *PDFWriter) write(data []byte) {
+	n, _ := p.w.Write(data)
+	p.written += int64(n)
+}
+
+// newObject allocates the next object number
+func (p *PDFWriter) newObject() int {
+	p.offsets = append(p.offsets, 0)
+	return len(p.offsets)
+}
+
+// beginObject records the offset of object num and writes its header
+func (p *PDFWriter) beginObject(num int) {
+	p.offsets[num-1] = p.written
+	p.printf("%d 0 obj\n", num)
+}
+
+// writeStream writes object num as a stream with the given dictionary entries
+func (p *PDFWriter) writeStream(num int, dict string, data []byte) {
+	p.beginObject(num)
+	p.printf("<< %s /Length %d >>\nstream\n", dict, len(data))
+	p.write(data)
+	p.printf("\nendstream\nendobj\n")
+}
+
+// AddPage appends a page showing img, with caption below it unless empty
+func (p *PDFWriter) AddPage(img image.Image, caption string) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+
+	var data bytes.Buffer
+	var filter string
+	switch strings.ToLower(p.opts.PDFCompression) {
+	case "", PDFJPEG:
+		quality := p.opts.Quality
+		if quality <= 0 {
+			quality = jpeg.DefaultQuality
+		}
+		if err := jpeg.Encode(&data, img, &jpeg.Options{Quality: quality}); err != nil {
+			return fmt.Errorf("failed to encode page image: %w", err)
+		}
+		filter = "/DCTDecode"
+	case PDFFlate:
+		m := ToNRGBA(img)
+		zw := zlib.NewWriter(&data)
+		row := make([]byte, 3*width)
+		for y := 0; y < height; y++ {
+			pix := m.Pix[y*m.Stride:]
+			for x := 0; x < width; x++ {
+				copy(row[3*x:3*x+3], pix[4*x:4*x+3])
+			}
+			zw.Write(row)
+		}
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("failed to compress page image: %w", err)
+		}
+		filter = "/FlateDecode"
+	default:
+		return fmt.Errorf("unsupported PDF compression: %s", p.opts.PDFCompression)
+	}
+
+	// The caption strip scales with the image so it stays readable
+	fontSize, strip := 0, 0
+	if caption != "" {
+		fontSize = max(10, width/100)
+		strip = 2 * fontSize
+	}
+
+	imageNum, contentNum, pageNum := p.newObject(), p.newObject(), p.newObject()
+	p.writeStream(imageNum, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter %s",
+		width, height, filter), data.Bytes())
+
+	content := fmt.Sprintf("q %d 0 0 %d 0 %d cm /Im0 Do Q\n", width, height, strip)
+	if caption != "" {
+		content += fmt.Sprintf("BT /F0 %d Tf %d %d Td (%s) Tj ET\n", fontSize, fontSize/2, fontSize*2/3, pdfString(caption))
+	}
+	p.writeStream(contentNum, "", []byte(content))
+
+	resources := fmt.Sprintf("/XObject << /Im0 %d 0 R >>", imageNum)
+	if caption != "" {
+		resources += fmt.Sprintf(" /Font << /F0 %d 0 R >>", pdfFont)
+	}
+	p.beginObject(pageNum)
+	p.printf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %d %d] /Resources << %s >> /Contents %d 0 R >>\nendobj\n",
+		pdfPages, width, height+strip, resources, contentNum)
+	p.pages = append(p.pages, pageNum)
+
+	return p.w.Flush()
+}
+
+// Close writes the page tree, the catalog and the cross-reference table
+func (p *PDFWriter) Close() error {
+	if len(p.pages) == 0 {
+		return fmt.Errorf("PDF has no pages")
+	}
+
+	p.beginObject(pdfFont)
+	p.printf("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>\nendobj\n")
+
+	kids := make([]string, len(p.pages))
+	for i, num := range p.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", num)
+	}
+	p.beginObject(pdfPages)
+	p.printf("<< /Type /Pages /Kids [%s] /Count %d >>\nendobj\n", strings.Join(kids, " "), len(p.pages))
+
+	p.beginObject(pdfCatalog)
+	p.printf("<< /Type /Catalog /Pages %d 0 R >>\nendobj\n", pdfPages)
+
+	xref := p.written
+	p.printf("xref\n0 %d\n0000000000 65535 f \n", len(p.offsets)+1)
+	for _, offset := range p.offsets {
+		p.printf("%010d 00000 n \n", offset)
+	}
+	p.printf("trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(p.offsets)+1, pdfCatalog, xref)
+	return p.w.Flush()
+}
+
+// pdfString escapes s for a literal string in the WinAnsi encoding of the
+// standard fonts. Characters outside Latin-1 are replaced by '?'.
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,131 @@
+package formats
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+	"sync"
+)
+
+// PNG compression levels
+const (
+	PNGNone    = "none"
+	PNGFast    = "fast"
+	PNGDefault = "default"
+	PNGBest    = "best"
+)
+
+// DefaultPNGCompression is used when no compression level is configured
+const DefaultPNGCompression = PNGDefault
+
+// IsPNGCompression reports whether name is a supported PNG compression level
+func IsPNGCompression(name string) bool {
+	_, ok := pngEncoders[strings.ToLower(name)]
+	return ok
+}
+
+// pngBufferPool lets consecutive encodes, such as the frames of a batch run,
+// reuse the encoder's zlib writer and row buffers
+type pngBufferPool struct {
+	pool sync.Pool
+}
+
+// Get implements png.EncoderBufferPool
+func (p *pngBufferPool) Get() *png.EncoderBuffer {
+	b, _ := p.pool.Get().(*png.EncoderBuffer)
+	return b
+}
+
+// Put implements png.EncoderBufferPool
+func (p *pngBufferPool) Put(b *png.EncoderBuffer) {
+	p.pool.Put(b)
+}
+
+// pngEncoders holds one encoder per compression level. Each level gets its
+// own pool because the pooled zlib writer is bound to the level.
+var pngEncoders = map[string]*png.Encoder{
+	PNGNone:    {CompressionLevel: png.NoCompression, BufferPool: &pngBufferPool{}},
+	PNGFast:    {CompressionLevel: png.BestSpeed, BufferPool: &pngBufferPool{}},
+	PNGDefault: {CompressionLevel: png.DefaultCompression, BufferPool: &pngBufferPool{}},
+	PNGBest:    {CompressionLevel: png.BestCompression, BufferPool: &pngBufferPool{}},
+}
+
+// encodePNG writes a PNG with the configured compression level. With
+// PNGPalette set, frames with at most 256 distinct colors are written as
+// paletted PNG, which is exact and usually much smaller for UI screenshots.
+// If Colors is also set, other frames are quantized to that many colors.
+func encodePNG(w io.Writer, img image.Image, opts Options) error {
+	level := strings.ToLower(opts.PNGCompression)
+	if level == "" {
+		level = DefaultPNGCompression
+	}
+	enc, ok := pngEncoders[level]
+	if !ok {
+		return fmt.Errorf("unsupported PNG compression: %s", opts.PNGCompression)
+	}
+
+	if !opts.PNGPalette {
+		return enc.Encode(w, img)
+	}
+	var paletted *image.Paletted
+	if opts.Colors > 0 {
+		paletted = Quantize(ToNRGBA(img), opts.PaletteSize(), opts.Dither)
+	} else if paletted = toPaletted(ToNRGBA(img), MaxColors); paletted == nil {
+		opts.report("png: more than 256 colors, kept true color")
+		return enc.Encode(w, img)
+	}
+	if opts.Report == nil {
+		return enc.Encode(w, paletted)
+	}
+
+	// Measure the savings against the true color encoding for the report
+	var full, reduced bytes.Buffer
+	if err := enc.Encode(&full, img); err != nil {
+		return err
+	}
+	if err := enc.Encode(&reduced, paletted); err != nil {
+		return err
+	}
+	opts.report(fmt.Sprintf("png: %d-color palette, %d bytes instead of %d (%.1f%% smaller)",
+		len(paletted.Palette), reduced.Len(), full.Len(), 100*float64(full.Len()-reduced.Len())/float64(full.Len())))
+	_, err := reduced.WriteTo(w)
+	return err
+}
+
+// toPaletted returns m as a paletted image holding exactly its colors, or nil
+// if m has more than colors distinct colors
+func toPaletted(m *image.NRGBA, colors int) *image.Paletted {
+	width, height := m.Rect.Dx(), m.Rect.Dy()
+	p := image.NewPaletted(image.Rect(0, 0, width, height), nil)
+	index := make(map[color.NRGBA]uint8, 256)
+
+	var last color.NRGBA
+	var lastIndex uint8
+	for y := 0; y < height; y++ {
+		pix := m.Pix[y*m.Stride:]
+		row := p.Pix[y*p.Stride:]
+		for x := 0; x < width; x++ {
+			c := color.NRGBA{R: pix[4*x], G: pix[4*x+1], B: pix[4*x+2], A: pix[4*x+3]}
+			if c == last && len(p.Palette) > 0 {
+				row[x] = lastIndex
+				continue
+			}
+			i, ok := index[c]
+			if !ok {
+				if len(p.Palette) == colors {
+					return nil
+				}
+				i = uint8(len(p.Palette))
+				index[c] = i
+				p.Palette = append(p.Palette, c)
+			}
+			row[x] = i
+			last, lastIndex = c, i
+		}
+	}
+	return p
+}
@@ -0,0 +1,41 @@
+package formats
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+)
+
+// encodePPM writes a binary PPM (P6). PPM has no alpha channel, so alpha is
+// dropped and the color of each pixel is written as is.
+func encodePPM(w io.Writer, img image.Image, opts Options) error {
+	m := ToNRGBA(img)
+	width, height := m.Rect.Dx(), m.Rect.Dy()
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "P6\n%d %d\n255\n", width, height)
+	row := make([]byte, 3*width)
+	for y := 0; y < height; y++ {
+		pix := m.Pix[y*m.Stride:]
+		for x := 0; x < width; x++ {
+			copy(row[3*x:3*x+3], pix[4*x:4*x+3])
+		}
+		bw.Write(row)
+	}
+	return bw.Flush()
+}
+
+// encodePAM writes a PAM (P7) image of RGB_ALPHA tuples, which keeps the
+// alpha channel
+func encodePAM(w io.Writer, img image.Image, opts Options) error {
+	m := ToNRGBA(img)
+	width, height := m.Rect.Dx(), m.Rect.Dy()
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "P7\nWIDTH %d\nHEIGHT %d\nDEPTH 4\nMAXVAL 255\nTUPLTYPE RGB_ALPHA\nENDHDR\n", width, height)
+	for y := 0; y < height; y++ {
+		bw.Write(m.Pix[y*m.Stride : y*m.Stride+4*width])
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,100 @@
+package formats
+
+import (
+	"bufio"
+	"encoding/binary"
+	"image"
+	"io"
+)
+
+// QOI chunk tags, see https://qoiformat.org/qoi-specification.pdf
+const (
+	qoiOpIndex = 0x00
+	qoiOpDiff  = 0x40
+	qoiOpLuma  = 0x80
+	qoiOpRun   = 0xc0
+	qoiOpRGB   = 0xfe
+	qoiOpRGBA  = 0xff
+
+	qoiMaxRun = 62
+)
+
+// qoiEndMarker terminates the chunk stream
+var qoiEndMarker = []byte{0, 0, 0, 0, 0, 0, 0, 1}
+
+// encodeQOI writes a QOI image. Images without transparency are marked as
+// having three channels.
+func encodeQOI(w io.Writer, img image.Image, opts Options) error {
+	m := ToNRGBA(img)
+	width, height := m.Rect.Dx(), m.Rect.Dy()
+
+	channels := byte(3)
+	for y := 0; y < height && channels == 3; y++ {
+		for x := 0; x < width; x++ {
+			if m.Pix[y*m.Stride+4*x+3] != 0xff {
+				channels = 4
+				break
+			}
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	var header [14]byte
+	copy(header[:], "qoif")
+	binary.BigEndian.PutUint32(header[4:], uint32(width))
+	binary.BigEndian.PutUint32(header[8:], uint32(height))
+	header[12] = channels
+	header[13] = 0 // sRGB with linear alpha
+	bw.Write(header[:])
+
+	var index [64][4]byte
+	prev := [4]byte{0, 0, 0, 255}
+	run := 0
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*m.Stride + 4*x
+			px := [4]byte{m.Pix[i], m.Pix[i+1], m.Pix[i+2], m.Pix[i+3]}
+
+			if px == prev {
+				run++
+				if run == qoiMaxRun || (y == height-1 && x == width-1) {
+					bw.WriteByte(qoiOpRun | byte(run-1))
+					run = 0
+				}
+				continue
+			}
+
+			if run > 0 {
+				bw.WriteByte(qoiOpRun | byte(run-1))
+				run = 0
+			}
+
+			hash := (int(px[0])*3 + int(px[1])*5 + int(px[2])*7 + int(px[3])*11) % 64
+			switch {
+			case index[hash] == px:
+				bw.WriteByte(qoiOpIndex | byte(hash))
+			case px[3] != prev[3]:
+				index[hash] = px
+				bw.Write([]byte{qoiOpRGBA, px[0], px[1], px[2], px[3]})
+			default:
+				index[hash] = px
+				dr := int(int8(px[0] - prev[0]))
+				dg := int(int8(px[1] - prev[1]))
+				db := int(int8(px[2] - prev[2]))
+				drg, dbg := dr-dg, db-dg
+				switch {
+				case dr >= -2 && dr <= 1 && dg >= -2 && dg <= 1 && db >= -2 && db <= 1:
+					bw.WriteByte(qoiOpDiff | byte(dr+2)<<4 | byte(dg+2)<<2 | byte(db+2))
+				case dg >= -32 && dg <= 31 && drg >= -8 && drg <= 7 && dbg >= -8 && dbg <= 7:
+					bw.Write([]byte{qoiOpLuma | byte(dg+32), byte(drg+8)<<4 | byte(dbg+8)})
+				default:
+					bw.Write([]byte{qoiOpRGB, px[0], px[1], px[2]})
+				}
+			}
+			prev = px
+		}
+	}
+
+	bw.Write(qoiEndMarker)
+	return bw.Flush()
+}
@@ -0,0 +1,277 @@
+package formats
+
+import (
+	"image"
+	"image/color"
+	"sort"
+	"strings"
+)
+
+// Dithering methods for paletted output
+const (
+	DitherNone           = "none"
+	DitherFloydSteinberg = "floyd-steinberg"
+)
+
+// DefaultDither is used when no dithering method is configured
+const DefaultDither = DitherFloydSteinberg
+
+// MaxColors is the largest palette a paletted format can hold
+const MaxColors = 256
+
+// IsDither reports whether name is a supported dithering method
+func IsDither(name string) bool {
+	switch strings.ToLower(name) {
+	case DitherNone, DitherFloydSteinberg:
+		return true
+	}
+	return false
+}
+
+// PaletteSize returns the palette size requested by o, MaxColors if unset
+func (o Options) PaletteSize() int {
+	if o.Colors <= 0 || o.Colors > MaxColors {
+		return MaxColors
+	}
+	return o.Colors
+}
+
+// Quantize returns m reduced to at most colors colors. Frames that already
+// fit are converted exactly; others get an adaptive median cut palette and
+// are mapped onto it with the dithering method dither, DefaultDither if empty.
+func Quantize(m *image.NRGBA, colors int, dither string) *image.Paletted {
+	if dither == "" {
+		dither = DefaultDither
+	}
+	if p := toPaletted(m, colors); p != nil {
+		return p
+	}
+	return MapToPalette(m, MedianCut(m, colors), dither)
+}
+
+// Transparency threshold: pixels with lower alpha map to the transparent
+// palette entry, all others are treated as opaque
+const alphaThreshold = 0x80
+
+// colorBin accumulates the pixels whose color falls into one cell of the
+// 5 bits per channel histogram
+type colorBin struct {
+	count   int
+	r, g, b int
+	avg     [3]int
+}
+
+// colorBox is a set of histogram bins that median cut keeps splitting
+type colorBox struct {
+	bins  []*colorBin
+	count int
+}
+
+// longestAxis returns the channel with the widest range in the box and
+// that range
+func (b *colorBox) longestAxis() (int, int) {
+	lo := [3]int{255, 255, 255}
+	hi := [3]int{}
+	for _, bin := range b.bins {
+		for c := 0; c < 3; c++ {
+			lo[c] = min(lo[c], bin.avg[c])
+			hi[c] = max(hi[c], bin.avg[c])
+		}
+	}
+	axis := 0
+	for c := 1; c < 3; c++ {
+		if hi[c]-lo[c] > hi[axis]-lo[axis] {
+			axis = c
+		}
+	}
+	return axis, hi[axis] - lo[axis]
+}
+
+// MedianCut builds a palette of at most colors entries for m. Boxes of the
+// color histogram are split at the weighted median of their widest channel,
+// always splitting the box with the largest spread times pixel count. One
+// entry is reserved for transparency if m has transparent pixels.
+func MedianCut(m *image.NRGBA, colors int) color.Palette {
+	var hist [1 << 15]colorBin
+	transparent := false
+	width, height := m.Rect.Dx(), m.Rect.Dy()
+	for y := 0; y < height; y++ {
+		pix := m.Pix[y*m.Stride : y*m.Stride+4*width]
+		for i := 0; i < len(pix); i += 4 {
+			if pix[i+3] < alphaThreshold {
+				transparent = true
+				continue
+			}
+			bin := &hist[int(pix[i]>>3)<<10|int(pix[i+1]>>3)<<5|int(pix[i+2]>>3)]
+			bin.count++
+			bin.r += int(pix[i])
+			bin.g += int(pix[i+1])
+			bin.b += int(pix[i+2])
+		}
+	}
+	if transparent {
+		colors--
+	}
+
+	box := &colorBox{}
+	for i := range hist {
+		bin := &hist[i]
+		if bin.count == 0 {
+			continue
+		}
+		bin.avg = [3]int{bin.r / bin.count, bin.g / bin.count, bin.b / bin.count}
+		box.bins = append(box.bins, bin)
+		box.count += bin.count
+	}
+
+	boxes := []*colorBox{box}
+	for len(boxes) < colors {
+		best, bestScore, bestAxis := -1, 0, 0
+		for i, b := range boxes {
+			if len(b.bins) < 2 {
+				continue
+			}
+			axis, spread := b.longestAxis()
+			if score := spread * b.count; best < 0 || score > bestScore {
+				best, bestScore, bestAxis = i, score, axis
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		b := boxes[best]
+		sort.Slice(b.bins, func(i, j int) bool { return b.bins[i].avg[bestAxis] < b.bins[j].avg[bestAxis] })
+
+		// Split after the bin that reaches half of the pixels, keeping at
+		// least one bin on each side
+		split, seen := 1, b.bins[0].count
+		for split < len(b.bins)-1 && seen+b.bins[split].count <= b.count/2 {
+			seen += b.bins[split].count
+			split++
+		}
+		upper := &colorBox{bins: b.bins[split:], count: b.count - seen}
+		b.bins, b.count = b.bins[:split], seen
+		boxes = append(boxes, upper)
+	}
+
+	palette := make(color.Palette, 0, len(boxes)+1)
+	for _, b := range boxes {
+		if b.count == 0 {
+			continue
+		}
+		var r, g, bl int
+		for _, bin := range b.bins {
+			r += bin.r
+			g += bin.g
+			bl += bin.b
+		}
+		palette = append(palette, color.NRGBA{R: uint8(r / b.count), G: uint8(g / b.count), B: uint8(bl / b.count), A: 0xff})
+	}
+	if transparent {
+		palette = append(palette, color.NRGBA{})
+	}
+	return palette
+}
+
+// paletteMatcher finds the nearest opaque palette entry of a color, caching
+// the answer per 6 bits per channel cell
+type paletteMatcher struct {
+	palette []color.NRGBA
+	cache   []int16
+}
+
+func newPaletteMatcher(palette color.Palette) *paletteMatcher {
+	pm := &paletteMatcher{cache: make([]int16, 1<<18)}
+	for _, c := range palette {
+		pm.palette = append(pm.palette, color.NRGBAModel.Convert(c).(color.NRGBA))
+	}
+	for i := range pm.cache {
+		pm.cache[i] = -1
+	}
+	return pm
+}
+
+// index returns the index of the opaque palette entry nearest to r, g, b
+func (pm *paletteMatcher) index(r, g, b int) uint8 {
+	key := (r>>2)<<12 | (g>>2)<<6 | b>>2
+	if i := pm.cache[key]; i >= 0 {
+		return uint8(i)
+	}
+	best, bestDist := 0, -1
+	for i, c := range pm.palette {
+		if c.A < alphaThreshold {
+			continue
+		}
+		dr, dg, db := r-int(c.R), g-int(c.G), b-int(c.B)
+		if d := 2*dr*dr + 4*dg*dg + 3*db*db; bestDist < 0 || d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	pm.cache[key] = int16(best)
+	return uint8(best)
+}
+
+// transparentIndex returns the index of the transparent entry of the
+// palette, or -1
+func (pm *paletteMatcher) transparentIndex() int {
+	for i, c := range pm.palette {
+		if c.A < alphaThreshold {
+			return i
+		}
+	}
+	return -1
+}
+
+// MapToPalette maps every pixel of m to palette. With Floyd-Steinberg
+// dithering the quantization error of each pixel is spread over its
+// unvisited neighbors.
+func MapToPalette(m *image.NRGBA, palette color.Palette, dither string) *image.Paletted {
+	width, height := m.Rect.Dx(), m.Rect.Dy()
+	p := image.NewPaletted(image.Rect(0, 0, width, height), palette)
+	pm := newPaletteMatcher(palette)
+	transparent := pm.transparentIndex()
+	dithered := strings.ToLower(dither) == DitherFloydSteinberg
+
+	// Errors for the current and the next row, in 1/16 units, with a pixel
+	// of padding on each side
+	cur := make([][3]int, width+2)
+	next := make([][3]int, width+2)
+	for y := 0; y < height; y++ {
+		pix := m.Pix[y*m.Stride:]
+		row := p.Pix[y*p.Stride:]
+		for x := 0; x < width; x++ {
+			i := 4 * x
+			if pix[i+3] < alphaThreshold && transparent >= 0 {
+				row[x] = uint8(transparent)
+				continue
+			}
+			if !dithered {
+				row[x] = pm.index(int(pix[i]), int(pix[i+1]), int(pix[i+2]))
+				continue
+			}
+
+			var c [3]int
+			for ch := 0; ch < 3; ch++ {
+				c[ch] = clampUint8(int(pix[i+ch]) + (cur[x+1][ch]+8)>>4)
+			}
+			idx := pm.index(c[0], c[1], c[2])
+			row[x] = idx
+			q := pm.palette[idx]
+			e := [3]int{c[0] - int(q.R), c[1] - int(q.G), c[2] - int(q.B)}
+			for ch := 0; ch < 3; ch++ {
+				cur[x+2][ch] += 7 * e[ch]
+				next[x][ch] += 3 * e[ch]
+				next[x+1][ch] += 5 * e[ch]
+				next[x+2][ch] += e[ch]
+			}
+		}
+		cur, next = next, cur
+		clear(next)
+	}
+	return p
+}
+
+func clampUint8(v int) int {
+	return min(max(v, 0), 255)
+}
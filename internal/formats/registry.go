@@ -0,0 +1,180 @@
+// Package formats is the registry of the image formats screenshots can be
+// written in. Each format registers its names, extensions, media type,
+// quality semantics, options and encoder once; validation, extension
+// handling, encoding and the command help are all derived from the registry.
+package formats
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"strings"
+)
+
+// Options are the encoder settings shared by all formats. Each encoder uses
+// the fields that apply to it and ignores the rest.
+type Options struct {
+	Quality         int    // JPEG and lossy WebP quality (1-100)
+	Lossless        bool   // Use lossless compression where the format offers both
+	TIFFCompression string // TIFF compression: none, deflate or lzw
+	PNGCompression  string // PNG compression level: none, fast, default or best
+	PNGPalette      bool   // Write PNGs with at most 256 colors as paletted images
+	Colors          int    // Palette size of paletted output (2-256), 0 for the format default
+	Dither          string // Dithering of paletted output: none or floyd-steinberg
+	PDFCompression  string // PDF page image compression: jpeg or flate
+	Caption         string // Caption line below the image of document formats (pdf)
+
+	// Report, if set, receives notes about choices the encoder made, such as
+	// the savings of a palette reduction, for verbose output
+	Report func(note string)
+}
+
+// report passes note to the Report hook if one is set
+func (o Options) report(note string) {
+	if o.Report != nil {
+		o.Report(note)
+	}
+}
+
+// QualityMode describes what --quality means for a format
+type QualityMode int
+
+const (
+	QualityIgnored QualityMode = iota // Always lossless, --quality has no effect
+	QualityLossy                      // --quality trades file size for fidelity
+)
+
+// Format describes a registered output format
+type Format struct {
+	Name        string   // Canonical name, e.g. "jpg"
+	Aliases     []string // Other accepted names, e.g. "jpeg"
+	Extensions  []string // File extensions with the leading dot; the first is written
+	MIME        string   // Media type, e.g. "image/jpeg"
+	Description string   // Short description for help text
+	Quality     QualityMode
+	Options     []string // Flags besides --quality the encoder honors, e.g. "lossless"
+	Encode      func(w io.Writer, img image.Image, opts Options) error
+}
+
+// Extension returns the file extension written for the format
+func (f *Format) Extension() string {
+	return f.Extensions[0]
+}
+
+var registry []*Format
+
+// Register adds a format to the registry
+func Register(f *Format) {
+	registry = append(registry, f)
+}
+
+// All returns the registered formats in registration order
+func All() []*Format {
+	return registry
+}
+
+// Lookup returns the format with the given name or alias, ignoring case
+func Lookup(name string) (*Format, bool) {
+	name = strings.ToLower(name)
+	for _, f := range registry {
+		if f.Name == name {
+			return f, true
+		}
+		for _, alias := range f.Aliases {
+			if alias == name {
+				return f, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// FromExtension returns the format written with the file extension ext, which
+// includes the leading dot, ignoring case
+func FromExtension(ext string) (*Format, bool) {
+	ext = strings.ToLower(ext)
+	for _, f := range registry {
+		for _, e := range f.Extensions {
+			if e == ext {
+				return f, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Names returns the canonical names of all formats
+func Names() []string {
+	names := make([]string, len(registry))
+	for i, f := range registry {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// QualityNames returns the names of the formats that honor --quality
+func QualityNames() []string {
+	var names []string
+	for _, f := range registry {
+		if f.Quality == QualityLossy {
+			names = append(names, f.Name)
+		}
+	}
+	return names
+}
+
+// NamesWithOption returns the names of the formats that honor the flag option
+func NamesWithOption(option string) []string {
+	var names []string
+	for _, f := range registry {
+		for _, o := range f.Options {
+			if o == option {
+				names = append(names, f.Name)
+				break
+			}
+		}
+	}
+	return names
+}
+
+// Help returns one line per format for the command help, marking the
+// default format and listing aliases, --quality support and options
+func Help(defaultName string) string {
+	var b strings.Builder
+	for i, f := range registry {
+		var notes []string
+		if f.Name == defaultName {
+			notes = append(notes, "default")
+		}
+		for _, alias := range f.Aliases {
+			notes = append(notes, "alias "+alias)
+		}
+		if f.Quality == QualityLossy {
+			notes = append(notes, "-q")
+		}
+		for _, o := range f.Options {
+			notes = append(notes, "--"+o)
+		}
+
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "  %-5s- %s", f.Name, f.Description)
+		if len(notes) > 0 {
+			fmt.Fprintf(&b, " (%s)", strings.Join(notes, ", "))
+		}
+	}
+	return b.String()
+}
+
+// ToNRGBA returns img as non-premultiplied RGBA with its origin at (0, 0)
+func ToNRGBA(img image.Image) *image.NRGBA {
+	b := img.Bounds()
+	if m, ok := img.(*image.NRGBA); ok && b.Min == (image.Point{}) {
+		return m
+	}
+	m := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(m, m.Bounds(), img, b.Min, draw.Src)
+	return m
+}
@@ -0,0 +1,296 @@
+package formats
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"sort"
+	"strings"
+
+	"golang.org/x/image/tiff"
+)
+
+// TIFF compression methods
+const (
+	TIFFNone    = "none"
+	TIFFDeflate = "deflate"
+	TIFFLZW     = "lzw"
+)
+
+// DefaultTIFFCompression is used when no compression is configured
+const DefaultTIFFCompression = TIFFDeflate
+
+// IsTIFFCompression reports whether name is a supported TIFF compression
+func IsTIFFCompression(name string) bool {
+	switch strings.ToLower(name) {
+	case TIFFNone, TIFFDeflate, TIFFLZW:
+		return true
+	}
+	return false
+}
+
+// encodeTIFF writes a TIFF with the configured compression. x/image/tiff
+// writes uncompressed and deflate images but cannot compress with LZW, so LZW
+// images are written by writeLZWTIFF.
+func encodeTIFF(w io.Writer, img image.Image, opts Options) error {
+	switch strings.ToLower(opts.TIFFCompression) {
+	case TIFFNone:
+		return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Uncompressed})
+	case "", TIFFDeflate:
+		return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate})
+	case TIFFLZW:
+		return writeLZWTIFF(w, ToNRGBA(img))
+	default:
+		return fmt.Errorf("unsupported TIFF compression: %s", opts.TIFFCompression)
+	}
+}
+
+// TIFF tags and field types used by writeLZWTIFF
+const (
+	tagImageWidth                = 256
+	tagImageLength               = 257
+	tagBitsPerSample             = 258
+	tagCompression               = 259
+	tagPhotometricInterpretation = 262
+	tagStripOffsets              = 273
+	tagSamplesPerPixel           = 277
+	tagRowsPerStrip              = 278
+	tagStripByteCounts           = 279
+	tagXResolution               = 282
+	tagYResolution               = 283
+	tagPlanarConfiguration       = 284
+	tagResolutionUnit            = 296
+	tagPredictor                 = 317
+	tagExtraSamples              = 338
+
+	typeShort    = 3
+	typeLong     = 4
+	typeRational = 5
+)
+
+// ifdEntry is a TIFF directory entry
+type ifdEntry struct {
+	tag    uint16
+	typ    uint16
+	values []uint32
+}
+
+// lzwStripSize is the uncompressed size of an LZW strip
+const lzwStripSize = 64 << 10
+
+// writeLZWTIFF writes m as an LZW-compressed RGBA TIFF with unassociated
+// alpha. Rows are horizontally differenced first, which makes screenshots
+// compress far better. The directory that follows the strips is addressed in
+// the header, so the strips are compressed twice, once to measure them and
+// once to write them; that keeps memory bounded without seeking in w.
+func writeLZWTIFF(w io.Writer, m *image.NRGBA) error {
+	width, height := m.Rect.Dx(), m.Rect.Dy()
+	rowsPerStrip := max(1, lzwStripSize/max(4*width, 1))
+	strips := (height + rowsPerStrip - 1) / rowsPerStrip
+
+	row := make([]byte, 4*width)
+	compress := func(w io.ByteWriter, strip int) {
+		lzw := newLZWWriter(w)
+		for y := strip * rowsPerStrip; y < min(height, (strip+1)*rowsPerStrip); y++ {
+			copy(row, m.Pix[y*m.Stride:y*m.Stride+4*width])
+			for x := len(row) - 1; x >= 4; x-- {
+				row[x] -= row[x-4]
+			}
+			lzw.write(row)
+		}
+		lzw.close()
+	}
+
+	const headerSize = 8
+	offsets := make([]uint32, strips)
+	counts := make([]uint32, strips)
+	end := headerSize
+	for i := range counts {
+		var n byteCounter
+		compress(&n, i)
+		offsets[i], counts[i] = uint32(end), uint32(n)
+		end += int(n)
+	}
+
+	entries := []ifdEntry{
+		{tagImageWidth, typeLong, []uint32{uint32(width)}},
+		{tagImageLength, typeLong, []uint32{uint32(height)}},
+		{tagBitsPerSample, typeShort, []uint32{8, 8, 8, 8}},
+		{tagCompression, typeShort, []uint32{5}},
+		{tagPhotometricInterpretation, typeShort, []uint32{2}}, // RGB
+		{tagStripOffsets, typeLong, offsets},
+		{tagSamplesPerPixel, typeShort, []uint32{4}},
+		{tagRowsPerStrip, typeLong, []uint32{uint32(rowsPerStrip)}},
+		{tagStripByteCounts, typeLong, counts},
+		{tagXResolution, typeRational, []uint32{72, 1}},
+		{tagYResolution, typeRational, []uint32{72, 1}},
+		{tagPlanarConfiguration, typeShort, []uint32{1}}, // Chunky
+		{tagResolutionUnit, typeShort, []uint32{2}},      // Inches
+		{tagPredictor, typeShort, []uint32{2}},           // Horizontal differencing
+		{tagExtraSamples, typeShort, []uint32{2}},        // Unassociated alpha
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	// The strips follow the header, padded to a word boundary, then the
+	// directory, then the values too large to fit in their entries
+	ifdOffset := end + end&1
+	extraOffset := ifdOffset + 2 + 12*len(entries) + 4
+
+	bw := bufio.NewWriter(w)
+	le := binary.LittleEndian
+	var b [12]byte
+
+	bw.WriteString("II")
+	le.PutUint16(b[:], 42)
+	le.PutUint32(b[2:], uint32(ifdOffset))
+	bw.Write(b[:6])
+	for i := range counts {
+		compress(bw, i)
+	}
+	if end&1 == 1 {
+		bw.WriteByte(0)
+	}
+
+	le.PutUint16(b[:], uint16(len(entries)))
+	bw.Write(b[:2])
+	var extra []byte
+	for _, e := range entries {
+		var data []byte
+		for _, v := range e.values {
+			if e.typ == typeShort {
+				data = le.AppendUint16(data, uint16(v))
+			} else {
+				data = le.AppendUint32(data, v)
+			}
+		}
+		count := len(e.values)
+		if e.typ == typeRational {
+			count /= 2
+		}
+
+		le.PutUint16(b[0:], e.tag)
+		le.PutUint16(b[2:], e.typ)
+		le.PutUint32(b[4:], uint32(count))
+		if len(data) <= 4 {
+			clear(b[8:])
+			copy(b[8:], data)
+		} else {
+			le.PutUint32(b[8:], uint32(extraOffset+len(extra)))
+			extra = append(extra, data...)
+		}
+		bw.Write(b[:])
+	}
+	le.PutUint32(b[:], 0) // No further directories
+	bw.Write(b[:4])
+	bw.Write(extra)
+
+	return bw.Flush()
+}
+
+// TIFF LZW codes
+const (
+	lzwClear     = 256
+	lzwEOI       = 257
+	lzwFirstCode = 258
+	lzwMaxWidth  = 12
+	lzwTableSize = 4093 // Codes are reset before the decoder's table fills up
+)
+
+// lzwWriter compresses with the TIFF flavor of LZW: codes are written most
+// significant bit first, and the code width grows one code earlier than in
+// GIF or compress/lzw
+type lzwWriter struct {
+	w     io.ByteWriter
+	bits  uint32
+	nBits uint
+
+	table    map[uint32]uint16 // prefix code<<8 | byte -> code
+	next     uint16            // Next code to assign
+	hi       uint16            // Mirror of the decoder's highest code
+	width    uint
+	overflow uint16
+	cur      int // Code of the pending string, -1 if none
+}
+
+func newLZWWriter(w io.ByteWriter) *lzwWriter {
+	z := &lzwWriter{w: w, cur: -1}
+	z.reset()
+	z.emit(lzwClear)
+	return z
+}
+
+// reset empties the string table
+func (z *lzwWriter) reset() {
+	z.table = make(map[uint32]uint16)
+	z.next = lzwFirstCode
+	z.hi = lzwEOI
+	z.width = 9
+	z.overflow = 1 << 9
+}
+
+// emit writes a code with the width the decoder expects next
+func (z *lzwWriter) emit(code uint16) {
+	z.bits |= uint32(code) << (32 - z.width - z.nBits)
+	z.nBits += z.width
+	for z.nBits >= 8 {
+		z.w.WriteByte(byte(z.bits >> 24))
+		z.bits <<= 8
+		z.nBits -= 8
+	}
+
+	if code == lzwClear {
+		return
+	}
+	z.hi++
+	if z.hi+1 >= z.overflow && z.width < lzwMaxWidth {
+		z.width++
+		z.overflow <<= 1
+	}
+}
+
+func (z *lzwWriter) write(p []byte) {
+	for _, c := range p {
+		if z.cur < 0 {
+			z.cur = int(c)
+			continue
+		}
+
+		key := uint32(z.cur)<<8 | uint32(c)
+		if code, ok := z.table[key]; ok {
+			z.cur = int(code)
+			continue
+		}
+
+		z.emit(uint16(z.cur))
+		if z.next < lzwTableSize {
+			z.table[key] = z.next
+			z.next++
+		} else {
+			z.emit(lzwClear)
+			z.reset()
+		}
+		z.cur = int(c)
+	}
+}
+
+// byteCounter counts the bytes written to it
+type byteCounter int
+
+// WriteByte implements io.ByteWriter
+func (n *byteCounter) WriteByte(byte) error {
+	*n++
+	return nil
+}
+
+// close writes the pending string and the end of information code
+func (z *lzwWriter) close() {
+	if z.cur >= 0 {
+		z.emit(uint16(z.cur))
+	}
+	z.emit(lzwEOI)
+	if z.nBits > 0 {
+		z.w.WriteByte(byte(z.bits >> 24))
+	}
+}
@@ -1,41 +1,316 @@
 package output
 
 import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"image"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/funnyzak/screenshot-cli/internal/config"
 )
 
+// SaveResult describes a file written by SaveToFileWithResult
+type SaveResult struct {
+	Path           string
+	Size           int64
+	SHA256         string
+	EncodeDuration time.Duration
+	Skipped        bool     // The file already existed and --if-exists skip kept it
+	Notes          []string // Notes from the encoder, e.g. palette reduction savings
+	Width          int      // Dimensions of the saved image
+	Height         int
+	Quality        int // Quality chosen to fit --max-size, 0 otherwise
+}
+
 // SaveToFile saves an image to a file with the specified configuration
 func SaveToFile(img image.Image, config *config.Config) error {
+	_, err := SaveToFileWithResult(img, config)
+	return err
+}
+
+// SaveToFileWithResult saves an image like SaveToFile and reports what was
+// written. With the output path "-" the image is written to stdout instead.
+func SaveToFileWithResult(img image.Image, config *config.Config) (*SaveResult, error) {
+	if config.WritesToStdout() {
+		return WriteToStdout(img, config)
+	}
+
 	// Create template processor
 	templateProcessor := config.NewTemplateProcessor()
 
+	templateProcessor.SetImageSize(img.Bounds().Dx(), img.Bounds().Dy())
+
 	// Get the actual output path (process template if needed)
-	outputPath := GetOutputPath(config, templateProcessor)
+	outputPath, err := GetOutputPath(config, templateProcessor)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure output directory exists and apply the overwrite policy
+	outputPath, skip, err := PrepareOutputPath(outputPath, config.IfExists)
+	if err != nil {
+		return nil, err
+	}
+	if skip {
+		return &SaveResult{Path: outputPath, Skipped: true}, nil
+	}
+
+	// Stream the encoded image into a temporary file next to the target
+	f, err := CreateAtomic(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write file: %w", err)
+	}
+	defer f.Abort()
+
+	w := fileBuffers.Get().(*bufio.Writer)
+	w.Reset(f)
+	defer func() {
+		w.Reset(nil)
+		fileBuffers.Put(w)
+	}()
+
+	result, err := encodeTo(w, img, config)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := f.Commit(config.Fsync); err != nil {
+		return nil, fmt.Errorf("failed to write file: %w", err)
+	}
+
+	result.Path = outputPath
+	return result, nil
+}
+
+// fileBufferSize is the write buffer between encoders and output files;
+// encoders issue many small writes, which it batches into large ones
+const fileBufferSize = 256 << 10
+
+// fileBuffers recycles write buffers across the screenshots of a batch
+var fileBuffers = sync.Pool{
+	New: func() any { return bufio.NewWriterSize(nil, fileBufferSize) },
+}
+
+// encodeTo encodes img with the configured format and options to w. The
+// result describes the encoded data; its path is left to the caller.
+func encodeTo(w io.Writer, img image.Image, config *config.Config) (*SaveResult, error) {
+	caption := ""
+	if config.PDFCaption {
+		capturedAt := config.CaptureTime
+		if capturedAt.IsZero() {
+			capturedAt = time.Now()
+		}
+		caption = config.Caption(capturedAt)
+	}
 
-	// Ensure output directory exists
-	if err := ensureDirectory(outputPath); err != nil {
-		return fmt.Errorf("failed to create directory: %w", err)
+	var notes []string
+	opts := EncodeOptions{
+		Quality:         config.Quality,
+		Lossless:        config.Lossless,
+		TIFFCompression: config.TIFFCompression,
+		PNGCompression:  config.PNGCompression,
+		PNGPalette:      config.PNGPalette,
+		Colors:          config.Colors,
+		Dither:          config.Dither,
+		PDFCompression:  config.PDFCompression,
+		Caption:         caption,
 	}
+	// Notes can cost extra work, such as a second PNG encoding to measure the
+	// palette savings, so they are only collected for --verbose
+	if config.Verbose {
+		opts.Report = func(note string) {
+			notes = append(notes, note)
+		}
+	}
+
+	hash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(w, hash)}
+	encodeStart := time.Now()
+	result := &SaveResult{Width: img.Bounds().Dx(), Height: img.Bounds().Dy()}
+
+	if config.MaxSize > 0 {
+		// The output has to be measured before it is written
+		fit, err := encodeWithin(img, ImageFormat(config.Format), opts, config.MaxSize, config.Interpolation)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode image: %w", err)
+		}
+		if _, err := counter.Write(fit.data); err != nil {
+			return nil, fmt.Errorf("failed to write image: %w", err)
+		}
+		result.Width, result.Height, result.Quality = fit.width, fit.height, fit.quality
+	} else if err := EncodeImageTo(counter, img, ImageFormat(config.Format), opts); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+
+	result.Size = counter.n
+	result.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	result.EncodeDuration = time.Since(encodeStart)
+	result.Notes = notes
+	return result, nil
+}
 
-	// Encode image to the specified format
-	data, err := EncodeImage(img, ImageFormat(config.Format), config.Quality)
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write implements io.Writer
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// WriteFileAtomic writes data to a temporary file in the target directory and
+// renames it into place, so readers never observe a partially written file and
+// a crash cannot leave a truncated image behind. With fsync set the data is
+// flushed to stable storage before the rename.
+func WriteFileAtomic(path string, data []byte, fsync bool) error {
+	f, err := CreateAtomic(path)
 	if err != nil {
-		return fmt.Errorf("failed to encode image: %w", err)
+		return err
 	}
+	defer f.Abort()
 
-	// Write to file
-	if err := os.WriteFile(outputPath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("failed to write temp file: %w", err)
 	}
+	return f.Commit(fsync)
+}
+
+// AtomicFile is written to a temporary file next to its target and renamed
+// into place by Commit, for outputs that are written incrementally
+type AtomicFile struct {
+	*os.File
+	path     string
+	mode     os.FileMode // Permissions given to the file on Commit
+	finished bool
+}
+
+// newFileMode is the mode of new files, as os.WriteFile(path, data, 0666)
+// would create them under the umask of the process
+var newFileMode = 0666 &^ umask()
+
+// CreateAtomic creates the temporary file for path. If path is a symlink the
+// file it points to is replaced, keeping the link; an existing file keeps its
+// permissions.
+func CreateAtomic(path string) (*AtomicFile, error) {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	mode := newFileMode
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	return &AtomicFile{File: tmpFile, path: path, mode: mode}, nil
+}
+
+// Commit closes the temporary file and renames it to the target path. With
+// fsync set the data is flushed to stable storage first.
+func (f *AtomicFile) Commit(fsync bool) error {
+	if f.finished {
+		return fmt.Errorf("file already closed: %s", f.path)
+	}
+	f.finished = true
+	tmpPath := f.Name()
+
+	// Remove the temp file unless it was renamed into place
+	renamed := false
+	defer func() {
+		if !renamed {
+			f.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if fsync {
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("failed to sync temp file: %w", err)
+		}
+	}
+
+	// CreateTemp uses 0600 regardless of the umask
+	if err := f.Chmod(f.mode); err != nil {
+		return fmt.Errorf("failed to set file permissions: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, f.path); err != nil {
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+	renamed = true
 
 	return nil
 }
 
+// Abort removes the temporary file unless it was committed
+func (f *AtomicFile) Abort() {
+	if f.finished {
+		return
+	}
+	f.finished = true
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// PrepareOutputPath creates the directory of path and applies the --if-exists
+// policy to it. It returns the path to write to and whether writing should be
+// skipped altogether.
+func PrepareOutputPath(path, policy string) (string, bool, error) {
+	if err := ensureDirectory(path); err != nil {
+		return "", false, fmt.Errorf("failed to create directory: %w", err)
+	}
+	return resolveExisting(path, policy)
+}
+
+// resolveExisting applies the --if-exists policy to path. It returns the path
+// to write to and whether writing should be skipped altogether.
+func resolveExisting(path, policy string) (string, bool, error) {
+	if policy == "" || policy == config.IfExistsOverwrite {
+		return path, false, nil
+	}
+
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return path, false, nil
+	}
+
+	switch policy {
+	case config.IfExistsSkip:
+		return path, true, nil
+	case config.IfExistsError:
+		return "", false, fmt.Errorf("file already exists: %s", path)
+	case config.IfExistsSuffix:
+		ext := filepath.Ext(path)
+		base := strings.TrimSuffix(path, ext)
+		for n := 1; ; n++ {
+			candidate := fmt.Sprintf("%s-%d%s", base, n, ext)
+			if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+				return candidate, false, nil
+			}
+		}
+	default:
+		return "", false, fmt.Errorf("unsupported if-exists policy: %s", policy)
+	}
+}
+
 // ensureDirectory ensures the directory for the output file exists
 func ensureDirectory(filePath string) error {
 	dir := filepath.Dir(filePath)
@@ -51,18 +326,18 @@ func ensureDirectory(filePath string) error {
 }
 
 // GetOutputPath generates the output path based on configuration and template
-func GetOutputPath(config *config.Config, templateProcessor *config.TemplateProcessor) string {
+func GetOutputPath(config *config.Config, templateProcessor *config.TemplateProcessor) (string, error) {
 	if config.Template != "" {
 		return templateProcessor.ProcessTemplate(config.Template, config)
 	}
 
 	// If no template, use the configured output path
 	if config.OutputPath != "" {
-		return config.OutputPath
+		return config.OutputPath, nil
 	}
 
 	// Default fallback
-	return "screenshot.png"
+	return "screenshot.png", nil
 }
 
 // ValidateOutputPath checks if the output path is valid and writable
@@ -90,3 +365,60 @@ func ValidateOutputPath(outputPath string) error {
 
 	return nil
 }
+
+// ValidatePathChars checks that a path only contains characters and names
+// that are legal on the target operating system (a GOOS value)
+func ValidatePathChars(path, goos string) error {
+	if strings.ContainsRune(path, 0) {
+		return fmt.Errorf("path contains a NUL character")
+	}
+
+	switch goos {
+	case "windows":
+		// Allow a drive letter such as C: at the start
+		if len(path) >= 2 && path[1] == ':' && isDriveLetter(path[0]) {
+			path = path[2:]
+		}
+
+		for _, component := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '\\' }) {
+			if component == "." || component == ".." {
+				continue
+			}
+			for _, r := range component {
+				if r < 32 || strings.ContainsRune(`<>:"|?*`, r) {
+					return fmt.Errorf("%q contains %q, which is not allowed on Windows", component, r)
+				}
+			}
+			if strings.HasSuffix(component, ".") || strings.HasSuffix(component, " ") {
+				return fmt.Errorf("%q ends with a dot or space, which Windows strips", component)
+			}
+			name := strings.ToUpper(strings.TrimSpace(strings.SplitN(component, ".", 2)[0]))
+			if isReservedWindowsName(name) {
+				return fmt.Errorf("%q is a reserved device name on Windows", component)
+			}
+		}
+	case "darwin":
+		if strings.ContainsRune(path, ':') {
+			return fmt.Errorf("path contains ':', which Finder displays as '/' on macOS")
+		}
+	}
+
+	return nil
+}
+
+// isDriveLetter reports whether c is an ASCII letter
+func isDriveLetter(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// isReservedWindowsName reports whether name is a DOS device name
+func isReservedWindowsName(name string) bool {
+	switch name {
+	case "CON", "PRN", "AUX", "NUL":
+		return true
+	}
+	if len(name) == 4 && (strings.HasPrefix(name, "COM") || strings.HasPrefix(name, "LPT")) {
+		return name[3] >= '1' && name[3] <= '9'
+	}
+	return false
+}
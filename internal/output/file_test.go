@@ -0,0 +1,386 @@
+package output
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	_ "image/jpeg"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/funnyzak/screenshot-cli/internal/config"
+)
+
+func TestResolveExisting(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "shot.png")
+	for _, name := range []string{"shot.png", "shot-1.png"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		policy   string
+		wantPath string
+		wantSkip bool
+		wantErr  bool
+	}{
+		{"overwrite", existing, config.IfExistsOverwrite, existing, false, false},
+		{"skip", existing, config.IfExistsSkip, existing, true, false},
+		{"error", existing, config.IfExistsError, "", false, true},
+		{"suffix", existing, config.IfExistsSuffix, filepath.Join(dir, "shot-2.png"), false, false},
+		{"new file", filepath.Join(dir, "new.png"), config.IfExistsError, filepath.Join(dir, "new.png"), false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, skip, err := resolveExisting(tt.path, tt.policy)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveExisting() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if path != tt.wantPath || skip != tt.wantSkip {
+				t.Errorf("resolveExisting() = (%q, %v), want (%q, %v)", path, skip, tt.wantPath, tt.wantSkip)
+			}
+		})
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shot.png")
+
+	for _, data := range []string{"first", "second"} {
+		if err := WriteFileAtomic(path, []byte(data), true); err != nil {
+			t.Fatalf("WriteFileAtomic() error = %v", err)
+		}
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "second" {
+		t.Errorf("file content = %q, want second", got)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory contains %d entries, want only the target file", len(entries))
+	}
+}
+
+func TestValidatePathChars(t *testing.T) {
+	tests := []struct {
+		path    string
+		goos    string
+		wantErr bool
+	}{
+		{"shots/2024-01-02/shot_001.png", "windows", false},
+		{`C:\shots\shot.png`, "windows", false},
+		{"shots/12:30:00.png", "windows", true},
+		{"shots/what?.png", "windows", true},
+		{"shots/con.png", "windows", true},
+		{"shots/COM1", "windows", true},
+		{"shots/console.png", "windows", false},
+		{"shots./shot.png", "windows", true},
+		{"shots/12:30:00.png", "darwin", true},
+		{"shots/12:30:00.png", "linux", false},
+		{"shots/what?.png", "linux", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.goos+"/"+tt.path, func(t *testing.T) {
+			err := ValidatePathChars(tt.path, tt.goos)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidatePathChars(%q, %q) error = %v, wantErr %v", tt.path, tt.goos, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWriteEncoded(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	var raw bytes.Buffer
+	if err := png.Encode(&raw, img); err != nil {
+		t.Fatal(err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(raw.Bytes())
+
+	tests := []struct {
+		name     string
+		encoding string
+		terminal bool
+		force    bool
+		want     string
+		wantErr  bool
+	}{
+		{"raw", "", false, false, raw.String(), false},
+		{"base64", config.EncodingBase64, false, false, encoded + "\n", false},
+		{"data url", config.EncodingDataURL, false, false, "data:image/png;base64," + encoded + "\n", false},
+		{"raw to terminal", "", true, false, "", true},
+		{"forced raw to terminal", "", true, true, raw.String(), false},
+		{"base64 to terminal", config.EncodingBase64, true, false, encoded + "\n", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{OutputPath: config.StdoutPath, Format: "png", Encoding: tt.encoding, ForceStdout: tt.force}
+			var out bytes.Buffer
+			result, err := writeEncoded(&out, tt.terminal, img, cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("writeEncoded() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if out.String() != tt.want {
+				t.Errorf("writeEncoded() wrote %d bytes, want %d", out.Len(), len(tt.want))
+			}
+			if err == nil && (result.Path != config.StdoutPath || result.Size != int64(raw.Len())) {
+				t.Errorf("writeEncoded() result = %+v", result)
+			}
+		})
+	}
+}
+
+// benchmarkFrame returns a frame resembling a desktop capture: flat areas,
+// gradients and fine detail
+func benchmarkFrame(width, height int) *image.RGBA {
+	m := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := m.PixOffset(x, y)
+			switch {
+			case y < height/10:
+				m.Pix[i], m.Pix[i+1], m.Pix[i+2] = 240, 240, 240
+			case (x/8+y/16)%7 == 0:
+				m.Pix[i], m.Pix[i+1], m.Pix[i+2] = byte(x*y), byte(x^y), byte(x+y)
+			default:
+				m.Pix[i], m.Pix[i+1], m.Pix[i+2] = byte(x*255/width), byte(y*255/height), 128
+			}
+			m.Pix[i+3] = 255
+		}
+	}
+	return m
+}
+
+// BenchmarkSaveToFile compares encoding into memory and writing the result,
+// as files were written before, with streaming into the temporary file.
+// B/op shows the buffered copy of the encoded image that streaming avoids.
+func BenchmarkSaveToFile(b *testing.B) {
+	sizes := []struct {
+		name          string
+		width, height int
+	}{
+		{"4K", 3840, 2160},
+		{"8K", 7680, 4320},
+	}
+	for _, size := range sizes {
+		img := benchmarkFrame(size.width, size.height)
+		for _, format := range []string{"png", "jpg"} {
+			cfg := &config.Config{
+				OutputPath:     filepath.Join(b.TempDir(), "shot."+format),
+				Format:         format,
+				Quality:        90,
+				PNGCompression: "fast",
+			}
+
+			b.Run(size.name+"/"+format+"/buffered", func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					data, err := EncodeImage(img, ImageFormat(cfg.Format), EncodeOptions{Quality: cfg.Quality, PNGCompression: cfg.PNGCompression})
+					if err != nil {
+						b.Fatal(err)
+					}
+					if err := WriteFileAtomic(cfg.OutputPath, data, false); err != nil {
+						b.Fatal(err)
+					}
+					b.SetBytes(int64(len(data)))
+				}
+			})
+
+			b.Run(size.name+"/"+format+"/streamed", func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					result, err := SaveToFileWithResult(img, cfg)
+					if err != nil {
+						b.Fatal(err)
+					}
+					b.SetBytes(result.Size)
+				}
+			})
+		}
+	}
+}
+
+func TestSaveThumbnail(t *testing.T) {
+	dir := t.TempDir()
+	screenshot := filepath.Join(dir, "shot_001.png")
+	img := image.NewRGBA(image.Rect(0, 0, 128, 64))
+
+	tests := []struct {
+		name     string
+		template string
+		format   string
+		wantPath string
+	}{
+		{"default", config.DefaultThumbnailTemplate, "jpg", "shot_001.thumb.jpg"},
+		{"subdirectory with size", "thumbs/{name}_{width}x{height}", "png", filepath.Join("thumbs", "shot_001_32x16.png")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Format:            "png",
+				ThumbnailWidth:    32,
+				ThumbnailTemplate: tt.template,
+				ThumbnailFormat:   tt.format,
+				ThumbnailQuality:  80,
+			}
+			result, err := SaveThumbnail(img, screenshot, cfg, config.NewTemplateProcessor())
+			if err != nil {
+				t.Fatalf("SaveThumbnail() error = %v", err)
+			}
+			if want := filepath.Join(dir, tt.wantPath); result.Path != want {
+				t.Errorf("SaveThumbnail() path = %q, want %q", result.Path, want)
+			}
+
+			f, err := os.Open(result.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			thumb, format, err := image.Decode(f)
+			if err != nil {
+				t.Fatalf("image.Decode() error = %v", err)
+			}
+			if format != map[string]string{"jpg": "jpeg", "png": "png"}[tt.format] || thumb.Bounds().Dx() != 32 || thumb.Bounds().Dy() != 16 {
+				t.Errorf("thumbnail is a %dx%d %s", thumb.Bounds().Dx(), thumb.Bounds().Dy(), format)
+			}
+		})
+	}
+}
+
+func TestEncodeWithin(t *testing.T) {
+	// Noise keeps the encoded size sensitive to both quality and dimensions
+	img := image.NewRGBA(image.Rect(0, 0, 256, 160))
+	rng := rand.New(rand.NewSource(1))
+	rng.Read(img.Pix)
+
+	full, err := EncodeImage(img, "jpg", EncodeOptions{Quality: 95})
+	if err != nil {
+		t.Fatal(err)
+	}
+	low, err := EncodeImage(img, "jpg", EncodeOptions{Quality: minFitQuality})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		format        ImageFormat
+		maxSize       int64
+		wantQuality   func(int) bool
+		wantDownscale bool
+		wantErr       bool
+	}{
+		{"fits at full quality", "jpg", int64(len(full)), func(q int) bool { return q == 95 }, false, false},
+		{"lower quality", "jpg", int64(len(low)+len(full)) / 2, func(q int) bool { return q > minFitQuality && q < 95 }, false, false},
+		{"downscaled", "jpg", int64(len(low)) / 3, func(q int) bool { return q >= minFitQuality }, true, false},
+		{"png only downscales", "png", int64(len(full)) / 4, func(q int) bool { return q == 0 }, true, false},
+		{"impossible", "jpg", 100, nil, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fit, err := encodeWithin(img, tt.format, EncodeOptions{Quality: 95}, tt.maxSize, "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("encodeWithin() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if int64(len(fit.data)) > tt.maxSize {
+				t.Errorf("encodeWithin() = %d bytes, want at most %d", len(fit.data), tt.maxSize)
+			}
+			if !tt.wantQuality(fit.quality) {
+				t.Errorf("encodeWithin() quality = %d", fit.quality)
+			}
+			if downscaled := fit.width < 256; downscaled != tt.wantDownscale {
+				t.Errorf("encodeWithin() size = %dx%d, downscaled = %v, want %v", fit.width, fit.height, downscaled, tt.wantDownscale)
+			}
+		})
+	}
+}
+
+func TestSaveNotesOnlyWhenVerbose(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for _, verbose := range []bool{false, true} {
+		cfg := &config.Config{
+			OutputPath: filepath.Join(t.TempDir(), "shot.png"),
+			Format:     "png",
+			PNGPalette: true,
+			Verbose:    verbose,
+		}
+		result, err := SaveToFileWithResult(img, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(result.Notes) > 0; got != verbose {
+			t.Errorf("verbose = %v: notes = %q", verbose, result.Notes)
+		}
+	}
+}
+
+func TestSaveThumbnailOwnOptions(t *testing.T) {
+	dir := t.TempDir()
+	screenshot := filepath.Join(dir, "shot_001.png")
+	img := image.NewRGBA(image.Rect(0, 0, 128, 64))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+
+	tests := []struct {
+		format string
+		chunk  string // WebP image chunk, if any
+	}{
+		{"jpg", ""},
+		{"webp", "VP8 "}, // Lossy, despite --lossless for the screenshot
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			cfg := &config.Config{
+				Format:            "webp",
+				Lossless:          true,
+				MaxSize:           100,
+				PNGPalette:        true,
+				ThumbnailWidth:    32,
+				ThumbnailTemplate: "{name}.thumb",
+				ThumbnailFormat:   tt.format,
+				ThumbnailQuality:  80,
+			}
+			result, err := SaveThumbnail(img, screenshot, cfg, config.NewTemplateProcessor())
+			if err != nil {
+				t.Fatalf("SaveThumbnail() error = %v", err)
+			}
+			// --max-size would have forced a downscale or a lower quality
+			if result.Width != 32 || result.Height != 16 || result.Quality != 0 || result.Size <= cfg.MaxSize {
+				t.Errorf("thumbnail = %dx%d, quality %d, %d bytes; want 32x16 as encoded", result.Width, result.Height, result.Quality, result.Size)
+			}
+			if tt.chunk != "" {
+				data, err := os.ReadFile(result.Path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Contains(data, []byte(tt.chunk)) || bytes.Contains(data, []byte("VP8L")) {
+					t.Errorf("thumbnail has no %q chunk", tt.chunk)
+				}
+			}
+		})
+	}
+}
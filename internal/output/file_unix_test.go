@@ -0,0 +1,68 @@
+//go:build unix
+
+package output
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestWriteFileAtomicMode(t *testing.T) {
+	// Files created under a restrictive umask stay private
+	mask := syscall.Umask(0077)
+	defer syscall.Umask(mask)
+	defer func(mode os.FileMode) { newFileMode = mode }(newFileMode)
+	newFileMode = 0666 &^ umask()
+
+	dir := t.TempDir()
+	newPath := filepath.Join(dir, "new.png")
+	existing := filepath.Join(dir, "existing.png")
+	target := filepath.Join(dir, "target.png")
+	link := filepath.Join(dir, "link.png")
+	if err := os.WriteFile(existing, []byte("old"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(existing, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(target, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("target.png", link); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{newPath, existing, link} {
+		if err := WriteFileAtomic(path, []byte("new"), false); err != nil {
+			t.Fatalf("WriteFileAtomic(%s) error = %v", path, err)
+		}
+	}
+
+	tests := []struct {
+		path string
+		want os.FileMode
+	}{
+		{newPath, 0600},
+		{existing, 0640},
+		{target, 0600},
+	}
+	for _, tt := range tests {
+		info, err := os.Stat(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != tt.want {
+			t.Errorf("%s mode = %v, want %v", filepath.Base(tt.path), info.Mode().Perm(), tt.want)
+		}
+	}
+
+	// The symlink is kept and the file it points to is replaced
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("link.png is no longer a symlink: %v", err)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "new" {
+		t.Errorf("target.png = %q, want new", data)
+	}
+}
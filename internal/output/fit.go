@@ -0,0 +1,109 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/funnyzak/screenshot-cli/internal/formats"
+	"github.com/funnyzak/screenshot-cli/internal/resize"
+)
+
+// minFitQuality is the lowest quality tried before an image is downscaled to
+// fit; below it text in screenshots becomes hard to read
+const minFitQuality = 40
+
+// minFitDimension is the smallest width or height an image is downscaled to
+const minFitDimension = 16
+
+// fitResult is an encoding that fits within a size limit
+type fitResult struct {
+	data    []byte
+	quality int // Chosen quality, 0 for formats without one
+	width   int
+	height  int
+}
+
+// encodeWithin encodes img so the output is at most maxSize bytes. Formats
+// with a lossy quality are tried at the highest quality up to opts.Quality
+// that fits, down to minFitQuality; if even that is too large, the image is
+// downscaled in proportion to the excess and the search starts again.
+func encodeWithin(img image.Image, format ImageFormat, opts EncodeOptions, maxSize int64, interpolation string) (*fitResult, error) {
+	f, ok := formats.Lookup(string(format))
+	if !ok {
+		return nil, fmt.Errorf("unsupported format: %s", format)
+	}
+	// Flate-compressed PDF pages ignore the quality
+	lossy := f.Quality == formats.QualityLossy && !opts.Lossless && opts.PDFCompression != formats.PDFFlate
+	maxQuality := opts.Quality
+	minQuality := min(minFitQuality, maxQuality)
+
+	// Trial encodings share one buffer; only a fitting one is kept
+	var buf bytes.Buffer
+	encode := func(m image.Image, quality int) (int64, error) {
+		buf.Reset()
+		trial := opts
+		trial.Quality = quality
+		trial.Report = nil
+		if err := EncodeImageTo(&buf, m, format, trial); err != nil {
+			return 0, err
+		}
+		return int64(buf.Len()), nil
+	}
+	result := func(m image.Image, quality int, data []byte) *fitResult {
+		fit := &fitResult{data: data, width: m.Bounds().Dx(), height: m.Bounds().Dy()}
+		if lossy {
+			fit.quality = quality
+		}
+		return fit
+	}
+
+	b := img.Bounds()
+	for scale := 1.0; ; {
+		m := img
+		if scale < 1 {
+			m = resize.Apply(img, resize.Options{Scale: scale, Interpolation: interpolation})
+		}
+
+		size, err := encode(m, maxQuality)
+		if err != nil {
+			return nil, err
+		}
+		if size <= maxSize {
+			return result(m, maxQuality, buf.Bytes()), nil
+		}
+
+		if lossy && minQuality < maxQuality {
+			if size, err = encode(m, minQuality); err != nil {
+				return nil, err
+			}
+			if size <= maxSize {
+				// The size grows with the quality, so bisect between a
+				// fitting and a too large quality
+				best := bytes.Clone(buf.Bytes())
+				lo, hi := minQuality, maxQuality
+				for hi-lo > 1 {
+					mid := (lo + hi) / 2
+					size, err := encode(m, mid)
+					if err != nil {
+						return nil, err
+					}
+					if size <= maxSize {
+						lo = mid
+						best = append(best[:0], buf.Bytes()...)
+					} else {
+						hi = mid
+					}
+				}
+				return result(m, lo, best), nil
+			}
+		}
+
+		// The size roughly follows the pixel count; shrink by at least 10%
+		scale *= min(0.9, 0.95*math.Sqrt(float64(maxSize)/float64(size)))
+		if float64(b.Dx())*scale < minFitDimension || float64(b.Dy())*scale < minFitDimension {
+			return nil, fmt.Errorf("image does not fit within %d bytes, even at %dx%d", maxSize, m.Bounds().Dx(), m.Bounds().Dy())
+		}
+	}
+}
@@ -4,12 +4,10 @@ import (
 	"bytes"
 	"fmt"
 	"image"
-	"image/gif"
-	"image/jpeg"
-	"image/png"
+	"io"
 	"strings"
 
-	"golang.org/x/image/bmp"
+	"github.com/funnyzak/screenshot-cli/internal/formats"
 )
 
 // ImageFormat represents supported image formats
@@ -21,67 +19,51 @@ const (
 	FormatJPEG ImageFormat = "jpeg"
 	FormatBMP  ImageFormat = "bmp"
 	FormatGIF  ImageFormat = "gif"
+	FormatWEBP ImageFormat = "webp"
+	FormatTIFF ImageFormat = "tiff"
+	FormatPPM  ImageFormat = "ppm"
+	FormatPAM  ImageFormat = "pam"
+	FormatQOI  ImageFormat = "qoi"
+	FormatPDF  ImageFormat = "pdf"
 )
 
-// EncodeImage encodes an image to the specified format with quality settings
-func EncodeImage(img image.Image, format ImageFormat, quality int) ([]byte, error) {
-	var buf bytes.Buffer
-
-	switch strings.ToLower(string(format)) {
-	case string(FormatPNG):
-		if err := png.Encode(&buf, img); err != nil {
-			return nil, fmt.Errorf("failed to encode PNG: %w", err)
-		}
-
-	case string(FormatJPG), string(FormatJPEG):
-		opts := jpeg.Options{Quality: quality}
-		if err := jpeg.Encode(&buf, img, &opts); err != nil {
-			return nil, fmt.Errorf("failed to encode JPEG: %w", err)
-		}
+// EncodeOptions are the format-specific encoder settings
+type EncodeOptions = formats.Options
 
-	case string(FormatBMP):
-		if err := bmp.Encode(&buf, img); err != nil {
-			return nil, fmt.Errorf("failed to encode BMP: %w", err)
-		}
-
-	case string(FormatGIF):
-		if err := gif.Encode(&buf, img, nil); err != nil {
-			return nil, fmt.Errorf("failed to encode GIF: %w", err)
-		}
+// EncodeImage encodes an image to the specified format with the given options
+func EncodeImage(img image.Image, format ImageFormat, opts EncodeOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := EncodeImageTo(&buf, img, format, opts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
 
-	default:
-		return nil, fmt.Errorf("unsupported format: %s", format)
+// EncodeImageTo encodes an image like EncodeImage, streaming it to w
+func EncodeImageTo(w io.Writer, img image.Image, format ImageFormat, opts EncodeOptions) error {
+	f, ok := formats.Lookup(string(format))
+	if !ok {
+		return fmt.Errorf("unsupported format: %s", format)
 	}
 
-	return buf.Bytes(), nil
+	if err := f.Encode(w, img, opts); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", strings.ToUpper(f.Name), err)
+	}
+	return nil
 }
 
 // GetFileExtension returns the file extension for a given format
 func GetFileExtension(format ImageFormat) string {
-	switch strings.ToLower(string(format)) {
-	case string(FormatPNG):
-		return ".png"
-	case string(FormatJPG), string(FormatJPEG):
-		return ".jpg"
-	case string(FormatBMP):
-		return ".bmp"
-	case string(FormatGIF):
-		return ".gif"
-	default:
-		return ".png"
+	if f, ok := formats.Lookup(string(format)); ok {
+		return f.Extension()
 	}
+	return ".png"
 }
 
 // IsFormatSupported checks if a format is supported
 func IsFormatSupported(format string) bool {
-	supportedFormats := map[string]bool{
-		"png":  true,
-		"jpg":  true,
-		"jpeg": true,
-		"bmp":  true,
-		"gif":  true,
-	}
-	return supportedFormats[strings.ToLower(format)]
+	_, ok := formats.Lookup(format)
+	return ok
 }
 
 // GetImageInfo returns basic information about an image
@@ -0,0 +1,63 @@
+package output
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"io"
+	"os"
+
+	"github.com/funnyzak/screenshot-cli/internal/config"
+	"github.com/funnyzak/screenshot-cli/internal/formats"
+)
+
+// WriteToStdout encodes an image with the configured format and writes it to
+// stdout, raw or as base64 text. Raw image data is not written to a terminal
+// unless forced.
+func WriteToStdout(img image.Image, config *config.Config) (*SaveResult, error) {
+	return writeEncoded(os.Stdout, isTerminal(os.Stdout), img, config)
+}
+
+// writeEncoded writes img to w, which is a terminal if terminal is set
+func writeEncoded(w io.Writer, terminal bool, img image.Image, cfg *config.Config) (*SaveResult, error) {
+	if terminal && cfg.Encoding == "" && !cfg.ForceStdout {
+		return nil, fmt.Errorf("refusing to write binary image data to a terminal; redirect stdout, use --base64, or use --force-stdout")
+	}
+
+	bw := bufio.NewWriter(w)
+	var dst io.Writer = bw
+	var encoder io.WriteCloser
+	if cfg.Encoding == config.EncodingDataURL {
+		mime := "application/octet-stream"
+		if f, ok := formats.Lookup(cfg.Format); ok {
+			mime = f.MIME
+		}
+		bw.WriteString("data:" + mime + ";base64,")
+	}
+	if cfg.Encoding != "" {
+		encoder = base64.NewEncoder(base64.StdEncoding, bw)
+		dst = encoder
+	}
+
+	result, err := encodeTo(dst, img, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if encoder != nil {
+		encoder.Close()
+		bw.WriteByte('\n')
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write to stdout: %w", err)
+	}
+
+	result.Path = config.StdoutPath
+	return result, nil
+}
+
+// isTerminal reports whether f is a character device such as a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
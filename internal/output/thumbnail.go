@@ -0,0 +1,42 @@
+package output
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/funnyzak/screenshot-cli/internal/config"
+	"github.com/funnyzak/screenshot-cli/internal/resize"
+)
+
+// SaveThumbnail writes a thumbnail of img next to the screenshot saved at
+// screenshotPath. The thumbnail is saved like a screenshot, with its own
+// template, format and quality; templateProcessor supplies the counter and
+// capture time of the screenshot.
+func SaveThumbnail(img image.Image, screenshotPath string, cfg *config.Config, templateProcessor *config.TemplateProcessor) (*SaveResult, error) {
+	thumb := resize.Apply(img, cfg.ThumbnailResize())
+	width, height, _ := GetImageInfo(thumb)
+
+	path, err := templateProcessor.ThumbnailPath(screenshotPath, width, height, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve thumbnail path: %w", err)
+	}
+	if path == screenshotPath {
+		return nil, fmt.Errorf("thumbnail would overwrite its screenshot: %s", path)
+	}
+
+	// Only encoder settings that suit any image carry over; options such as
+	// --max-size, --lossless or --png-palette apply to the screenshot alone
+	thumbConfig := &config.Config{
+		OutputPath:      path,
+		Format:          cfg.ThumbnailFormat,
+		Quality:         cfg.ThumbnailQuality,
+		TIFFCompression: cfg.TIFFCompression,
+		PNGCompression:  cfg.PNGCompression,
+		Colors:          cfg.Colors,
+		Dither:          cfg.Dither,
+		PDFCompression:  cfg.PDFCompression,
+		IfExists:        cfg.IfExists,
+		Fsync:           cfg.Fsync,
+	}
+	return SaveToFileWithResult(thumb, thumbConfig)
+}
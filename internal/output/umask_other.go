@@ -0,0 +1,10 @@
+//go:build !unix
+
+package output
+
+import "os"
+
+// umask returns the file mode creation mask, which only exists on Unix
+func umask() os.FileMode {
+	return 0
+}
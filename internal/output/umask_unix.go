@@ -0,0 +1,16 @@
+//go:build unix
+
+package output
+
+import (
+	"os"
+	"syscall"
+)
+
+// umask returns the file mode creation mask of the process. It can only be
+// read by setting it, so it is read once, before any files are written.
+func umask() os.FileMode {
+	mask := syscall.Umask(0)
+	syscall.Umask(mask)
+	return os.FileMode(mask)
+}
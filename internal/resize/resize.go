@@ -0,0 +1,90 @@
+// Package resize scales captures before they are encoded
+package resize
+
+import (
+	"image"
+	"math"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// Interpolation methods
+const (
+	Nearest    = "nearest"
+	Bilinear   = "bilinear"
+	CatmullRom = "catmull-rom"
+)
+
+// DefaultInterpolation is used when no interpolation is configured
+const DefaultInterpolation = CatmullRom
+
+// IsInterpolation reports whether name is a supported interpolation method
+func IsInterpolation(name string) bool {
+	switch strings.ToLower(name) {
+	case Nearest, Bilinear, CatmullRom:
+		return true
+	}
+	return false
+}
+
+// Options describe how captures are resized. The scale factor is applied
+// first; images still larger than MaxWidth or MaxHeight are then shrunk to
+// fit, keeping their aspect ratio. Zero values leave an image unchanged.
+type Options struct {
+	Scale         float64 // Scale factor, 0 or 1 for none
+	MaxWidth      int     // Maximum width in pixels, 0 for no limit
+	MaxHeight     int     // Maximum height in pixels, 0 for no limit
+	Interpolation string  // nearest, bilinear or catmull-rom
+}
+
+// Size returns the dimensions of a width x height image after resizing
+func (o Options) Size(width, height int) (int, int) {
+	if width <= 0 || height <= 0 {
+		return width, height
+	}
+
+	factor := 1.0
+	if o.Scale > 0 {
+		factor = o.Scale
+	}
+	if o.MaxWidth > 0 && float64(width)*factor > float64(o.MaxWidth) {
+		factor = float64(o.MaxWidth) / float64(width)
+	}
+	if o.MaxHeight > 0 && float64(height)*factor > float64(o.MaxHeight) {
+		factor = float64(o.MaxHeight) / float64(height)
+	}
+	if factor == 1 {
+		return width, height
+	}
+
+	w := max(1, int(math.Round(float64(width)*factor)))
+	h := max(1, int(math.Round(float64(height)*factor)))
+	return w, h
+}
+
+// Apply returns img resized as configured, or img itself if its size does
+// not change
+func Apply(img image.Image, o Options) image.Image {
+	b := img.Bounds()
+	width, height := o.Size(b.Dx(), b.Dy())
+	if width == b.Dx() && height == b.Dy() {
+		return img
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	o.interpolator().Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// interpolator returns the scaler of the configured interpolation method
+func (o Options) interpolator() draw.Interpolator {
+	switch strings.ToLower(o.Interpolation) {
+	case Nearest:
+		return draw.NearestNeighbor
+	case Bilinear:
+		return draw.BiLinear
+	default:
+		return draw.CatmullRom
+	}
+}
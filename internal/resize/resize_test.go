@@ -0,0 +1,59 @@
+package resize
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestSize(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          Options
+		width, height int
+		wantW, wantH  int
+	}{
+		{"unchanged", Options{}, 5120, 2880, 5120, 2880},
+		{"scale", Options{Scale: 0.5}, 5120, 2880, 2560, 1440},
+		{"rounded", Options{Scale: 0.3}, 1001, 1001, 300, 300},
+		{"max width", Options{MaxWidth: 1920}, 5120, 2880, 1920, 1080},
+		{"max height", Options{MaxHeight: 1080}, 2880, 5120, 608, 1080},
+		{"fit", Options{MaxWidth: 1920, MaxHeight: 1080}, 2560, 1600, 1728, 1080},
+		{"within limits", Options{MaxWidth: 1920, MaxHeight: 1080}, 800, 600, 800, 600},
+		{"scale then limit", Options{Scale: 2, MaxWidth: 1000}, 800, 600, 1000, 750},
+		{"at least one pixel", Options{Scale: 0.01}, 50, 20, 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, h := tt.opts.Size(tt.width, tt.height)
+			if w != tt.wantW || h != tt.wantH {
+				t.Errorf("Size(%d, %d) = %dx%d, want %dx%d", tt.width, tt.height, w, h, tt.wantW, tt.wantH)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	src := image.NewRGBA(image.Rect(10, 10, 110, 60))
+	for y := src.Rect.Min.Y; y < src.Rect.Max.Y; y++ {
+		for x := src.Rect.Min.X; x < src.Rect.Max.X; x++ {
+			src.Set(x, y, color.RGBA{R: 200, G: 100, B: 50, A: 255})
+		}
+	}
+
+	if got := Apply(src, Options{Scale: 1}); got != image.Image(src) {
+		t.Errorf("Apply() copied an image that keeps its size")
+	}
+
+	for _, interpolation := range []string{Nearest, Bilinear, CatmullRom} {
+		got := Apply(src, Options{Scale: 0.5, Interpolation: interpolation})
+		if got.Bounds() != image.Rect(0, 0, 50, 25) {
+			t.Fatalf("%s: bounds = %v, want 50x25", interpolation, got.Bounds())
+		}
+		// A flat image stays flat whatever the filter
+		if c := color.RGBAModel.Convert(got.At(25, 12)).(color.RGBA); c != (color.RGBA{R: 200, G: 100, B: 50, A: 255}) {
+			t.Errorf("%s: pixel = %v", interpolation, c)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package webp
+
+// boolEncoder is the boolean entropy encoder of VP8, RFC 6386 section 7.3
+type boolEncoder struct {
+	buf      []byte
+	rng      uint32 // Always between 128 and 255 between calls
+	bottom   uint32
+	bitCount int // Shifts left before a byte is complete
+}
+
+func newBoolEncoder() *boolEncoder {
+	return &boolEncoder{rng: 255, bitCount: 24}
+}
+
+// putBit writes bit, which is false with probability prob/256
+func (e *boolEncoder) putBit(bit bool, prob uint8) bool {
+	split := 1 + ((e.rng - 1) * uint32(prob) >> 8)
+	if bit {
+		e.bottom += split
+		e.rng -= split
+	} else {
+		e.rng = split
+	}
+
+	for e.rng < 128 {
+		e.rng <<= 1
+		if e.bottom&(1<<31) != 0 {
+			e.carry()
+		}
+		e.bottom <<= 1
+		e.bitCount--
+		if e.bitCount == 0 {
+			e.buf = append(e.buf, byte(e.bottom>>24))
+			e.bottom &= 1<<24 - 1
+			e.bitCount = 8
+		}
+	}
+
+	return bit
+}
+
+// putLiteral writes the n low bits of v, most significant first, at even odds
+func (e *boolEncoder) putLiteral(v uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		e.putBit(v>>i&1 == 1, 128)
+	}
+}
+
+// putFlag writes a single bit at even odds
+func (e *boolEncoder) putFlag(bit bool) {
+	e.putBit(bit, 128)
+}
+
+// carry propagates a carry into the bytes already written
+func (e *boolEncoder) carry() {
+	i := len(e.buf) - 1
+	for ; i >= 0 && e.buf[i] == 0xff; i-- {
+		e.buf[i] = 0
+	}
+	if i >= 0 {
+		e.buf[i]++
+	}
+}
+
+// bytes flushes the encoder and returns the written data
+func (e *boolEncoder) bytes() []byte {
+	c := e.bitCount
+	v := e.bottom
+	if v&(1<<(32-c)) != 0 {
+		e.carry()
+	}
+	v <<= c & 7
+	for c >>= 3; c > 0; c-- {
+		v <<= 8
+	}
+	for i := 0; i < 4; i++ {
+		e.buf = append(e.buf, byte(v>>24))
+		v <<= 8
+	}
+	return e.buf
+}
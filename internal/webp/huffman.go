@@ -0,0 +1,327 @@
+package webp
+
+import (
+	"sort"
+)
+
+// bitWriter writes the least significant bits first, as used by VP8L
+type bitWriter struct {
+	buf  []byte
+	acc  uint64
+	nAcc uint
+}
+
+// write appends the n low bits of v
+func (w *bitWriter) write(v uint32, n uint) {
+	w.acc |= uint64(v) << w.nAcc
+	w.nAcc += n
+	for w.nAcc >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.nAcc -= 8
+	}
+}
+
+// bytes flushes the pending bits and returns the written data
+func (w *bitWriter) bytes() []byte {
+	if w.nAcc > 0 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc, w.nAcc = 0, 0
+	}
+	return w.buf
+}
+
+// huffmanCode is a canonical prefix code
+type huffmanCode struct {
+	lengths []uint8  // Code lengths as transmitted
+	codes   []uint16 // Bit-reversed codes, ready to be written LSB first
+	bits    []uint8  // Number of bits written per symbol
+}
+
+// writeSymbol writes the code of symbol
+func (c *huffmanCode) writeSymbol(w *bitWriter, symbol int) {
+	w.write(uint32(c.codes[symbol]), uint(c.bits[symbol]))
+}
+
+// cost returns the number of bits needed to write the symbols of histogram
+func (c *huffmanCode) cost(histogram []uint32) int {
+	total := 0
+	for symbol, count := range histogram {
+		total += int(count) * int(c.bits[symbol])
+	}
+	return total
+}
+
+// newHuffmanCode builds a length-limited canonical prefix code for histogram.
+// A code with a single used symbol needs no bits at all, which the decoder
+// handles as a special case.
+func newHuffmanCode(histogram []uint32, maxLength int) *huffmanCode {
+	n := len(histogram)
+	c := &huffmanCode{
+		lengths: make([]uint8, n),
+		codes:   make([]uint16, n),
+		bits:    make([]uint8, n),
+	}
+
+	var used []int
+	for symbol, count := range histogram {
+		if count > 0 {
+			used = append(used, symbol)
+		}
+	}
+
+	switch len(used) {
+	case 0:
+		return c
+	case 1:
+		c.lengths[used[0]] = 1
+		return c
+	}
+
+	lengths := huffmanLengths(histogram, used, maxLength)
+	for symbol, length := range lengths {
+		c.lengths[symbol] = length
+		c.bits[symbol] = length
+	}
+
+	// Assign canonical codes in symbol order, shortest codes first
+	var count [16]int
+	for _, length := range c.lengths {
+		count[length]++
+	}
+	count[0] = 0
+	var next [16]int
+	code := 0
+	for length := 1; length < len(next); length++ {
+		code = (code + count[length-1]) << 1
+		next[length] = code
+	}
+	for symbol, length := range c.lengths {
+		if length > 0 {
+			c.codes[symbol] = reverseBits(uint16(next[length]), length)
+			next[length]++
+		}
+	}
+
+	return c
+}
+
+// huffmanLengths computes code lengths no longer than maxLength. When the
+// optimal code is too deep, small counts are raised until it fits.
+func huffmanLengths(histogram []uint32, used []int, maxLength int) []uint8 {
+	counts := make([]uint32, len(used))
+	for floor := uint32(1); ; floor *= 2 {
+		for i, symbol := range used {
+			counts[i] = histogram[symbol]
+			if counts[i] < floor {
+				counts[i] = floor
+			}
+		}
+
+		depths := huffmanDepths(counts)
+		deepest := 0
+		for _, depth := range depths {
+			deepest = max(deepest, depth)
+		}
+		if deepest <= maxLength {
+			lengths := make([]uint8, len(histogram))
+			for i, symbol := range used {
+				lengths[symbol] = uint8(depths[i])
+			}
+			return lengths
+		}
+	}
+}
+
+// huffmanDepths returns the depth of each leaf of a Huffman tree built from
+// counts, using the two-queue construction over sorted leaves
+func huffmanDepths(counts []uint32) []int {
+	type node struct {
+		count       uint64
+		left, right int // Child node indices, -1 for leaves
+	}
+
+	order := make([]int, len(counts))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return counts[order[a]] < counts[order[b]] })
+
+	nodes := make([]node, 0, 2*len(counts)-1)
+	for _, i := range order {
+		nodes = append(nodes, node{count: uint64(counts[i]), left: -1, right: -1})
+	}
+
+	leaf, inner := 0, len(nodes)
+	pick := func() int {
+		if leaf < len(order) && (inner >= len(nodes) || nodes[leaf].count <= nodes[inner].count) {
+			leaf++
+			return leaf - 1
+		}
+		inner++
+		return inner - 1
+	}
+	for i := 1; i < len(counts); i++ {
+		a := pick()
+		b := pick()
+		nodes = append(nodes, node{count: nodes[a].count + nodes[b].count, left: a, right: b})
+	}
+
+	// Walk down from the root, which is the last node
+	depth := make([]int, len(nodes))
+	for i := len(nodes) - 1; i >= len(order); i-- {
+		depth[nodes[i].left] = depth[i] + 1
+		depth[nodes[i].right] = depth[i] + 1
+	}
+
+	depths := make([]int, len(counts))
+	for sorted, i := range order {
+		depths[i] = depth[sorted]
+	}
+	return depths
+}
+
+// reverseBits reverses the n low bits of v
+func reverseBits(v uint16, n uint8) uint16 {
+	var r uint16
+	for i := uint8(0); i < n; i++ {
+		r = r<<1 | v&1
+		v >>= 1
+	}
+	return r
+}
+
+// codeLengthCodeOrder is the order in which code length code lengths are written
+var codeLengthCodeOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// codeLengthToken is a symbol of the code length alphabet with its extra bits
+type codeLengthToken struct {
+	symbol    int
+	extra     uint32
+	extraBits uint
+}
+
+// writeHuffmanCode writes the prefix code c for an alphabet of len(c.lengths)
+// symbols. Codes with at most two symbols below 256 use the compact "simple"
+// form; c is updated to match the codes the decoder will derive.
+func writeHuffmanCode(w *bitWriter, c *huffmanCode) {
+	var used []int
+	for symbol, length := range c.lengths {
+		if length > 0 {
+			used = append(used, symbol)
+		}
+	}
+
+	if len(used) == 0 {
+		// An unused alphabet still needs a valid code: one symbol, zero bits
+		used = []int{0}
+	}
+	if len(used) <= 2 && used[len(used)-1] < 256 {
+		writeSimpleCode(w, c, used)
+		return
+	}
+
+	tokens := codeLengthTokens(c.lengths)
+	histogram := make([]uint32, 19)
+	for _, token := range tokens {
+		histogram[token.symbol]++
+	}
+	lengthCode := newHuffmanCode(histogram, 7)
+
+	n := 4
+	for i, symbol := range codeLengthCodeOrder {
+		if lengthCode.lengths[symbol] > 0 {
+			n = max(n, i+1)
+		}
+	}
+
+	w.write(0, 1) // Normal code
+	w.write(uint32(n-4), 4)
+	for _, symbol := range codeLengthCodeOrder[:n] {
+		w.write(uint32(lengthCode.lengths[symbol]), 3)
+	}
+
+	w.write(0, 1) // All symbols are coded, no max_symbol
+	for _, token := range tokens {
+		lengthCode.writeSymbol(w, token.symbol)
+		if token.extraBits > 0 {
+			w.write(token.extra, token.extraBits)
+		}
+	}
+}
+
+// writeSimpleCode writes a code of one or two symbols below 256
+func writeSimpleCode(w *bitWriter, c *huffmanCode, symbols []int) {
+	w.write(1, 1) // Simple code
+	w.write(uint32(len(symbols)-1), 1)
+	if symbols[0] < 2 {
+		w.write(0, 1)
+		w.write(uint32(symbols[0]), 1)
+	} else {
+		w.write(1, 1)
+		w.write(uint32(symbols[0]), 8)
+	}
+
+	for i := range c.bits {
+		c.bits[i], c.codes[i] = 0, 0
+	}
+	if len(symbols) == 2 {
+		w.write(uint32(symbols[1]), 8)
+		c.bits[symbols[0]], c.codes[symbols[0]] = 1, 0
+		c.bits[symbols[1]], c.codes[symbols[1]] = 1, 1
+	}
+}
+
+// codeLengthTokens run-length encodes code lengths with the repeat codes
+// 16 (previous non-zero length), 17 (short zero run) and 18 (long zero run)
+func codeLengthTokens(lengths []uint8) []codeLengthToken {
+	var tokens []codeLengthToken
+	prev := uint8(8) // The decoder's initial value for code 16
+
+	for i := 0; i < len(lengths); {
+		value := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == value {
+			run++
+		}
+		i += run
+
+		if value == 0 {
+			for run > 0 {
+				switch {
+				case run < 3:
+					for ; run > 0; run-- {
+						tokens = append(tokens, codeLengthToken{symbol: 0})
+					}
+				case run <= 10:
+					tokens = append(tokens, codeLengthToken{symbol: 17, extra: uint32(run - 3), extraBits: 3})
+					run = 0
+				default:
+					r := min(run, 138)
+					tokens = append(tokens, codeLengthToken{symbol: 18, extra: uint32(r - 11), extraBits: 7})
+					run -= r
+				}
+			}
+			continue
+		}
+
+		if value != prev {
+			tokens = append(tokens, codeLengthToken{symbol: int(value)})
+			prev = value
+			run--
+		}
+		for run > 0 {
+			if run < 3 {
+				for ; run > 0; run-- {
+					tokens = append(tokens, codeLengthToken{symbol: int(value)})
+				}
+				break
+			}
+			r := min(run, 6)
+			tokens = append(tokens, codeLengthToken{symbol: 16, extra: uint32(r - 3), extraBits: 2})
+			run -= r
+		}
+	}
+
+	return tokens
+}
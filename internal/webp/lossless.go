@@ -0,0 +1,629 @@
+package webp
+
+import (
+	"image"
+	"math/bits"
+	"sort"
+)
+
+// VP8L constants, see the WebP lossless bitstream specification
+const (
+	vp8lSignature = 0x2f
+
+	transformPredictor     = 0
+	transformSubtractGreen = 2
+	transformColorIndexing = 3
+
+	predictorBits = 4 // Predictor tiles are 16x16 pixels
+
+	nLiteralCodes  = 256
+	nLengthCodes   = 24
+	nDistanceCodes = 40
+
+	maxLength   = 4096
+	maxDistance = 1<<20 - 120
+
+	colorCacheMultiplier = 0x1e35a7bd
+)
+
+// distanceMapTable maps short 2D offsets to distance codes 1-120
+var distanceMapTable = [120]uint8{
+	0x18, 0x07, 0x17, 0x19, 0x28, 0x06, 0x27, 0x29, 0x16, 0x1a,
+	0x26, 0x2a, 0x38, 0x05, 0x37, 0x39, 0x15, 0x1b, 0x36, 0x3a,
+	0x25, 0x2b, 0x48, 0x04, 0x47, 0x49, 0x14, 0x1c, 0x35, 0x3b,
+	0x46, 0x4a, 0x24, 0x2c, 0x58, 0x45, 0x4b, 0x34, 0x3c, 0x03,
+	0x57, 0x59, 0x13, 0x1d, 0x56, 0x5a, 0x23, 0x2d, 0x44, 0x4c,
+	0x55, 0x5b, 0x33, 0x3d, 0x68, 0x02, 0x67, 0x69, 0x12, 0x1e,
+	0x66, 0x6a, 0x22, 0x2e, 0x54, 0x5c, 0x43, 0x4d, 0x65, 0x6b,
+	0x32, 0x3e, 0x78, 0x01, 0x77, 0x79, 0x53, 0x5d, 0x11, 0x1f,
+	0x64, 0x6c, 0x42, 0x4e, 0x76, 0x7a, 0x21, 0x2f, 0x75, 0x7b,
+	0x31, 0x3f, 0x63, 0x6d, 0x52, 0x5e, 0x00, 0x74, 0x7c, 0x41,
+	0x4f, 0x10, 0x20, 0x62, 0x6e, 0x30, 0x73, 0x7d, 0x51, 0x5f,
+	0x40, 0x72, 0x7e, 0x61, 0x6f, 0x50, 0x71, 0x7f, 0x60, 0x70,
+}
+
+// encodeVP8L returns the VP8L bitstream of an NRGBA image
+func encodeVP8L(m *image.NRGBA) []byte {
+	w, h := m.Rect.Dx(), m.Rect.Dy()
+
+	argb := make([]uint32, w*h)
+	hasAlpha := false
+	for y := 0; y < h; y++ {
+		row := m.Pix[y*m.Stride : y*m.Stride+4*w]
+		for x := 0; x < w; x++ {
+			r, g, b, a := row[4*x], row[4*x+1], row[4*x+2], row[4*x+3]
+			argb[y*w+x] = uint32(a)<<24 | uint32(r)<<16 | uint32(g)<<8 | uint32(b)
+			if a != 0xff {
+				hasAlpha = true
+			}
+		}
+	}
+
+	bw := &bitWriter{}
+	bw.write(vp8lSignature, 8)
+	bw.write(uint32(w-1), 14)
+	bw.write(uint32(h-1), 14)
+	if hasAlpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3) // Version
+
+	if palette := findPalette(argb, 256); palette != nil {
+		argb, w = applyPalette(bw, argb, w, h, palette)
+	} else {
+		subtractGreen(bw, argb)
+		argb = applyPredictor(bw, argb, w, h)
+	}
+	bw.write(0, 1) // No more transforms
+
+	writeImageData(bw, argb, w, h, true)
+	return bw.bytes()
+}
+
+// findPalette returns the sorted distinct colors of argb, or nil if there are
+// more than limit
+func findPalette(argb []uint32, limit int) []uint32 {
+	seen := make(map[uint32]struct{}, limit+1)
+	last := argb[0] ^ 1
+	for _, c := range argb {
+		if c == last {
+			continue
+		}
+		last = c
+		if _, ok := seen[c]; ok {
+			continue
+		}
+		if len(seen) == limit {
+			return nil
+		}
+		seen[c] = struct{}{}
+	}
+
+	palette := make([]uint32, 0, len(seen))
+	for c := range seen {
+		palette = append(palette, c)
+	}
+	sort.Slice(palette, func(i, j int) bool { return palette[i] < palette[j] })
+	return palette
+}
+
+// applyPalette writes a color indexing transform and returns the image of
+// packed palette indices together with its width
+func applyPalette(bw *bitWriter, argb []uint32, w, h int, palette []uint32) ([]uint32, int) {
+	bw.write(1, 1)
+	bw.write(transformColorIndexing, 2)
+	bw.write(uint32(len(palette)-1), 8)
+
+	// The palette is delta coded
+	deltas := make([]uint32, len(palette))
+	for i, c := range palette {
+		if i == 0 {
+			deltas[i] = c
+		} else {
+			deltas[i] = subPixels(c, palette[i-1])
+		}
+	}
+	writeImageData(bw, deltas, len(palette), 1, false)
+
+	index := make(map[uint32]uint32, len(palette))
+	for i, c := range palette {
+		index[c] = uint32(i)
+	}
+
+	// Small palettes pack several pixels into one
+	xBits := 0
+	switch {
+	case len(palette) <= 2:
+		xBits = 3
+	case len(palette) <= 4:
+		xBits = 2
+	case len(palette) <= 16:
+		xBits = 1
+	}
+	bitsPerPixel := 8 >> xBits
+	packedWidth := (w + 1<<xBits - 1) >> xBits
+
+	packed := make([]uint32, packedWidth*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*packedWidth + x>>xBits
+			packed[i] |= index[argb[y*w+x]] << (8 + uint(bitsPerPixel*(x&(1<<xBits-1))))
+		}
+	}
+	for i := range packed {
+		packed[i] |= 0xff000000
+	}
+
+	return packed, packedWidth
+}
+
+// subtractGreen writes the subtract green transform and applies it in place
+func subtractGreen(bw *bitWriter, argb []uint32) {
+	bw.write(1, 1)
+	bw.write(transformSubtractGreen, 2)
+
+	for i, c := range argb {
+		green := (c >> 8) & 0xff
+		argb[i] = c&0xff00ff00 | ((c>>16-green)&0xff)<<16 | (c-green)&0xff
+	}
+}
+
+// applyPredictor writes a predictor transform choosing the best of the 14
+// spatial predictors for each tile, and returns the residuals
+func applyPredictor(bw *bitWriter, argb []uint32, w, h int) []uint32 {
+	tilesX := (w + 1<<predictorBits - 1) >> predictorBits
+	tilesY := (h + 1<<predictorBits - 1) >> predictorBits
+	modes := make([]uint32, tilesX*tilesY)
+
+	for ty := 0; ty < tilesY; ty++ {
+		for tx := 0; tx < tilesX; tx++ {
+			modes[ty*tilesX+tx] = bestPredictor(argb, w, h, tx, ty)
+		}
+	}
+
+	bw.write(1, 1)
+	bw.write(transformPredictor, 2)
+	bw.write(predictorBits-2, 3)
+	tileImage := make([]uint32, len(modes))
+	for i, mode := range modes {
+		tileImage[i] = 0xff000000 | mode<<8
+	}
+	writeImageData(bw, tileImage, tilesX, tilesY, false)
+
+	residuals := make([]uint32, len(argb))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			var mode uint32
+			switch {
+			case y == 0 && x == 0:
+				mode = 0
+			case y == 0:
+				mode = 1
+			case x == 0:
+				mode = 2
+			default:
+				mode = modes[(y>>predictorBits)*tilesX+x>>predictorBits]
+			}
+			residuals[i] = subPixels(argb[i], predict(mode, argb, i, w))
+		}
+	}
+
+	return residuals
+}
+
+// bestPredictor returns the predictor mode with the smallest residuals in a tile
+func bestPredictor(argb []uint32, w, h, tx, ty int) uint32 {
+	x0, y0 := tx<<predictorBits, ty<<predictorBits
+	x1, y1 := min(x0+1<<predictorBits, w), min(y0+1<<predictorBits, h)
+	x0, y0 = max(x0, 1), max(y0, 1)
+
+	best, bestCost := uint32(1), -1
+	for mode := uint32(0); mode < 14; mode++ {
+		cost := 0
+		for y := y0; y < y1 && (bestCost < 0 || cost < bestCost); y++ {
+			for x := x0; x < x1; x++ {
+				i := y*w + x
+				cost += residualCost(subPixels(argb[i], predict(mode, argb, i, w)))
+			}
+		}
+		if bestCost < 0 || cost < bestCost {
+			best, bestCost = mode, cost
+		}
+	}
+	return best
+}
+
+// residualCost estimates how expensive a residual is to code: the sum of
+// the magnitudes of its channels as signed values
+func residualCost(c uint32) int {
+	cost := 0
+	for shift := 0; shift < 32; shift += 8 {
+		v := int(int8(c >> shift))
+		if v < 0 {
+			v = -v
+		}
+		cost += v
+	}
+	return cost
+}
+
+// predict returns the prediction of pixel i for one of the 14 VP8L modes
+func predict(mode uint32, argb []uint32, i, w int) uint32 {
+	switch mode {
+	case 0:
+		return 0xff000000
+	case 1:
+		return argb[i-1]
+	case 2:
+		return argb[i-w]
+	case 3:
+		return argb[i-w+1]
+	case 4:
+		return argb[i-w-1]
+	case 5:
+		return average2(average2(argb[i-1], argb[i-w+1]), argb[i-w])
+	case 6:
+		return average2(argb[i-1], argb[i-w-1])
+	case 7:
+		return average2(argb[i-1], argb[i-w])
+	case 8:
+		return average2(argb[i-w-1], argb[i-w])
+	case 9:
+		return average2(argb[i-w], argb[i-w+1])
+	case 10:
+		return average2(average2(argb[i-1], argb[i-w-1]), average2(argb[i-w], argb[i-w+1]))
+	case 11:
+		return selectPredictor(argb[i-1], argb[i-w], argb[i-w-1])
+	case 12:
+		return clampAddSubtractFull(argb[i-1], argb[i-w], argb[i-w-1])
+	default:
+		return clampAddSubtractHalf(average2(argb[i-1], argb[i-w]), argb[i-w-1])
+	}
+}
+
+// subPixels subtracts b from a per channel, modulo 256
+func subPixels(a, b uint32) uint32 {
+	alphaGreen := 0x00ff00ff + a&0xff00ff00 - b&0xff00ff00
+	redBlue := 0xff00ff00 + a&0x00ff00ff - b&0x00ff00ff
+	return alphaGreen&0xff00ff00 | redBlue&0x00ff00ff
+}
+
+// average2 averages a and b per channel, rounding down
+func average2(a, b uint32) uint32 {
+	return ((a^b)&0xfefefefe)>>1 + a&b
+}
+
+// selectPredictor picks the left or top pixel, whichever is closer to the
+// gradient estimate L + T - TL
+func selectPredictor(l, t, tl uint32) uint32 {
+	predictLeft, predictTop := 0, 0
+	for shift := 0; shift < 32; shift += 8 {
+		cl, ct, ctl := int(l>>shift&0xff), int(t>>shift&0xff), int(tl>>shift&0xff)
+		predictLeft += abs(ct - ctl)
+		predictTop += abs(cl - ctl)
+	}
+	if predictLeft < predictTop {
+		return l
+	}
+	return t
+}
+
+// clampAddSubtractFull returns a + b - c per channel, clamped to 0-255
+func clampAddSubtractFull(a, b, c uint32) uint32 {
+	var out uint32
+	for shift := 0; shift < 32; shift += 8 {
+		v := int(a>>shift&0xff) + int(b>>shift&0xff) - int(c>>shift&0xff)
+		out |= uint32(clamp255(v)) << shift
+	}
+	return out
+}
+
+// clampAddSubtractHalf returns a + (a - b) / 2 per channel, clamped to 0-255
+func clampAddSubtractHalf(a, b uint32) uint32 {
+	var out uint32
+	for shift := 0; shift < 32; shift += 8 {
+		ca, cb := int(a>>shift&0xff), int(b>>shift&0xff)
+		out |= uint32(clamp255(ca+(ca-cb)/2)) << shift
+	}
+	return out
+}
+
+func clamp255(v int) int {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return v
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// token is a literal pixel or a backward reference in the LZ77 stream
+type token struct {
+	argb     uint32 // Literal pixel
+	length   int    // Copy length, 0 for literals
+	distance int    // Copy distance code
+}
+
+// writeImageData entropy codes an image: optional color cache, prefix codes
+// and the LZ77-compressed pixels. Only the main image may use meta prefix
+// codes, which are not used here.
+func writeImageData(bw *bitWriter, argb []uint32, w, h int, topLevel bool) {
+	tokens := backwardReferences(argb, w)
+
+	// Pick the color cache size that gives the smallest output
+	bestBits, bestCost := 0, -1
+	for _, cacheBits := range []int{0, 4, 6, 8, 10} {
+		if cacheBits > 0 && len(argb) < 1<<cacheBits {
+			continue
+		}
+		histograms := tokenHistograms(tokens, argb, cacheBits)
+		cost := 0
+		for _, histogram := range histograms {
+			cost += newHuffmanCode(histogram, 15).cost(histogram)
+		}
+		if bestCost < 0 || cost < bestCost {
+			bestBits, bestCost = cacheBits, cost
+		}
+	}
+
+	if bestBits > 0 {
+		bw.write(1, 1)
+		bw.write(uint32(bestBits), 4)
+	} else {
+		bw.write(0, 1)
+	}
+	if topLevel {
+		bw.write(0, 1) // No meta prefix codes
+	}
+
+	histograms := tokenHistograms(tokens, argb, bestBits)
+	var codes [5]*huffmanCode
+	for i, histogram := range histograms {
+		codes[i] = newHuffmanCode(histogram, 15)
+		writeHuffmanCode(bw, codes[i])
+	}
+
+	cache := newColorCache(bestBits)
+	p := 0
+	for _, t := range tokens {
+		if t.length == 0 {
+			if key, ok := cache.lookup(t.argb); ok {
+				codes[0].writeSymbol(bw, nLiteralCodes+nLengthCodes+key)
+			} else {
+				codes[0].writeSymbol(bw, int(t.argb>>8&0xff))
+				codes[1].writeSymbol(bw, int(t.argb>>16&0xff))
+				codes[2].writeSymbol(bw, int(t.argb&0xff))
+				codes[3].writeSymbol(bw, int(t.argb>>24))
+			}
+			cache.insert(t.argb)
+			p++
+			continue
+		}
+
+		prefix, extraBits, extra := prefixEncode(t.length)
+		codes[0].writeSymbol(bw, nLiteralCodes+prefix)
+		bw.write(extra, extraBits)
+		prefix, extraBits, extra = prefixEncode(t.distance)
+		codes[4].writeSymbol(bw, prefix)
+		bw.write(extra, extraBits)
+
+		for _, c := range argb[p : p+t.length] {
+			cache.insert(c)
+		}
+		p += t.length
+	}
+}
+
+// tokenHistograms counts the symbols of the green/length/cache, red, blue,
+// alpha and distance alphabets for a given color cache size
+func tokenHistograms(tokens []token, argb []uint32, cacheBits int) [5][]uint32 {
+	var histograms [5][]uint32
+	histograms[0] = make([]uint32, nLiteralCodes+nLengthCodes+colorCacheSize(cacheBits))
+	histograms[1] = make([]uint32, nLiteralCodes)
+	histograms[2] = make([]uint32, nLiteralCodes)
+	histograms[3] = make([]uint32, nLiteralCodes)
+	histograms[4] = make([]uint32, nDistanceCodes)
+
+	cache := newColorCache(cacheBits)
+	p := 0
+	for _, t := range tokens {
+		if t.length == 0 {
+			if key, ok := cache.lookup(t.argb); ok {
+				histograms[0][nLiteralCodes+nLengthCodes+key]++
+			} else {
+				histograms[0][t.argb>>8&0xff]++
+				histograms[1][t.argb>>16&0xff]++
+				histograms[2][t.argb&0xff]++
+				histograms[3][t.argb>>24]++
+			}
+			cache.insert(t.argb)
+			p++
+			continue
+		}
+
+		prefix, _, _ := prefixEncode(t.length)
+		histograms[0][nLiteralCodes+prefix]++
+		prefix, _, _ = prefixEncode(t.distance)
+		histograms[4][prefix]++
+
+		if cache.bits > 0 {
+			for _, c := range argb[p : p+t.length] {
+				cache.insert(c)
+			}
+		}
+		p += t.length
+	}
+
+	return histograms
+}
+
+// colorCache holds recently used colors addressed by a multiplicative hash
+type colorCache struct {
+	bits    int
+	entries []uint32
+	valid   []bool
+}
+
+func newColorCache(bits int) *colorCache {
+	size := colorCacheSize(bits)
+	return &colorCache{bits: bits, entries: make([]uint32, size), valid: make([]bool, size)}
+}
+
+func colorCacheSize(bits int) int {
+	if bits == 0 {
+		return 0
+	}
+	return 1 << bits
+}
+
+func (c *colorCache) key(argb uint32) int {
+	return int((argb * colorCacheMultiplier) >> (32 - c.bits))
+}
+
+// lookup returns the cache key of argb if it is cached
+func (c *colorCache) lookup(argb uint32) (int, bool) {
+	if c.bits == 0 {
+		return 0, false
+	}
+	key := c.key(argb)
+	return key, c.valid[key] && c.entries[key] == argb
+}
+
+func (c *colorCache) insert(argb uint32) {
+	if c.bits == 0 {
+		return
+	}
+	key := c.key(argb)
+	c.entries[key] = argb
+	c.valid[key] = true
+}
+
+// prefixEncode splits a length or distance code into its prefix symbol and
+// extra bits
+func prefixEncode(v int) (prefix int, extraBits uint, extra uint32) {
+	x := v - 1
+	if x < 4 {
+		return x, 0, 0
+	}
+	high := bits.Len(uint(x)) - 1
+	second := (x >> (high - 1)) & 1
+	extraBits = uint(high - 1)
+	return 2*high + second, extraBits, uint32(x) & (1<<extraBits - 1)
+}
+
+// backwardReferences finds LZ77 matches with a hash chain over pixel pairs.
+// Runs (distance 1) and copies of the row above are always tried first, as
+// they dominate in screenshots.
+func backwardReferences(argb []uint32, w int) []token {
+	const (
+		hashBits = 16
+		maxChain = 32
+		minMatch = 3
+	)
+
+	n := len(argb)
+	distanceCodes := newDistanceCodes(w)
+
+	head := make([]int32, 1<<hashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	chain := make([]int32, n)
+
+	hash := func(i int) int {
+		return int(((argb[i]*0x1e35a7bd)^(argb[i+1]*0x9e3779b1))>>(32-hashBits)) & (1<<hashBits - 1)
+	}
+	insert := func(i int) {
+		if i+1 < n {
+			key := hash(i)
+			chain[i] = head[key]
+			head[key] = int32(i)
+		}
+	}
+	matchLength := func(i, j int) int {
+		limit := min(maxLength, n-i)
+		length := 0
+		for length < limit && argb[i+length] == argb[j+length] {
+			length++
+		}
+		return length
+	}
+
+	var tokens []token
+	for i := 0; i < n; {
+		bestLength, bestDistance := 0, 0
+		for _, distance := range []int{1, w} {
+			if distance <= i {
+				if length := matchLength(i, i-distance); length > bestLength {
+					bestLength, bestDistance = length, distance
+				}
+			}
+		}
+
+		if bestLength < maxLength && i+1 < n {
+			candidate := head[hash(i)]
+			for steps := 0; candidate >= 0 && steps < maxChain; steps++ {
+				distance := i - int(candidate)
+				if distance > maxDistance {
+					break
+				}
+				if length := matchLength(i, int(candidate)); length > bestLength {
+					bestLength, bestDistance = length, distance
+					if length == maxLength {
+						break
+					}
+				}
+				candidate = chain[candidate]
+			}
+		}
+
+		if bestLength < minMatch {
+			tokens = append(tokens, token{argb: argb[i]})
+			insert(i)
+			i++
+			continue
+		}
+
+		tokens = append(tokens, token{length: bestLength, distance: distanceCodes.code(bestDistance)})
+		for j := i; j < i+bestLength; j++ {
+			insert(j)
+		}
+		i += bestLength
+	}
+
+	return tokens
+}
+
+// distanceCodes maps distances to the short 2D distance codes where possible
+type distanceCodes []int
+
+func newDistanceCodes(w int) distanceCodes {
+	codes := make(distanceCodes, 7*w+9)
+	for i := len(distanceMapTable) - 1; i >= 0; i-- {
+		yOffset := int(distanceMapTable[i] >> 4)
+		xOffset := 8 - int(distanceMapTable[i]&0xf)
+		if distance := yOffset*w + xOffset; distance >= 1 && distance < len(codes) {
+			codes[distance] = i + 1
+		}
+	}
+	return codes
+}
+
+// code returns the distance code of a pixel distance
+func (c distanceCodes) code(distance int) int {
+	if distance < len(c) && c[distance] > 0 {
+		return c[distance]
+	}
+	return distance + len(distanceMapTable)
+}
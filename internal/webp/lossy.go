@@ -0,0 +1,782 @@
+package webp
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"math"
+)
+
+// Intra prediction modes, numbered as in the decoder
+const (
+	predDC = iota
+	predTM
+	predVE
+	predHE
+)
+
+// Probability indices of the branches of the coefficient token tree
+const (
+	probEOB = iota
+	probZero
+	probOne
+)
+
+// maxLevel is the largest quantized coefficient magnitude VP8 can code
+const maxLevel = 2048
+
+// maxFirstPartition is the largest first partition the frame tag can describe
+const maxFirstPartition = 1<<19 - 1
+
+// quantMatrix holds the DC and AC quantizer steps and rounding biases (in
+// 1/256ths of a step) of one kind of block
+type quantMatrix struct {
+	q    [2]int32
+	bias [2]int32
+}
+
+// quantize returns the quantized level of coefficient c at position i
+func (m *quantMatrix) quantize(c int32, i int) int32 {
+	k := min(i, 1)
+	sign := c < 0
+	if sign {
+		c = -c
+	}
+	level := (c + m.q[k]*m.bias[k]>>8) / m.q[k]
+	level = min(level, maxLevel)
+	if sign {
+		return -level
+	}
+	return level
+}
+
+// dequantize returns the coefficient reconstructed from level at position i
+func (m *quantMatrix) dequantize(level int32, i int) int32 {
+	return level * m.q[min(i, 1)]
+}
+
+// macroblock records the coding decisions of a 16x16 macroblock
+type macroblock struct {
+	yMode, uvMode uint8
+	skip          bool
+	levels        int // Offset of the 25 blocks of levels, in zigzag order
+}
+
+// vp8Encoder encodes a key frame using 16x16 luma and 8x8 chroma prediction
+type vp8Encoder struct {
+	mbw, mbh int
+	qIndex   int
+	y1, y2   quantMatrix
+	uv       quantMatrix
+
+	// Source and reconstructed planes, padded to whole macroblocks
+	srcY, srcU, srcV []uint8
+	recY, recU, recV []uint8
+	yStride, cStride int
+
+	mbs    []macroblock
+	levels []int16 // Per coded macroblock: Y2, 16 Y, 4 U and 4 V blocks
+
+	probs [nPlanes][nBands][nContexts][nProbs]uint8
+}
+
+// encodeVP8 returns the VP8 key frame of the opaque part of an image
+func encodeVP8(m *image.NRGBA, quality int) ([]byte, error) {
+	w, h := m.Rect.Dx(), m.Rect.Dy()
+	e := &vp8Encoder{
+		mbw:    (w + 15) / 16,
+		mbh:    (h + 15) / 16,
+		qIndex: quantizerIndex(quality),
+		probs:  defaultCoeffProbs,
+	}
+	e.initQuant()
+	e.importImage(m)
+
+	for mby := 0; mby < e.mbh; mby++ {
+		for mbx := 0; mbx < e.mbw; mbx++ {
+			e.encodeMacroblock(mbx, mby)
+		}
+	}
+
+	updates := e.optimizeProbs()
+	first := e.writeFirstPartition(updates)
+	if len(first) > maxFirstPartition {
+		return nil, errors.New("webp: image too large for lossy encoding")
+	}
+	tokens := e.writeTokenPartition()
+
+	data := make([]byte, 10, 10+len(first)+len(tokens))
+	const keyFrame, version, show = 0, 0, 1
+	putUint24(data, keyFrame|version<<1|show<<4|uint32(len(first))<<5)
+	data[3], data[4], data[5] = 0x9d, 0x01, 0x2a
+	binary.LittleEndian.PutUint16(data[6:], uint16(w))
+	binary.LittleEndian.PutUint16(data[8:], uint16(h))
+	data = append(data, first...)
+	data = append(data, tokens...)
+	return data, nil
+}
+
+// quantizerIndex maps a quality of 1 to 100 to a VP8 quantizer index of 127
+// to 0
+func quantizerIndex(quality int) int {
+	quality = max(1, min(quality, 100))
+	return ((100-quality)*127 + 49) / 99
+}
+
+// initQuant derives the quantizer steps from the quantizer index, RFC 6386
+// section 14.1
+func (e *vp8Encoder) initQuant() {
+	q := e.qIndex
+	e.y1 = quantMatrix{q: [2]int32{dcQuant[q], acQuant[q]}, bias: [2]int32{96, 110}}
+	e.y2 = quantMatrix{q: [2]int32{dcQuant[q] * 2, max(acQuant[q]*155/100, 8)}, bias: [2]int32{96, 108}}
+	e.uv = quantMatrix{q: [2]int32{dcQuant[min(q, 117)], acQuant[q]}, bias: [2]int32{110, 115}}
+}
+
+// importImage converts the image to limited range Y'CbCr 4:2:0 with the
+// BT.601 coefficients, replicating the edge pixels to fill whole macroblocks
+func (e *vp8Encoder) importImage(m *image.NRGBA) {
+	w, h := m.Rect.Dx(), m.Rect.Dy()
+	e.yStride, e.cStride = e.mbw*16, e.mbw*8
+	e.srcY = make([]uint8, e.yStride*e.mbh*16)
+	e.srcU = make([]uint8, e.cStride*e.mbh*8)
+	e.srcV = make([]uint8, e.cStride*e.mbh*8)
+	e.recY = make([]uint8, len(e.srcY))
+	e.recU = make([]uint8, len(e.srcU))
+	e.recV = make([]uint8, len(e.srcV))
+
+	rgb := func(x, y int) (int32, int32, int32) {
+		i := min(y, h-1)*m.Stride + 4*min(x, w-1)
+		return int32(m.Pix[i]), int32(m.Pix[i+1]), int32(m.Pix[i+2])
+	}
+
+	for y := 0; y < e.mbh*16; y++ {
+		for x := 0; x < e.yStride; x++ {
+			r, g, b := rgb(x, y)
+			e.srcY[y*e.yStride+x] = uint8((16839*r + 33059*g + 6420*b + 1<<15 + 16<<16) >> 16)
+		}
+	}
+
+	for y := 0; y < e.mbh*8; y++ {
+		for x := 0; x < e.cStride; x++ {
+			var r, g, b int32
+			for _, p := range [4][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+				pr, pg, pb := rgb(2*x+p[0], 2*y+p[1])
+				r, g, b = r+pr, g+pg, b+pb
+			}
+			e.srcU[y*e.cStride+x] = clipUV(-9719*r - 19081*g + 28800*b)
+			e.srcV[y*e.cStride+x] = clipUV(28800*r - 24116*g - 4684*b)
+		}
+	}
+}
+
+// clipUV scales a chroma value computed from the sum of four pixels
+func clipUV(uv int32) uint8 {
+	return uint8(clamp255(int((uv + 1<<17 + 128<<18) >> 18)))
+}
+
+// encodeMacroblock chooses the prediction modes of a macroblock, quantizes
+// its residuals and reconstructs it the way the decoder will
+func (e *vp8Encoder) encodeMacroblock(mbx, mby int) {
+	mb := macroblock{levels: len(e.levels)}
+	levels := make([]int16, 25*16)
+	nonZero := false
+
+	// Luma: 16 blocks whose DC coefficients are coded separately by Y2
+	yOffset := mby*16*e.yStride + mbx*16
+	var pred [256]uint8
+	mb.yMode = e.bestMode(pred[:], 16, e.srcY, e.recY, e.yStride, yOffset, mbx, mby)
+
+	var coeffs [16][16]int32
+	var dc [16]int32
+	for n := 0; n < 16; n++ {
+		off := yOffset + (n/4)*4*e.yStride + (n%4)*4
+		forwardDCT(&coeffs[n], e.srcY[off:], e.yStride, pred[(n/4)*64+(n%4)*4:], 16)
+		dc[n] = coeffs[n][0]
+	}
+	var wht [16]int32
+	forwardWHT(&wht, &dc)
+	var y2 [16]int32
+	for i := 0; i < 16; i++ {
+		level := e.y2.quantize(wht[zigzag[i]], i)
+		levels[i] = int16(level)
+		y2[zigzag[i]] = e.y2.dequantize(level, i)
+		nonZero = nonZero || level != 0
+	}
+	inverseWHT(&dc, &y2)
+	for n := 0; n < 16; n++ {
+		var block [16]int32
+		block[0] = dc[n]
+		for i := 1; i < 16; i++ {
+			level := e.y1.quantize(coeffs[n][zigzag[i]], i)
+			levels[16+16*n+i] = int16(level)
+			block[zigzag[i]] = e.y1.dequantize(level, i)
+			nonZero = nonZero || level != 0
+		}
+		off := yOffset + (n/4)*4*e.yStride + (n%4)*4
+		inverseDCT(e.recY[off:], e.yStride, pred[(n/4)*64+(n%4)*4:], 16, &block)
+	}
+
+	// Chroma: both planes use the same mode
+	cOffset := mby*8*e.cStride + mbx*8
+	var predU, predV [64]uint8
+	mb.uvMode = e.bestChromaMode(predU[:], predV[:], cOffset, mbx, mby)
+	for p, plane := range [2]struct{ src, rec, pred []uint8 }{
+		{e.srcU, e.recU, predU[:]},
+		{e.srcV, e.recV, predV[:]},
+	} {
+		for n := 0; n < 4; n++ {
+			off := cOffset + (n/2)*4*e.cStride + (n%2)*4
+			predOff := (n/2)*32 + (n%2)*4
+			var c, block [16]int32
+			forwardDCT(&c, plane.src[off:], e.cStride, plane.pred[predOff:], 8)
+			base := 17*16 + (4*p+n)*16
+			for i := 0; i < 16; i++ {
+				level := e.uv.quantize(c[zigzag[i]], i)
+				levels[base+i] = int16(level)
+				block[zigzag[i]] = e.uv.dequantize(level, i)
+				nonZero = nonZero || level != 0
+			}
+			inverseDCT(plane.rec[off:], e.cStride, plane.pred[predOff:], 8, &block)
+		}
+	}
+
+	mb.skip = !nonZero
+	if !mb.skip {
+		e.levels = append(e.levels, levels...)
+	}
+	e.mbs = append(e.mbs, mb)
+}
+
+// edges returns the reconstructed pixels above and left of a block, and the
+// one above-left. Outside the frame the decoder assumes 127 above and 129 to
+// the left.
+func edges(rec []uint8, stride, offset, n, mbx, mby int) (top, left []uint8, topLeft uint8) {
+	top = make([]uint8, n)
+	left = make([]uint8, n)
+	for i := 0; i < n; i++ {
+		if mby == 0 {
+			top[i] = 127
+		} else {
+			top[i] = rec[offset-stride+i]
+		}
+		if mbx == 0 {
+			left[i] = 129
+		} else {
+			left[i] = rec[offset+i*stride-1]
+		}
+	}
+	switch {
+	case mby == 0:
+		topLeft = 127
+	case mbx == 0:
+		topLeft = 129
+	default:
+		topLeft = rec[offset-stride-1]
+	}
+	return top, left, topLeft
+}
+
+// predictBlock fills the n by n block dst with the prediction of mode
+func predictBlock(dst []uint8, n int, mode uint8, top, left []uint8, topLeft uint8, mbx, mby int) {
+	switch mode {
+	case predDC:
+		shift := 3
+		if n == 16 {
+			shift = 4
+		}
+		var sum int
+		switch {
+		case mbx == 0 && mby == 0:
+			sum = 128 << shift
+		case mby == 0:
+			for _, v := range left {
+				sum += int(v)
+			}
+		case mbx == 0:
+			for _, v := range top {
+				sum += int(v)
+			}
+		default:
+			for i := range top {
+				sum += int(top[i]) + int(left[i])
+			}
+			shift++
+		}
+		avg := uint8((sum + 1<<(shift-1)) >> shift)
+		for i := range dst[:n*n] {
+			dst[i] = avg
+		}
+	case predTM:
+		for y := 0; y < n; y++ {
+			for x := 0; x < n; x++ {
+				dst[y*n+x] = uint8(clamp255(int(left[y]) + int(top[x]) - int(topLeft)))
+			}
+		}
+	case predVE:
+		for y := 0; y < n; y++ {
+			copy(dst[y*n:y*n+n], top)
+		}
+	case predHE:
+		for y := 0; y < n; y++ {
+			for x := 0; x < n; x++ {
+				dst[y*n+x] = left[y]
+			}
+		}
+	}
+}
+
+// bestMode fills pred with the n by n prediction closest to the source block
+// and returns its mode
+func (e *vp8Encoder) bestMode(pred []uint8, n int, src, rec []uint8, stride, offset, mbx, mby int) uint8 {
+	top, left, topLeft := edges(rec, stride, offset, n, mbx, mby)
+	candidate := make([]uint8, n*n)
+	best, bestCost := uint8(predDC), -1
+	for _, mode := range []uint8{predDC, predVE, predHE, predTM} {
+		predictBlock(candidate, n, mode, top, left, topLeft, mbx, mby)
+		if cost := sse(src[offset:], stride, candidate, n); bestCost < 0 || cost < bestCost {
+			best, bestCost = mode, cost
+			copy(pred, candidate)
+		}
+	}
+	return best
+}
+
+// bestChromaMode fills predU and predV with the chroma prediction closest to
+// the source and returns its mode
+func (e *vp8Encoder) bestChromaMode(predU, predV []uint8, offset, mbx, mby int) uint8 {
+	topU, leftU, topLeftU := edges(e.recU, e.cStride, offset, 8, mbx, mby)
+	topV, leftV, topLeftV := edges(e.recV, e.cStride, offset, 8, mbx, mby)
+	var u, v [64]uint8
+	best, bestCost := uint8(predDC), -1
+	for _, mode := range []uint8{predDC, predVE, predHE, predTM} {
+		predictBlock(u[:], 8, mode, topU, leftU, topLeftU, mbx, mby)
+		predictBlock(v[:], 8, mode, topV, leftV, topLeftV, mbx, mby)
+		cost := sse(e.srcU[offset:], e.cStride, u[:], 8) + sse(e.srcV[offset:], e.cStride, v[:], 8)
+		if bestCost < 0 || cost < bestCost {
+			best, bestCost = mode, cost
+			copy(predU, u[:])
+			copy(predV, v[:])
+		}
+	}
+	return best
+}
+
+// sse returns the sum of squared differences of an n by n block
+func sse(src []uint8, stride int, pred []uint8, n int) int {
+	total := 0
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			d := int(src[y*stride+x]) - int(pred[y*n+x])
+			total += d * d
+		}
+	}
+	return total
+}
+
+// forwardDCT transforms the 4x4 residual of src against pred, as libwebp does.
+// Coefficients are stored row by row, vertical frequency first.
+func forwardDCT(out *[16]int32, src []uint8, stride int, pred []uint8, predStride int) {
+	var tmp [16]int32
+	for i := 0; i < 4; i++ {
+		var d [4]int32
+		for j := range d {
+			d[j] = int32(src[i*stride+j]) - int32(pred[i*predStride+j])
+		}
+		a0, a1 := d[0]+d[3], d[1]+d[2]
+		a2, a3 := d[1]-d[2], d[0]-d[3]
+		tmp[0+i*4] = (a0 + a1) * 8
+		tmp[1+i*4] = (a2*2217 + a3*5352 + 1812) >> 9
+		tmp[2+i*4] = (a0 - a1) * 8
+		tmp[3+i*4] = (a3*2217 - a2*5352 + 937) >> 9
+	}
+	for i := 0; i < 4; i++ {
+		a0, a1 := tmp[0+i]+tmp[12+i], tmp[4+i]+tmp[8+i]
+		a2, a3 := tmp[4+i]-tmp[8+i], tmp[0+i]-tmp[12+i]
+		out[0+i] = (a0 + a1 + 7) >> 4
+		out[4+i] = (a2*2217 + a3*5352 + 12000) >> 16
+		if a3 != 0 {
+			out[4+i]++
+		}
+		out[8+i] = (a0 - a1 + 7) >> 4
+		out[12+i] = (a3*2217 - a2*5352 + 51000) >> 16
+	}
+}
+
+// inverseDCT adds the inverse transform of coeffs to pred and stores the
+// result in dst, exactly as the decoder does
+func inverseDCT(dst []uint8, stride int, pred []uint8, predStride int, coeffs *[16]int32) {
+	const (
+		c1 = 85627 // 65536 * cos(pi/8) * sqrt(2)
+		c2 = 35468 // 65536 * sin(pi/8) * sqrt(2)
+	)
+	var m [4][4]int32
+	for i := 0; i < 4; i++ {
+		a := coeffs[0+i] + coeffs[8+i]
+		b := coeffs[0+i] - coeffs[8+i]
+		c := (coeffs[4+i]*c2)>>16 - (coeffs[12+i]*c1)>>16
+		d := (coeffs[4+i]*c1)>>16 + (coeffs[12+i]*c2)>>16
+		m[i][0] = a + d
+		m[i][1] = b + c
+		m[i][2] = b - c
+		m[i][3] = a - d
+	}
+	for j := 0; j < 4; j++ {
+		dc := m[0][j] + 4
+		a := dc + m[2][j]
+		b := dc - m[2][j]
+		c := (m[1][j]*c2)>>16 - (m[3][j]*c1)>>16
+		d := (m[1][j]*c1)>>16 + (m[3][j]*c2)>>16
+		row := pred[j*predStride : j*predStride+4]
+		dst[j*stride+0] = uint8(clamp255(int(int32(row[0]) + (a+d)>>3)))
+		dst[j*stride+1] = uint8(clamp255(int(int32(row[1]) + (b+c)>>3)))
+		dst[j*stride+2] = uint8(clamp255(int(int32(row[2]) + (b-c)>>3)))
+		dst[j*stride+3] = uint8(clamp255(int(int32(row[3]) + (a-d)>>3)))
+	}
+}
+
+// forwardWHT transforms the DC coefficients of the 16 luma blocks
+func forwardWHT(out, in *[16]int32) {
+	var tmp [16]int32
+	for i := 0; i < 4; i++ {
+		a0, a1 := in[i*4+0]+in[i*4+2], in[i*4+1]+in[i*4+3]
+		a2, a3 := in[i*4+1]-in[i*4+3], in[i*4+0]-in[i*4+2]
+		tmp[0+i*4] = a0 + a1
+		tmp[1+i*4] = a3 + a2
+		tmp[2+i*4] = a3 - a2
+		tmp[3+i*4] = a0 - a1
+	}
+	for i := 0; i < 4; i++ {
+		a0, a1 := tmp[0+i]+tmp[8+i], tmp[4+i]+tmp[12+i]
+		a2, a3 := tmp[4+i]-tmp[12+i], tmp[0+i]-tmp[8+i]
+		out[0+i] = (a0 + a1) >> 1
+		out[4+i] = (a3 + a2) >> 1
+		out[8+i] = (a3 - a2) >> 1
+		out[12+i] = (a0 - a1) >> 1
+	}
+}
+
+// inverseWHT recovers the DC coefficients of the 16 luma blocks, exactly as
+// the decoder does
+func inverseWHT(out, in *[16]int32) {
+	var m [16]int32
+	for i := 0; i < 4; i++ {
+		a0 := in[0+i] + in[12+i]
+		a1 := in[4+i] + in[8+i]
+		a2 := in[4+i] - in[8+i]
+		a3 := in[0+i] - in[12+i]
+		m[0+i] = a0 + a1
+		m[8+i] = a0 - a1
+		m[4+i] = a3 + a2
+		m[12+i] = a3 - a2
+	}
+	for i := 0; i < 4; i++ {
+		dc := m[0+i*4] + 3
+		a0 := dc + m[3+i*4]
+		a1 := m[1+i*4] + m[2+i*4]
+		a2 := m[1+i*4] - m[2+i*4]
+		a3 := dc - m[3+i*4]
+		out[i*4+0] = (a0 + a1) >> 3
+		out[i*4+1] = (a3 + a2) >> 3
+		out[i*4+2] = (a0 - a1) >> 3
+		out[i*4+3] = (a3 - a2) >> 3
+	}
+}
+
+// tokenWriter codes coefficient tokens, or only counts the branches taken
+// when enc is nil
+type tokenWriter struct {
+	enc   *boolEncoder
+	probs *[nPlanes][nBands][nContexts][nProbs]uint8
+	stats *[nPlanes][nBands][nContexts][nProbs][2]uint32
+}
+
+func (t *tokenWriter) put(bit bool, plane, band, ctx, i int) bool {
+	if t.enc == nil {
+		if bit {
+			t.stats[plane][band][ctx][i][1]++
+		} else {
+			t.stats[plane][band][ctx][i][0]++
+		}
+		return bit
+	}
+	return t.enc.putBit(bit, t.probs[plane][band][ctx][i])
+}
+
+func (t *tokenWriter) putFixed(bit bool, prob uint8) {
+	if t.enc != nil {
+		t.enc.putBit(bit, prob)
+	}
+}
+
+// putCoeffs codes the levels of one block from position first, in zigzag
+// order, and reports whether any token besides end of block was coded. This
+// mirrors the token tree of RFC 6386 section 13.2.
+func (t *tokenWriter) putCoeffs(plane, ctx int, levels []int16, first int) bool {
+	last := -1
+	for i := 15; i >= first; i-- {
+		if levels[i] != 0 {
+			last = i
+			break
+		}
+	}
+
+	band := int(coeffBands[first])
+	if !t.put(last >= 0, plane, band, ctx, probEOB) {
+		return false
+	}
+
+	for n := first; n < 16; {
+		v := int(levels[n])
+		n++
+		sign := v < 0
+		if sign {
+			v = -v
+		}
+
+		if !t.put(v != 0, plane, band, ctx, probZero) {
+			band, ctx = int(coeffBands[n]), 0
+			continue
+		}
+
+		if !t.put(v > 1, plane, band, ctx, probOne) {
+			band, ctx = int(coeffBands[n]), 1
+		} else {
+			switch {
+			case !t.put(v > 4, plane, band, ctx, 3):
+				if t.put(v != 2, plane, band, ctx, 4) {
+					t.put(v == 4, plane, band, ctx, 5)
+				}
+			case !t.put(v > 10, plane, band, ctx, 6):
+				if !t.put(v > 6, plane, band, ctx, 7) {
+					t.putFixed(v == 6, 159)
+				} else {
+					t.putFixed(v >= 9, 165)
+					t.putFixed(v&1 == 0, 145)
+				}
+			default:
+				var extra []uint8
+				switch {
+				case v < 3+8<<1:
+					t.put(false, plane, band, ctx, 8)
+					t.put(false, plane, band, ctx, 9)
+					v -= 3 + 8<<0
+					extra = cat3Probs
+				case v < 3+8<<2:
+					t.put(false, plane, band, ctx, 8)
+					t.put(true, plane, band, ctx, 9)
+					v -= 3 + 8<<1
+					extra = cat4Probs
+				case v < 3+8<<3:
+					t.put(true, plane, band, ctx, 8)
+					t.put(false, plane, band, ctx, 10)
+					v -= 3 + 8<<2
+					extra = cat5Probs
+				default:
+					t.put(true, plane, band, ctx, 8)
+					t.put(true, plane, band, ctx, 10)
+					v -= 3 + 8<<3
+					extra = cat6Probs
+				}
+				for i, prob := range extra {
+					t.putFixed(v>>(len(extra)-1-i)&1 == 1, prob)
+				}
+			}
+			band, ctx = int(coeffBands[n]), 2
+		}
+
+		t.putFixed(sign, 128)
+		if n == 16 || !t.put(n <= last, plane, band, ctx, probEOB) {
+			return true
+		}
+	}
+	return true
+}
+
+// putTokens codes the levels of all macroblocks, tracking which neighboring
+// blocks had coefficients to select the probability contexts
+func (e *vp8Encoder) putTokens(t *tokenWriter) {
+	type nzContext struct {
+		y    [4]bool
+		u, v [2]bool
+		y2   bool
+	}
+	top := make([]nzContext, e.mbw)
+
+	for mby := 0; mby < e.mbh; mby++ {
+		var left nzContext
+		for mbx := 0; mbx < e.mbw; mbx++ {
+			mb := &e.mbs[mby*e.mbw+mbx]
+			above := &top[mbx]
+			if mb.skip {
+				*above, left = nzContext{}, nzContext{}
+				continue
+			}
+
+			levels := e.levels[mb.levels : mb.levels+25*16]
+			nz := t.putCoeffs(planeY2, neighborContext(left.y2, above.y2), levels[:16], 0)
+			left.y2, above.y2 = nz, nz
+
+			for y := 0; y < 4; y++ {
+				for x := 0; x < 4; x++ {
+					block := levels[16+16*(y*4+x):]
+					nz := t.putCoeffs(planeY1WithY2, neighborContext(left.y[y], above.y[x]), block[:16], 1)
+					left.y[y], above.y[x] = nz, nz
+				}
+			}
+
+			for p, nzs := range [2][2]*[2]bool{{&left.u, &above.u}, {&left.v, &above.v}} {
+				l, a := nzs[0], nzs[1]
+				for y := 0; y < 2; y++ {
+					for x := 0; x < 2; x++ {
+						block := levels[17*16+(4*p+y*2+x)*16:]
+						nz := t.putCoeffs(planeUV, neighborContext(l[y], a[x]), block[:16], 0)
+						l[y], a[x] = nz, nz
+					}
+				}
+			}
+		}
+	}
+}
+
+// neighborContext returns the probability context from the neighbors' non-zero flags
+func neighborContext(left, above bool) int {
+	n := 0
+	if left {
+		n++
+	}
+	if above {
+		n++
+	}
+	return n
+}
+
+// optimizeProbs replaces the default coefficient probabilities where coding
+// the update costs fewer bits than it saves, and reports which were updated
+func (e *vp8Encoder) optimizeProbs() *[nPlanes][nBands][nContexts][nProbs]bool {
+	var stats [nPlanes][nBands][nContexts][nProbs][2]uint32
+	e.putTokens(&tokenWriter{probs: &e.probs, stats: &stats})
+
+	var updates [nPlanes][nBands][nContexts][nProbs]bool
+	for i := range stats {
+		for j := range stats[i] {
+			for k := range stats[i][j] {
+				for l, counts := range stats[i][j][k] {
+					total := counts[0] + counts[1]
+					if total == 0 {
+						continue
+					}
+					old := e.probs[i][j][k][l]
+					prob := uint8(max(1, min(255, (uint64(counts[0])*256+uint64(total)/2)/uint64(total))))
+					update := coeffUpdateProbs[i][j][k][l]
+					saving := branchCost(counts, old) - branchCost(counts, prob) -
+						(bitCost(true, update) + 8 - bitCost(false, update))
+					if saving > 0 {
+						e.probs[i][j][k][l] = prob
+						updates[i][j][k][l] = true
+					}
+				}
+			}
+		}
+	}
+	return &updates
+}
+
+// bitCost returns the number of bits needed to code bit with prob
+func bitCost(bit bool, prob uint8) float64 {
+	p := float64(prob) / 256
+	if bit {
+		p = 1 - p
+	}
+	return -math.Log2(p)
+}
+
+// branchCost returns the bits needed to code the counted zeros and ones with prob
+func branchCost(counts [2]uint32, prob uint8) float64 {
+	return float64(counts[0])*bitCost(false, prob) + float64(counts[1])*bitCost(true, prob)
+}
+
+// writeFirstPartition writes the frame header and the macroblock modes
+func (e *vp8Encoder) writeFirstPartition(updates *[nPlanes][nBands][nContexts][nProbs]bool) []byte {
+	b := newBoolEncoder()
+	b.putFlag(false) // Color space
+	b.putFlag(false) // Clamping required
+	b.putFlag(false) // No segmentation
+
+	b.putFlag(false) // Normal loop filter
+	b.putLiteral(uint32(e.qIndex/3), 6)
+	b.putLiteral(0, 3) // Sharpness
+	b.putFlag(false)   // No filter adjustments
+
+	b.putLiteral(0, 2) // One token partition
+
+	b.putLiteral(uint32(e.qIndex), 7)
+	for i := 0; i < 5; i++ {
+		b.putFlag(false) // No quantizer deltas
+	}
+
+	b.putFlag(false) // Refresh entropy probabilities
+	for i := range updates {
+		for j := range updates[i] {
+			for k := range updates[i][j] {
+				for l, update := range updates[i][j][k] {
+					if b.putBit(update, coeffUpdateProbs[i][j][k][l]) {
+						b.putLiteral(uint32(e.probs[i][j][k][l]), 8)
+					}
+				}
+			}
+		}
+	}
+
+	coded := 0
+	for _, mb := range e.mbs {
+		if !mb.skip {
+			coded++
+		}
+	}
+	skipProb := uint8(max(1, min(254, coded*255/len(e.mbs))))
+	b.putFlag(true) // Macroblocks may be skipped
+	b.putLiteral(uint32(skipProb), 8)
+
+	for _, mb := range e.mbs {
+		b.putBit(mb.skip, skipProb)
+		b.putBit(true, 145) // 16x16 luma prediction
+		switch mb.yMode {
+		case predDC:
+			b.putBit(false, 156)
+			b.putBit(false, 163)
+		case predVE:
+			b.putBit(false, 156)
+			b.putBit(true, 163)
+		case predHE:
+			b.putBit(true, 156)
+			b.putBit(false, 128)
+		case predTM:
+			b.putBit(true, 156)
+			b.putBit(true, 128)
+		}
+		switch mb.uvMode {
+		case predDC:
+			b.putBit(false, 142)
+		case predVE:
+			b.putBit(true, 142)
+			b.putBit(false, 114)
+		case predHE:
+			b.putBit(true, 142)
+			b.putBit(true, 114)
+			b.putBit(false, 183)
+		case predTM:
+			b.putBit(true, 142)
+			b.putBit(true, 114)
+			b.putBit(true, 183)
+		}
+	}
+
+	return b.bytes()
+}
+
+// writeTokenPartition writes the coefficient tokens of all macroblocks
+func (e *vp8Encoder) writeTokenPartition() []byte {
+	b := newBoolEncoder()
+	e.putTokens(&tokenWriter{enc: b, probs: &e.probs})
+	return b.bytes()
+}
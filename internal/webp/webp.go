@@ -0,0 +1,141 @@
+// Package webp implements a pure Go WebP encoder supporting both the lossless
+// (VP8L) and the lossy (VP8) formats, with alpha for either.
+package webp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+)
+
+// DefaultQuality is the lossy quality used when Options.Quality is zero
+const DefaultQuality = 75
+
+// maxDimension is the largest width or height a WebP image can have
+const maxDimension = 16383
+
+// Options are the encoding parameters
+type Options struct {
+	Lossless bool
+	Quality  int // Lossy quality from 1 to 100, ignored for lossless images
+}
+
+// Encode writes the image m to w in WebP format. A nil o encodes lossy images
+// at DefaultQuality.
+func Encode(w io.Writer, m image.Image, o *Options) error {
+	b := m.Bounds()
+	if b.Dx() < 1 || b.Dy() < 1 || b.Dx() > maxDimension || b.Dy() > maxDimension {
+		return fmt.Errorf("webp: invalid image size %dx%d (must be 1 to %d pixels)", b.Dx(), b.Dy(), maxDimension)
+	}
+
+	nrgba, ok := m.(*image.NRGBA)
+	if !ok {
+		nrgba = image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(nrgba, nrgba.Bounds(), m, b.Min, draw.Src)
+	}
+
+	var chunks []chunk
+	if o != nil && o.Lossless {
+		chunks = []chunk{{"VP8L", encodeVP8L(nrgba)}}
+	} else {
+		quality := DefaultQuality
+		if o != nil && o.Quality > 0 {
+			quality = min(o.Quality, 100)
+		}
+		vp8, err := encodeVP8(nrgba, quality)
+		if err != nil {
+			return err
+		}
+		if alpha := encodeAlpha(nrgba); alpha != nil {
+			chunks = []chunk{{"VP8X", extendedHeader(b.Dx(), b.Dy())}, {"ALPH", alpha}, {"VP8 ", vp8}}
+		} else {
+			chunks = []chunk{{"VP8 ", vp8}}
+		}
+	}
+
+	return writeRIFF(w, chunks)
+}
+
+// chunk is a RIFF chunk
+type chunk struct {
+	fourCC string
+	data   []byte
+}
+
+// writeRIFF writes the RIFF container of a WebP file. Chunks of odd length are
+// padded with a zero byte.
+func writeRIFF(w io.Writer, chunks []chunk) error {
+	size := 4 // "WEBP"
+	for _, c := range chunks {
+		size += 8 + len(c.data) + len(c.data)&1
+	}
+
+	// Chunk data is written in place rather than copied into one buffer
+	header := make([]byte, 0, 12)
+	header = append(header, "RIFF"...)
+	header = binary.LittleEndian.AppendUint32(header, uint32(size))
+	header = append(header, "WEBP"...)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		var h [8]byte
+		copy(h[:], c.fourCC)
+		binary.LittleEndian.PutUint32(h[4:], uint32(len(c.data)))
+		if _, err := w.Write(h[:]); err != nil {
+			return err
+		}
+		if _, err := w.Write(c.data); err != nil {
+			return err
+		}
+		if len(c.data)&1 == 1 {
+			if _, err := w.Write([]byte{0}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// extendedHeader returns the VP8X chunk data announcing an alpha channel
+func extendedHeader(width, height int) []byte {
+	const alphaFlag = 0x10
+	data := make([]byte, 10)
+	data[0] = alphaFlag
+	putUint24(data[4:], uint32(width-1))
+	putUint24(data[7:], uint32(height-1))
+	return data
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
+
+// encodeAlpha returns the ALPH chunk data of a lossy image, or nil if the
+// image is opaque. The alpha plane is compressed losslessly: it is stored in
+// the green channel of a VP8L stream without the stream header.
+func encodeAlpha(m *image.NRGBA) []byte {
+	w, h := m.Rect.Dx(), m.Rect.Dy()
+
+	opaque := true
+	plane := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			a := m.Pix[y*m.Stride+4*x+3]
+			if a != 0xff {
+				opaque = false
+			}
+			i := y*plane.Stride + 4*x
+			plane.Pix[i+1], plane.Pix[i+3] = a, 0xff
+		}
+	}
+	if opaque {
+		return nil
+	}
+
+	const losslessCompression = 1
+	vp8l := encodeVP8L(plane)
+	return append([]byte{losslessCompression}, vp8l[5:]...)
+}
@@ -0,0 +1,180 @@
+package webp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+
+	xwebp "golang.org/x/image/webp"
+)
+
+// testImage draws a screenshot-like image: a gradient background, flat
+// panels and noisy "text" rows, with an optional alpha ramp
+func testImage(width, height int, alpha bool) *image.NRGBA {
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBA{R: uint8(x * 255 / width), G: uint8(y * 255 / height), B: 128, A: 255}
+			if x > width/4 && x < width*3/4 && y > height/4 && y < height*3/4 {
+				c = color.NRGBA{R: 240, G: 240, B: 245, A: 255}
+				if y%8 < 5 && rng.Intn(3) == 0 {
+					c = color.NRGBA{R: 20, G: 20, B: 30, A: 255}
+				}
+			}
+			if alpha {
+				c.A = uint8(x * 255 / width)
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// paletteImage draws an image with only a few colors
+func paletteImage(width, height, colors int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := uint8((x/3 + y/5) % colors * 255 / max(colors-1, 1))
+			img.SetNRGBA(x, y, color.NRGBA{R: v, G: 255 - v, B: v / 2, A: 255})
+		}
+	}
+	return img
+}
+
+func encodeDecode(t *testing.T, img image.Image, o *Options) image.Image {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, o); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	decoded, err := xwebp.Decode(&buf)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if decoded.Bounds() != img.Bounds() {
+		t.Fatalf("decoded bounds = %v, want %v", decoded.Bounds(), img.Bounds())
+	}
+	return decoded
+}
+
+func TestEncodeLossless(t *testing.T) {
+	tests := []struct {
+		name string
+		img  *image.NRGBA
+	}{
+		{"single pixel", paletteImage(1, 1, 1)},
+		{"two colors", paletteImage(67, 29, 2)},
+		{"sixteen colors", paletteImage(100, 40, 16)},
+		{"palette", paletteImage(130, 70, 200)},
+		{"many colors", testImage(150, 90, false)},
+		{"alpha", testImage(64, 48, true)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded := encodeDecode(t, tt.img, &Options{Lossless: true})
+			b := tt.img.Bounds()
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					want := tt.img.NRGBAAt(x, y)
+					if got := color.NRGBAModel.Convert(decoded.At(x, y)); got != want {
+						t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeLossy(t *testing.T) {
+	tests := []struct {
+		name     string
+		img      *image.NRGBA
+		quality  int
+		minPSNR  float64
+		hasAlpha bool
+	}{
+		{"single pixel", paletteImage(1, 1, 1), 75, 40, false},
+		{"odd size", testImage(37, 21, false), 90, 30, false},
+		{"default quality", testImage(160, 96, false), 0, 25, false},
+		{"low quality", testImage(160, 96, false), 10, 18, false},
+		{"best quality", testImage(160, 96, false), 100, 40, false},
+		{"alpha", testImage(64, 48, true), 90, 30, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded := encodeDecode(t, tt.img, &Options{Quality: tt.quality})
+
+			var luma []uint8
+			var stride int
+			switch m := decoded.(type) {
+			case *image.YCbCr:
+				luma, stride = m.Y, m.YStride
+			case *image.NYCbCrA:
+				luma, stride = m.Y, m.YStride
+				if !tt.hasAlpha {
+					t.Errorf("decoded image has alpha")
+				}
+				for y := 0; y < tt.img.Rect.Dy(); y++ {
+					for x := 0; x < tt.img.Rect.Dx(); x++ {
+						if got, want := m.A[y*m.AStride+x], tt.img.NRGBAAt(x, y).A; got != want {
+							t.Fatalf("alpha (%d, %d) = %d, want %d", x, y, got, want)
+						}
+					}
+				}
+			default:
+				t.Fatalf("decoded image type = %T", decoded)
+			}
+			if _, ok := decoded.(*image.NYCbCrA); tt.hasAlpha && !ok {
+				t.Errorf("decoded image has no alpha")
+			}
+
+			// Compare the luma plane against the encoder's own conversion
+			var sum float64
+			w, h := tt.img.Rect.Dx(), tt.img.Rect.Dy()
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					c := tt.img.NRGBAAt(x, y)
+					want := (16839*int(c.R) + 33059*int(c.G) + 6420*int(c.B) + 1<<15 + 16<<16) >> 16
+					d := float64(int(luma[y*stride+x]) - want)
+					sum += d * d
+				}
+			}
+			psnr := math.Inf(1)
+			if sum > 0 {
+				psnr = 10 * math.Log10(255*255/(sum/float64(w*h)))
+			}
+			if psnr < tt.minPSNR {
+				t.Errorf("luma PSNR = %.1f dB, want >= %.0f", psnr, tt.minPSNR)
+			}
+		})
+	}
+}
+
+func TestEncodeQualityReducesSize(t *testing.T) {
+	img := testImage(256, 160, false)
+	size := func(quality int) int {
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, &Options{Quality: quality}); err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+		return buf.Len()
+	}
+	if low, high := size(20), size(95); low >= high {
+		t.Errorf("size at quality 20 = %d, want less than %d at quality 95", low, high)
+	}
+}
+
+func TestEncodeInvalidSize(t *testing.T) {
+	for _, r := range []image.Rectangle{image.Rect(0, 0, 0, 10), image.Rect(0, 0, 16384, 1)} {
+		if err := Encode(&bytes.Buffer{}, image.NewNRGBA(r), nil); err == nil {
+			t.Errorf("Encode(%v) error = nil, want error", r)
+		}
+	}
+}